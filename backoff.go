@@ -0,0 +1,102 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"context"
+	"time"
+)
+
+// Backoff computes exponentially growing retry deadlines in bounded time. As
+// the deadlines are UnixTime values derived from clockd rather than the
+// local wall clock, they are robust to clock corrections and can be compared
+// with other bounded timestamps. Backoff is not thread safe.
+type Backoff struct {
+	source   TimeSource
+	base     time.Duration
+	max      time.Duration
+	attempt  int
+	deadline UnixTime
+}
+
+// NewBackoff creates a new Backoff instance using the specified TimeSource,
+// the delay starts from base and doubles after each retry until it reaches
+// max.
+func NewBackoff(source TimeSource, base time.Duration, max time.Duration) *Backoff {
+	if base <= 0 || max < base {
+		panic("invalid backoff delay")
+	}
+	return &Backoff{
+		source: source,
+		base:   base,
+		max:    max,
+	}
+}
+
+// Next schedules the next retry and returns its deadline. The deadline is the
+// upper bound of the current time plus the backoff delay so the retry is
+// guaranteed to happen no earlier than the delay from now.
+func (b *Backoff) Next() (UnixTime, error) {
+	now, err := b.source.GetUnixTime()
+	if err != nil {
+		return UnixTime{}, err
+	}
+	_, upper := now.Bounds()
+	deadline := upper + uint64(b.delay())
+	b.attempt++
+	b.deadline = UnixTime{
+		Sec:  deadline / 1e9,
+		NSec: uint32(deadline % 1e9),
+	}
+
+	return b.deadline, nil
+}
+
+// NextDeadline returns the deadline of the retry scheduled by the last Next
+// call.
+func (b *Backoff) NextDeadline() UnixTime {
+	return b.deadline
+}
+
+// WaitContext blocks until the lower bound of the current time passes the
+// deadline of the scheduled retry or the context is done.
+func (b *Backoff) WaitContext(ctx context.Context) error {
+	_, upper := b.deadline.Bounds()
+	return waitUntil(ctx, b.source, upper)
+}
+
+// Reset resets the delay back to base and clears the scheduled deadline.
+func (b *Backoff) Reset() {
+	b.attempt = 0
+	b.deadline = UnixTime{}
+}
+
+func (b *Backoff) delay() time.Duration {
+	return backoffDelay(b.base, b.max, b.attempt)
+}
+
+// backoffDelay returns the delay of the specified attempt, it starts from
+// base and doubles after each attempt until it reaches max.
+func backoffDelay(base time.Duration, max time.Duration, attempt int) time.Duration {
+	if attempt >= 62 {
+		return max
+	}
+	d := base << attempt
+	if d <= 0 || d > max {
+		return max
+	}
+
+	return d
+}
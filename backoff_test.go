@@ -0,0 +1,114 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTimeSource returns the specified readings in order, the last one is
+// repeated once all others are consumed.
+type testTimeSource struct {
+	readings []UnixTime
+	// errs are returned together with the readings of the same index
+	errs  []error
+	reads int
+}
+
+func (s *testTimeSource) GetUnixTime() (UnixTime, error) {
+	idx := min(s.reads, len(s.readings)-1)
+	s.reads++
+	var err error
+	if idx < len(s.errs) {
+		err = s.errs[idx]
+	}
+	return s.readings[idx], err
+}
+
+func TestBackoffDeadlinesAdvance(t *testing.T) {
+	source := &testTimeSource{
+		readings: []UnixTime{{Sec: 100, Dispersion: 1e6}},
+	}
+	b := NewBackoff(source, 10*time.Millisecond, 40*time.Millisecond)
+	deadline := b.NextDeadline()
+	assert.True(t, deadline.IsEmpty())
+	expected := []uint64{
+		100011000000,
+		100021000000,
+		100041000000,
+		100041000000,
+	}
+	for idx, v := range expected {
+		deadline, err := b.Next()
+		require.NoError(t, err)
+		assert.Equal(t, deadline, b.NextDeadline(), idx)
+		lower, upper := deadline.Bounds()
+		assert.Equal(t, v, lower, idx)
+		assert.Equal(t, v, upper, idx)
+	}
+	b.Reset()
+	deadline = b.NextDeadline()
+	assert.True(t, deadline.IsEmpty())
+	deadline, err := b.Next()
+	require.NoError(t, err)
+	assert.Equal(t, UnixTime{Sec: 100, NSec: 11000000}, deadline)
+}
+
+func TestBackoffDelayDoesNotOverflow(t *testing.T) {
+	b := NewBackoff(&testTimeSource{}, time.Nanosecond, time.Hour)
+	for i := 0; i < 100; i++ {
+		d := b.delay()
+		assert.Greater(t, d, time.Duration(0))
+		assert.LessOrEqual(t, d, time.Hour)
+		b.attempt++
+	}
+}
+
+func TestBackoffWaitSurvivesClockStep(t *testing.T) {
+	source := &testTimeSource{
+		readings: []UnixTime{
+			// the reading from which the deadline is computed
+			{Sec: 100, NSec: 5000000},
+			// a backward step, the deadline is further away
+			{Sec: 100, NSec: 0, Dispersion: 1e6},
+			// the lower bound is still before the deadline
+			{Sec: 100, NSec: 10500000, Dispersion: 1e6},
+			{Sec: 100, NSec: 11500000, Dispersion: 1e6},
+		},
+	}
+	b := NewBackoff(source, 5*time.Millisecond, 5*time.Millisecond)
+	deadline, err := b.Next()
+	require.NoError(t, err)
+	assert.Equal(t, UnixTime{Sec: 100, NSec: 10000000}, deadline)
+	require.NoError(t, b.WaitContext(context.Background()))
+	assert.Equal(t, 4, source.reads)
+}
+
+func TestBackoffWaitIsCanceled(t *testing.T) {
+	source := &testTimeSource{
+		readings: []UnixTime{{Sec: 100}},
+	}
+	b := NewBackoff(source, time.Hour, time.Hour)
+	_, err := b.Next()
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, b.WaitContext(ctx))
+}
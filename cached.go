@@ -0,0 +1,146 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CachedClient serves bounded time from a snapshot periodically refreshed by
+// a background goroutine, trading freshness for lock free reads. It is thread
+// safe.
+type CachedClient struct {
+	client   *Client
+	interval time.Duration
+	snapshot atomic.Pointer[snapshot]
+	stopper  chan struct{}
+	wg       sync.WaitGroup
+}
+
+type snapshot struct {
+	coarse           time.Time
+	coarseDispersion time.Duration
+	// ut and err are the result of the refresh taken at the monotonic time
+	// refreshed
+	ut        UnixTime
+	err       error
+	refreshed time.Time
+	// last is the result of the latest successful refresh taken at the
+	// monotonic time lastRefreshed
+	last          UnixTime
+	lastRefreshed time.Time
+}
+
+// NewCachedClient creates a new CachedClient instance refreshing its snapshot
+// from the specified client every interval. The CachedClient takes over the
+// ownership of the client, which must not be used by the caller afterwards.
+func NewCachedClient(client *Client, interval time.Duration) *CachedClient {
+	if interval <= 0 {
+		panic("invalid refresh interval")
+	}
+	c := &CachedClient{
+		client:   client,
+		interval: interval,
+		stopper:  make(chan struct{}),
+	}
+	c.refresh()
+	c.wg.Add(1)
+	go c.run()
+
+	return c
+}
+
+// Close stops the background refresh and closes the underlying client.
+func (c *CachedClient) Close() error {
+	close(c.stopper)
+	c.wg.Wait()
+
+	return c.client.Close()
+}
+
+// CoarseNow returns the current time and its uncertainty derived from the
+// latest snapshot. It is a single atomic pointer load with no lock and no
+// syscall, suitable for millions of calls per second, e.g. for tagging every
+// log line. The price is precision, as the snapshot can be up to a refresh
+// interval old, the returned time is shifted forward by half the interval
+// and the uncertainty is widened by half the interval plus the clock
+// uncertainty accumulated over the interval. The bound doesn't hold if the
+// background refresh falls behind, e.g. when the process is descheduled for
+// longer than the interval. When the latest refresh failed, the time is
+// derived from the latest successful one advanced by the monotonic time
+// elapsed since, with the uncertainty widened by the clock uncertainty
+// accumulated over the elapsed time. The zero time is returned when no refresh
+// has succeeded yet.
+func (c *CachedClient) CoarseNow() (time.Time, time.Duration) {
+	s := c.snapshot.Load()
+	return s.coarse, s.coarseDispersion
+}
+
+// GetUnixTime returns the UnixTime of the latest snapshot advanced by the time
+// elapsed on the local monotonic clock since the snapshot was refreshed, with
+// the dispersion widened by the uncertainty accumulated over the elapsed time
+// according to the DriftModel of the client. Unlike CoarseNow, the returned
+// UnixTime is correct regardless of how far behind the background refresh is,
+// only its dispersion grows. The error of the refresh is returned as is, e.g.
+// ErrStopped once clockd stopped updating, together with the UnixTime when the
+// refresh returned one, e.g. with ErrDegraded. Reads take no lock and make no
+// syscall other than reading the monotonic clock.
+func (c *CachedClient) GetUnixTime() (UnixTime, error) {
+	s := c.snapshot.Load()
+	if s.ut.IsEmpty() {
+		return UnixTime{}, s.err
+	}
+	elapsed := time.Since(s.refreshed)
+	uct := c.client.cfg.driftModel.Uncertainty(int64(elapsed))
+	ut := s.ut.Add(elapsed).AddDispersion(uct)
+
+	return ut, s.err
+}
+
+func (c *CachedClient) run() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stopper:
+			return
+		case <-ticker.C:
+			c.refresh()
+		}
+	}
+}
+
+func (c *CachedClient) refresh() {
+	ut, err := c.client.GetUnixTime()
+	s := &snapshot{ut: ut, err: err, refreshed: time.Now()}
+	if err == nil {
+		s.last, s.lastRefreshed = ut, s.refreshed
+	} else if prev := c.snapshot.Load(); prev != nil {
+		s.last, s.lastRefreshed = prev.last, prev.lastRefreshed
+	}
+	if !s.last.IsEmpty() {
+		model := c.client.cfg.driftModel
+		elapsed := s.refreshed.Sub(s.lastRefreshed)
+		base := s.last.Add(elapsed).AddDispersion(model.Uncertainty(int64(elapsed)))
+		half := c.interval / 2
+		uct := model.Uncertainty(int64(c.interval))
+		s.coarse = base.ToTime().Add(half)
+		s.coarseDispersion = base.AddDispersion(uct).DispersionDuration() + half
+	}
+	c.snapshot.Store(s)
+}
@@ -0,0 +1,162 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedClientCoarseNow(t *testing.T) {
+	d := newTestClockd(t)
+	info := lockedInfo(1, 1000)
+	d.publish(info)
+	interval := 10 * time.Millisecond
+	c := NewCachedClient(d.newClient(), interval)
+	defer func() {
+		assert.NoError(t, c.Close())
+	}()
+
+	now, dispersion := c.CoarseNow()
+	assert.False(t, now.IsZero())
+	ref := time.Unix(int64(info.Sec), int64(info.NSec))
+	assert.True(t, now.After(ref))
+	assert.GreaterOrEqual(t, dispersion, interval/2+time.Duration(info.Dispersion))
+	// the actual time is covered by the reported interval
+	assert.True(t, time.Now().Before(now.Add(dispersion)))
+}
+
+func TestCachedClientCoarseNowWhenNotReady(t *testing.T) {
+	d := newTestClockd(t)
+	info := lockedInfo(1, 1000)
+	info.Locked = false
+	d.publish(info)
+	c := NewCachedClient(d.newClient(), time.Millisecond)
+	defer func() {
+		assert.NoError(t, c.Close())
+	}()
+
+	now, dispersion := c.CoarseNow()
+	assert.True(t, now.IsZero())
+	assert.Equal(t, time.Duration(0), dispersion)
+}
+
+func TestCachedClientCoarseNowAfterFailedRefresh(t *testing.T) {
+	d := newTestClockd(t)
+	d.publish(lockedInfo(1, 1000))
+	interval := 10 * time.Millisecond
+	model := AffineDriftModel{Base: uint64(time.Millisecond), PPB: MaxClockDrift}
+	c := NewCachedClient(d.newClient(WithDriftModel(model)), interval)
+	defer func() {
+		assert.NoError(t, c.Close())
+	}()
+
+	d.publish(ClientInfo{})
+	require.Eventually(t, func() bool {
+		_, err := c.GetUnixTime()
+		return err != nil
+	}, time.Second, time.Millisecond)
+
+	// the latest successful refresh is still used, widened by the model for
+	// both the time elapsed since and the interval
+	now, dispersion := c.CoarseNow()
+	assert.False(t, now.IsZero())
+	last := c.snapshot.Load().last
+	assert.GreaterOrEqual(t, dispersion,
+		last.DispersionDuration()+2*time.Millisecond+interval/2)
+	assert.True(t, time.Now().Before(now.Add(dispersion)))
+}
+
+func TestCachedClientGetUnixTime(t *testing.T) {
+	d := newTestClockd(t)
+	info := lockedInfo(1, 1000)
+	d.publish(info)
+	c := NewCachedClient(d.newClient(), time.Hour)
+	defer func() {
+		assert.NoError(t, c.Close())
+	}()
+
+	ut1, err := c.GetUnixTime()
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	ut2, err := c.GetUnixTime()
+	require.NoError(t, err)
+	// the snapshot is advanced by the elapsed time with a growing dispersion
+	assert.GreaterOrEqual(t, ut2.Sub(ut1), int64(10*time.Millisecond))
+	assert.Greater(t, ut2.Dispersion, ut1.Dispersion)
+}
+
+func TestCachedClientGetUnixTimeUsesDriftModel(t *testing.T) {
+	d := newTestClockd(t)
+	d.publish(lockedInfo(1, 1000))
+	model := AffineDriftModel{Base: uint64(time.Second)}
+	c := NewCachedClient(d.newClient(WithDriftModel(model)), time.Hour)
+	defer func() {
+		assert.NoError(t, c.Close())
+	}()
+
+	ut, err := c.GetUnixTime()
+	require.NoError(t, err)
+	// widened by the constant error of the model on top of the snapshot
+	s := c.snapshot.Load()
+	assert.GreaterOrEqual(t, ut.Dispersion, s.ut.Dispersion+uint64(time.Second))
+}
+
+func TestCachedClientGetUnixTimeReportsErrors(t *testing.T) {
+	d := newTestClockd(t)
+	info := lockedInfo(1, 1000)
+	info.Locked = false
+	d.publish(info)
+	c := NewCachedClient(d.newClient(), time.Hour)
+	defer func() {
+		assert.NoError(t, c.Close())
+	}()
+
+	ut, err := c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrNotLocked)
+	assert.True(t, ut.IsEmpty())
+}
+
+func TestCachedClientCoarseNowDoesNotAllocate(t *testing.T) {
+	d := newTestClockd(t)
+	d.publish(lockedInfo(1, 1000))
+	c := NewCachedClient(d.newClient(), time.Hour)
+	defer func() {
+		assert.NoError(t, c.Close())
+	}()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		c.CoarseNow()
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func BenchmarkCachedClientCoarseNow(b *testing.B) {
+	d := newTestClockd(b)
+	d.publish(lockedInfo(1, 1000))
+	c := NewCachedClient(d.newClient(), time.Hour)
+	defer func() {
+		_ = c.Close()
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.CoarseNow()
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrSegmentInUse indicates that CleanupStale refused to remove clockd's
+// shared memory segment because some process is still attached to it.
+var ErrSegmentInUse = errors.New("shared memory segment in use")
+
+// CleanupStale removes the semaphore named lockPath and the shared memory
+// segment identified by shmKey left behind by a clockd that is gone, e.g.
+// after a crash. It is intended for recovery tooling.
+//
+// The caller must own both objects: neither clockd nor any Client should be
+// using them. The segment is only removed when no process is attached to it,
+// otherwise ErrSegmentInUse is returned and nothing is removed, as both clockd
+// and its clients keep the segment attached while running. Objects that don't
+// exist are ignored.
+func CleanupStale(lockPath string, shmKey int) error {
+	if err := removeStaleSegment(shmKey); err != nil {
+		return fmt.Errorf("failed to remove segment %d: %w", shmKey, err)
+	}
+	sem := &Semaphore{name: lockPath}
+	if err := sem.Unlink(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to unlink semaphore %s: %w", lockPath, err)
+	}
+
+	return nil
+}
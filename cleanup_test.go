@@ -0,0 +1,83 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package thymef
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newStaleClockd creates a semaphore and a segment the way clockd does, but
+// leaves it to the test to clean them up.
+func newStaleClockd(t *testing.T) (string, int, *segment) {
+	n := int(testClockdInstance.Add(1))
+	pid := os.Getpid()
+	lockPath := fmt.Sprintf("thymef.test.%d.%d.lock", pid, n)
+	shmKey := 0x7f000000 | (pid&0xffff)<<8 | n&0xff
+	m, err := NewSemaphore(lockPath, 0600, 1)
+	require.NoError(t, err)
+	require.NoError(t, m.Close())
+	seg, err := openSegment(shmKey, ClientInfoSharedMemoryBufferSize)
+	require.NoError(t, err)
+
+	return lockPath, shmKey, seg
+}
+
+func TestCleanupOnClose(t *testing.T) {
+	lockPath, shmKey, seg := newStaleClockd(t)
+	defer func() {
+		assert.NoError(t, seg.detach())
+		assert.NoError(t, seg.remove())
+	}()
+	c, err := NewClient(lockPath, shmKey, WithCleanupOnClose())
+	require.NoError(t, err)
+	require.NoError(t, c.Close())
+	err = (&Semaphore{name: lockPath}).Unlink()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestCloseKeepsSemaphoreByDefault(t *testing.T) {
+	d := newTestClockd(t)
+	c, err := NewClient(d.lockPath, d.shmKey)
+	require.NoError(t, err)
+	require.NoError(t, c.Close())
+	// the cleanup registered by newTestClockd fails when the semaphore is gone
+}
+
+func TestCleanupStale(t *testing.T) {
+	lockPath, shmKey, seg := newStaleClockd(t)
+	seg.data[0] = 1
+
+	err := CleanupStale(lockPath, shmKey)
+	assert.ErrorIs(t, err, ErrSegmentInUse)
+
+	require.NoError(t, seg.detach())
+	require.NoError(t, CleanupStale(lockPath, shmKey))
+	err = (&Semaphore{name: lockPath}).Unlink()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	seg, err = openSegment(shmKey, ClientInfoSharedMemoryBufferSize)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0), seg.data[0])
+	require.NoError(t, seg.detach())
+
+	// cleaning up objects that no longer exist is not an error
+	require.NoError(t, CleanupStale(lockPath, shmKey))
+}
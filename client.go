@@ -15,129 +15,617 @@
 package thymef
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/bits"
 	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/gen2brain/shm"
+	"unsafe"
 )
 
 const (
-	// Path of the lock file.
+	// Path of the lock file. All applications using the default lock path and
+	// shm key on the same host share the same kernel objects, use Namespace
+	// to derive distinct ones when multiple clockd instances are deployed.
 	DefaultLockPath string = "clockd.client.lock"
 	// Key used for shared memory communication with clockd.
 	DefaultShmKey int = 55356
-	// buffer size of the shared memory.
+	// default buffer size of the shared memory, see WithBufferSize.
 	ClientInfoSharedMemoryBufferSize int   = 48
 	staleThresholdNanoseconds        int64 = 300000000
+	// ClientInfoMagic is the magic number leading the marshaled ClientInfo
+	// record.
+	ClientInfoMagic uint16 = 0x5446
+	// ClientInfoVersion is the version of the ClientInfo record layout. The
+	// version byte of records encoded in little endian byte order also has the
+	// littleEndianVersionFlag bit set.
+	ClientInfoVersion uint8 = 1
+	// littleEndianVersionFlag is set in the version byte of records encoded in
+	// little endian byte order
+	littleEndianVersionFlag uint8 = 0x80
+	// clientInfoSize is the size of the marshaled ClientInfo record
+	clientInfoSize int = 32
+	// legacyClientInfoSize is the size of the record published in the legacy
+	// unversioned layout
+	legacyClientInfoSize int = 24
+	// seqOffset is the offset of the Seq field in the shared memory region,
+	// it is 4 bytes aligned so the field can be atomically accessed
+	seqOffset = 8
+	// seqlockRetries is the max number of attempts made to read a consistent
+	// record without the semaphore
+	seqlockRetries            = 1000
+	newReadingPollInterval    = time.Millisecond
+	defaultReconnectBaseDelay = 10 * time.Millisecond
+	defaultReconnectMaxDelay  = time.Second
+	// defaultFallbackDispersion is deliberately huge, a fallback reading taken
+	// from the unsynchronized local clock must not imply any precision
+	defaultFallbackDispersion = time.Minute
+	// defaultSemaphoreMode is the permission mode of the semaphore created by
+	// the client when clockd's semaphore doesn't exist yet
+	defaultSemaphoreMode = 0600
+	// interval at which the context is checked when waiting for the semaphore
+	semaphorePollInterval = 10 * time.Millisecond
 )
 
 var (
-	// Encoder used for content stored in the shared memory region.
+	// Encoder used for content stored in the shared memory region, it is the
+	// default byte order, see WithByteOrder.
 	Encoder = binary.BigEndian
 )
 
 var (
-	// ErrNotReady indicates that clockd is not ready yet.
+	// ErrNotReady indicates that clockd is not ready yet. Use errors.Is to check
+	// for it as more specific errors are returned to tell the causes apart.
 	ErrNotReady = errors.New("bounded time service not ready")
+	// ErrUninitializedSegment indicates that clockd has never written to the
+	// shared memory segment. It is an ErrNotReady.
+	ErrUninitializedSegment = fmt.Errorf("%w: segment not initialized",
+		ErrNotReady)
+	// ErrNeverReady is an alias of ErrUninitializedSegment, it indicates that
+	// clockd has never published anything, as opposed to ErrNotLocked which
+	// indicates that clockd published a record but is not or no longer locked.
+	// Startup probes can wait out ErrNeverReady while liveness probes treat
+	// other ErrNotReady errors as failures. It is an ErrNotReady.
+	ErrNeverReady = ErrUninitializedSegment
+	// ErrNotLocked indicates that clockd's record was published but the clock
+	// is not valid or not locked yet. It is an ErrNotReady.
+	ErrNotLocked = fmt.Errorf("%w: clock not locked", ErrNotReady)
+	// ErrNotTrusted indicates that the client hasn't observed enough
+	// consecutive consistent records to trust clockd, see
+	// WithMinReadsBeforeTrust. It is an ErrNotReady.
+	ErrNotTrusted = fmt.Errorf("%w: clock not trusted yet", ErrNotReady)
+	// ErrBusy indicates that clockd's semaphore is currently held by someone
+	// else, see WithTryLock. It is an ErrNotReady.
+	ErrBusy = fmt.Errorf("%w: semaphore busy", ErrNotReady)
 	// ErrStopped indicates that clockd unexpectedly stopped, e.g. crashed.
 	ErrStopped = errors.New("bounded time service stopped")
+	// ErrCorruptData indicates that the content of the shared memory region is
+	// not a valid ClientInfo record, e.g. a torn or out of range datalen, or a
+	// NSec value not in the [0, 1e9) range.
+	ErrCorruptData = errors.New("bounded time service data corrupted")
+	// ErrInvalidLength indicates that the buffer or the data provided for
+	// marshaling or unmarshaling a ClientInfo record has an invalid length. It
+	// is an ErrCorruptData.
+	ErrInvalidLength = fmt.Errorf("%w: invalid record length", ErrCorruptData)
+	// ErrVersionMismatch indicates that clockd's record doesn't carry the
+	// expected magic number and layout version, e.g. clockd and the client are
+	// built against incompatible versions, see also WithLegacyLayout.
+	ErrVersionMismatch = errors.New("bounded time service version mismatch")
+	// ErrImplausibleReading indicates that clockd published a reading that can
+	// not be physically true, e.g. a locked clock with zero dispersion. It is
+	// only reported in strict mode.
+	ErrImplausibleReading = errors.New("bounded time service reading implausible")
+	// ErrDegraded indicates that clockd's reference hasn't advanced for longer
+	// than the configured degraded threshold. It is returned together with a
+	// valid UnixTime which is still safe to use.
+	ErrDegraded = errors.New("bounded time service degraded")
+	// ErrDispersionTooLarge indicates that the dispersion of the reading exceeds
+	// the configured max usable dispersion. It is returned together with the
+	// UnixTime, which is still correct but likely too wide to be useful.
+	ErrDispersionTooLarge = errors.New("bounded time dispersion too large")
+	// ErrClockInversion indicates that the local clock is behind the reference
+	// published by clockd, e.g. the two clocks momentarily disagree. It is
+	// transient and it is an ErrNotReady.
+	ErrClockInversion = fmt.Errorf("%w: clock inversion", ErrNotReady)
+	// ErrNoSegment indicates that clockd's shared memory segment doesn't exist,
+	// e.g. clockd is not running or the shm key is wrong. It is only reported
+	// in strict attach mode, see WithStrictAttach.
+	ErrNoSegment = errors.New("bounded time service segment not found")
+	// ErrNoSemaphore indicates that clockd's semaphore doesn't exist, e.g.
+	// clockd is not running or the lock path is wrong. It is only reported in
+	// strict attach mode, see WithStrictAttach.
+	ErrNoSemaphore = errors.New("bounded time service semaphore not found")
+	// ErrSegmentSizeMismatch indicates that the size of clockd's shared memory
+	// segment is not the expected buffer size. It is only reported in strict
+	// attach mode, see WithStrictAttach.
+	ErrSegmentSizeMismatch = errors.New("bounded time service segment size mismatch")
+	// ErrPermission indicates that the process is not allowed to attach
+	// clockd's shared memory segment, e.g. clockd runs as a different user and
+	// created the segment with a restrictive mode. The error message carries
+	// the owner, creator and mode of the segment when they can be queried.
+	ErrPermission = errors.New("bounded time service segment permission denied")
+	// ErrClosed indicates that the client has been closed, see Client.Close.
+	ErrClosed = errors.New("bounded time service client closed")
 )
 
+// StoppedError is the error returned when clockd is considered as stopped
+// because the Count it publishes has been frozen for longer than the stale
+// threshold. It is an ErrStopped.
+type StoppedError struct {
+	// Count is the Count observed by the read that reported the error.
+	Count uint16
+	// LastCount is the Count observed when it was last seen changing.
+	LastCount uint16
+	// Frozen is how long the Count has been frozen.
+	Frozen time.Duration
+}
+
+var _ error = (*StoppedError)(nil)
+
+func (e *StoppedError) Error() string {
+	return fmt.Sprintf("%s: count %d frozen for %s",
+		ErrStopped.Error(), e.Count, e.Frozen)
+}
+
+// Unwrap returns ErrStopped so errors.Is(err, ErrStopped) is true.
+func (e *StoppedError) Unwrap() error {
+	return ErrStopped
+}
+
+// ReadError is the error returned by reads failing with an ErrNotReady or an
+// ErrStopped, it records how long the failing read took and the last Count
+// observed by the client, so a single log line tells whether the read failed
+// fast, e.g. clockd never published anything, or slowly, e.g. contention on
+// clockd's semaphore, and whether any fresh record was ever observed. Use
+// errors.Is against the sentinel errors, errors.As still finds a wrapped
+// StoppedError.
+type ReadError struct {
+	// Err is the error of the read, e.g. ErrNotLocked or a StoppedError.
+	Err error
+	// Latency is how long the failing read took.
+	Latency time.Duration
+	// LastCount is the last Count observed by the client, it is only
+	// meaningful when Seen is true.
+	LastCount uint16
+	// Seen indicates whether the client has ever observed a Count published
+	// by clockd.
+	Seen bool
+}
+
+var _ error = (*ReadError)(nil)
+
+func (e *ReadError) Error() string {
+	if !e.Seen {
+		return fmt.Sprintf("%s (read took %s, no count observed)",
+			e.Err.Error(), e.Latency)
+	}
+	return fmt.Sprintf("%s (read took %s, last count %d)",
+		e.Err.Error(), e.Latency, e.LastCount)
+}
+
+// Unwrap returns the error of the read.
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
 // ClientInfo contains details exposed by clockd. Applications shouldn't be
-// accessing any fields. All fields are in Unix time.
+// accessing any fields other than for diagnostics, see Client.DebugInfo. All
+// fields are in Unix time.
 type ClientInfo struct {
-	Valid      bool
-	Locked     bool
-	Count      uint16
-	Dispersion uint64
-	Sec        uint64
-	NSec       uint32
+	Valid      bool   `json:"valid"`
+	Locked     bool   `json:"locked"`
+	Count      uint16 `json:"count"`
+	Dispersion uint64 `json:"dispersion"`
+	Sec        uint64 `json:"sec"`
+	NSec       uint32 `json:"nsec"`
+	// Seq is the seqlock sequence number, clockd makes it odd before updating
+	// the record and even again once the update is done, see WithSeqlock. It
+	// is always 0 for records published in the legacy layout.
+	Seq uint32 `json:"seq"`
+	// LeapState is the leap second status, it is always LeapNone for records
+	// published in the legacy layout.
+	LeapState LeapState `json:"leap_state"`
 }
 
+// Marshal marshals the ClientInfo record into buf, which is expected to
+// follow the 2 bytes datalen prefix in the shared memory region, using the
+// Encoder byte order. The record starts with ClientInfoMagic and
+// ClientInfoVersion, the LeapState occupies what used to be a padding byte
+// and the Seq field is placed so it is 4 bytes aligned in the region.
+// ErrInvalidLength is returned when buf is too small.
 func (c *ClientInfo) Marshal(buf []byte) ([]byte, error) {
-	if len(buf) < 24 {
-		panic("invalid buffer length")
-	}
+	return c.MarshalByteOrder(buf, Encoder)
+}
 
-	if c.Valid {
-		buf[0] = 1
-	} else {
-		buf[0] = 0
-	}
-	if c.Locked {
-		buf[1] = 1
-	} else {
-		buf[1] = 0
+// MarshalByteOrder is similar to Marshal, but the record is encoded in the
+// specified byte order, which is also advertised in the version byte so
+// clients expecting a different byte order fail with ErrVersionMismatch.
+func (c *ClientInfo) MarshalByteOrder(buf []byte,
+	order binary.ByteOrder) ([]byte, error) {
+	if len(buf) < clientInfoSize {
+		return nil, ErrInvalidLength
 	}
 
-	Encoder.PutUint16(buf[2:], c.Count)
-	Encoder.PutUint64(buf[4:], c.Dispersion)
-	Encoder.PutUint64(buf[12:], c.Sec)
-	Encoder.PutUint32(buf[20:], c.NSec)
+	order.PutUint16(buf, ClientInfoMagic)
+	buf[2] = versionByte(order)
+	buf[3] = boolToByte(c.Valid)
+	buf[4] = boolToByte(c.Locked)
+	buf[5] = uint8(c.LeapState)
+	order.PutUint32(buf[6:], c.Seq)
+	order.PutUint16(buf[10:], c.Count)
+	order.PutUint64(buf[12:], c.Dispersion)
+	order.PutUint64(buf[20:], c.Sec)
+	order.PutUint32(buf[28:], c.NSec)
 
-	return buf[:24], nil
+	return buf[:clientInfoSize], nil
 }
 
+// UnmarshalClientInfo unmarshals the ClientInfo record encoded in the Encoder
+// byte order. ErrInvalidLength is returned when data has an invalid length,
+// ErrVersionMismatch is returned when the record doesn't start with the
+// expected magic number and version, ErrCorruptData is returned when the leap
+// state is unknown.
 func UnmarshalClientInfo(data []byte, c *ClientInfo) error {
-	if len(data) != 24 {
-		panic("invalid input")
+	return UnmarshalClientInfoByteOrder(data, c, Encoder)
+}
+
+// UnmarshalClientInfoByteOrder is similar to UnmarshalClientInfo, but the
+// record is expected to be encoded in the specified byte order.
+func UnmarshalClientInfoByteOrder(data []byte,
+	c *ClientInfo, order binary.ByteOrder) error {
+	if len(data) != clientInfoSize {
+		return ErrInvalidLength
 	}
-	c.Valid = false
-	if data[0] == 1 {
-		c.Valid = true
+	if order.Uint16(data) != ClientInfoMagic || data[2] != versionByte(order) {
+		return ErrVersionMismatch
 	}
-	c.Locked = false
-	if data[1] == 1 {
-		c.Locked = true
+	if !LeapState(data[5]).valid() {
+		return fmt.Errorf("%w: unknown leap state %d", ErrCorruptData, data[5])
 	}
-	c.Count = Encoder.Uint16(data[2:])
-	c.Dispersion = Encoder.Uint64(data[4:])
-	c.Sec = Encoder.Uint64(data[12:])
-	c.NSec = Encoder.Uint32(data[20:])
+	c.Valid = data[3] == 1
+	c.Locked = data[4] == 1
+	c.LeapState = LeapState(data[5])
+	c.Seq = order.Uint32(data[6:])
+	c.Count = order.Uint16(data[10:])
+	c.Dispersion = order.Uint64(data[12:])
+	c.Sec = order.Uint64(data[20:])
+	c.NSec = order.Uint32(data[28:])
 
 	return nil
 }
 
+// UnmarshalLegacyClientInfo unmarshals the ClientInfo record published in the
+// legacy unversioned layout, which has no magic number, version or Seq field
+// and is always encoded in big endian byte order. ErrInvalidLength is
+// returned when data has an invalid length.
+func UnmarshalLegacyClientInfo(data []byte, c *ClientInfo) error {
+	if len(data) != legacyClientInfoSize {
+		return ErrInvalidLength
+	}
+	c.Valid = data[0] == 1
+	c.Locked = data[1] == 1
+	c.Seq = 0
+	c.LeapState = LeapNone
+	c.Count = binary.BigEndian.Uint16(data[2:])
+	c.Dispersion = binary.BigEndian.Uint64(data[4:])
+	c.Sec = binary.BigEndian.Uint64(data[12:])
+	c.NSec = binary.BigEndian.Uint32(data[20:])
+
+	return nil
+}
+
+// versionByte returns the version byte of records encoded in the specified
+// byte order.
+func versionByte(order binary.ByteOrder) uint8 {
+	if isLittleEndian(order) {
+		return ClientInfoVersion | littleEndianVersionFlag
+	}
+
+	return ClientInfoVersion
+}
+
+// nativeLittleEndian indicates whether the host is little endian
+var nativeLittleEndian = probeLittleEndian(binary.NativeEndian)
+
+// isLittleEndian returns a boolean value indicating whether the specified
+// byte order is little endian, well known byte orders are identified without
+// allocation.
+func isLittleEndian(order binary.ByteOrder) bool {
+	switch order {
+	case binary.LittleEndian:
+		return true
+	case binary.BigEndian:
+		return false
+	case binary.NativeEndian:
+		return nativeLittleEndian
+	}
+
+	return probeLittleEndian(order)
+}
+
+func probeLittleEndian(order binary.ByteOrder) bool {
+	var b [2]byte
+	order.PutUint16(b[:], 1)
+	return b[0] == 1
+}
+
+func boolToByte(v bool) byte {
+	if v {
+		return 1
+	}
+
+	return 0
+}
+
+// MustUnmarshalClientInfo is similar to UnmarshalClientInfo, but it panics
+// when data is invalid. It is intended for already validated data.
+func MustUnmarshalClientInfo(data []byte, c *ClientInfo) {
+	if err := UnmarshalClientInfo(data, c); err != nil {
+		panic(err)
+	}
+}
+
+// loadSeq atomically loads the Seq field encoded in the specified byte order
+// from the shared memory region.
+func loadSeq(region []byte, order binary.ByteOrder) uint32 {
+	v := atomic.LoadUint32((*uint32)(unsafe.Pointer(&region[seqOffset])))
+	if isLittleEndian(order) != nativeLittleEndian {
+		return bits.ReverseBytes32(v)
+	}
+
+	return v
+}
+
+// storeSeq atomically stores the Seq field encoded in the specified byte order
+// into the shared memory region.
+func storeSeq(region []byte, seq uint32, order binary.ByteOrder) {
+	v := seq
+	if isLittleEndian(order) != nativeLittleEndian {
+		v = bits.ReverseBytes32(seq)
+	}
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(&region[seqOffset])), v)
+}
+
 // Client is the client used to get current bounded time. It is not thread safe
 // meaning you shouldn't be using the same client concurrently from multiple
-// threads.
+// threads, unless it is created by NewSyncClient. See Clone for creating one
+// client per goroutine.
 type Client struct {
+	// mu is only used when the client is created by NewSyncClient
+	mu       sync.Mutex
+	synced   bool
 	lockPath string
 	shmKey   int
 	buf      []byte
 	data     []byte
 	mutex    *Semaphore
-	shmID    int
+	seg      *segment
+	cfg      config
+	// afterCopy is invoked right after copying the shared memory region when
+	// set, it is used for simulating slow copies in tests
+	afterCopy func()
 
 	last struct {
 		count uint16
-		time  UnixTime
+		// mono is the monotonic reading taken when count was last seen
+		// changing, see Client.monotonic
+		mono int64
+		// advanced is set when the last read observed a new Count
+		advanced bool
+	}
+	onUpdate     func(UnixTime)
+	onReconnect  func(error)
+	onDisconnect func(error)
+	// events are the disconnect and reconnect observed by the read in
+	// progress, they are set to the error that required the reset and are
+	// reported once the client is unlocked
+	events struct {
+		disconnected error
+		reconnected  error
+	}
+	history history
+	// info and sample are scratch space reused across GetUnixTime calls, they
+	// are only accessed with the client locked
+	info   ClientInfo
+	sample UnixTime
+	// lastLatency is the duration of the last read, see LastReadLatency
+	lastLatency time.Duration
+	// decoded is the last record successfully decoded, see DebugInfo
+	decoded struct {
+		ok   bool
+		info ClientInfo
+	}
+	// latest is the latest reading returned by the client
+	latest UnixTime
+	state  State
+	trust  struct {
+		trusted bool
+		reads   int
+		prev    ClientInfo
 	}
 
+	// reconnect tracks reset attempts made since the last successful read
+	reconnect struct {
+		attempts int
+		next     time.Time
+		err      error
+		// cause is the error that required the pending reset
+		cause error
+	}
 	resetRequired bool
+	closed        bool
+}
+
+// NewClient creates a new Client instance for the clockd instance identified
+// by the specified lock path and shm key. It is equivalent to calling
+// NewClientWithOptions with WithLockPath and WithShmKey appended to opts.
+func NewClient(lockPath string, shmKey int, opts ...Option) (*Client, error) {
+	opts = append(opts[:len(opts):len(opts)],
+		WithLockPath(lockPath), WithShmKey(shmKey))
+	return NewClientWithOptions(opts...)
+}
+
+// NewClientWithOptions creates a new Client instance configured by the
+// specified options. DefaultLockPath and DefaultShmKey are used unless
+// WithLockPath and WithShmKey are specified.
+func NewClientWithOptions(opts ...Option) (*Client, error) {
+	cfg := config{
+		lockPath:           DefaultLockPath,
+		shmKey:             DefaultShmKey,
+		maxClockDrift:      MaxClockDrift,
+		staleThreshold:     time.Duration(staleThresholdNanoseconds),
+		byteOrder:          Encoder,
+		bufferSize:         ClientInfoSharedMemoryBufferSize,
+		logger:             discardLogger,
+		reconnectBase:      defaultReconnectBaseDelay,
+		reconnectMax:       defaultReconnectMaxDelay,
+		fallbackDispersion: defaultFallbackDispersion,
+		historySize:        defaultHistorySize,
+		semaphoreMode:      defaultSemaphoreMode,
+		metrics:            NopMetricsObserver{},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.driftModel == nil {
+		cfg.driftModel = LinearDriftModel(cfg.maxClockDrift)
+	}
+
+	return newClient(cfg)
 }
 
-// NewClient creates a new Client instance.
-func NewClient(lockPath string, shmKey int) (*Client, error) {
+// newClient creates a new Client instance with the specified validated
+// config.
+func newClient(cfg config) (*Client, error) {
 	c := &Client{
-		lockPath: lockPath,
-		shmKey:   shmKey,
-		buf:      make([]byte, ClientInfoSharedMemoryBufferSize),
+		lockPath: cfg.lockPath,
+		shmKey:   cfg.shmKey,
+		buf:      make([]byte, cfg.bufferSize),
+		history:  newHistory(cfg.historySize),
+		cfg:      cfg,
+	}
+	err := reset(c)
+	c.logReset(err)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Clone creates a new Client instance attached to the same clockd instance
+// with the same options. The clone reopens clockd's semaphore and reattaches
+// the shared memory segment, it has its own buffer and its own state for
+// detecting a stopped clockd, nothing mutable is shared with c. Closing the
+// clone doesn't affect c and vice versa, WithCleanupOnClose is not inherited
+// so closing the clone never unlinks the semaphore still used by c. Callbacks
+// set by options, e.g. WithStateChangeCallback or WithLockRecovery, are
+// inherited as any other option, the same callback is then invoked by both
+// clients, possibly concurrently. Callbacks registered on c by OnUpdate,
+// OnReconnect or OnDisconnect are not inherited.
+//
+// Absent NewSyncClient, one clone per goroutine is the recommended way of
+// reading bounded time concurrently, each goroutine owns its client and never
+// waits for other readers in the same process. A clone of a client created by
+// NewSyncClient is also safe for concurrent use.
+func (c *Client) Clone() (*Client, error) {
+	cfg := c.cfg
+	cfg.cleanupOnClose = false
+	clone, err := newClient(cfg)
+	if err != nil {
+		return nil, err
 	}
-	if err := reset(c); err != nil {
+	clone.synced = c.synced
+
+	return clone, nil
+}
+
+// NewSyncClient creates a new Client instance that is safe for concurrent use
+// by multiple goroutines, e.g. a single long lived client queried from many
+// request handlers. An internal mutex serializes all reads, including the
+// wait and post on clockd's semaphore and the bookkeeping for detecting a
+// stopped clockd, so concurrent readers queue behind each other. Clients
+// created by NewClient don't pay for such locking and should be preferred by
+// single threaded callers.
+func NewSyncClient(lockPath string, shmKey int, opts ...Option) (*Client, error) {
+	c, err := NewClient(lockPath, shmKey, opts...)
+	if err != nil {
 		return nil, err
 	}
+	c.synced = true
 
 	return c, nil
 }
 
-// Close closes the client instance.
-func (c *Client) Close() (err error) {
-	if c.data != nil {
-		err = FirstError(err, shm.Dt(c.data))
-		c.data = nil
+// Validate checks whether the clockd instance identified by the specified
+// lock path and shm key is present and publishing valid records, e.g. as a
+// preflight check in deployment tooling. Unlike NewClient, it never creates
+// the semaphore or the shared memory segment and it leaves no kernel object
+// behind. ErrNoSemaphore or ErrNoSegment is returned when clockd's semaphore
+// or segment is missing, otherwise the error of reading clockd's record is
+// returned, e.g. ErrNeverReady when clockd hasn't published anything yet or
+// ErrNotLocked when its clock is not locked. opts are applied to the client
+// used for the check, e.g. WithBufferSize for a segment of a non-default size.
+func Validate(lockPath string, shmKey int, opts ...Option) error {
+	opts = append(opts[:len(opts):len(opts)], WithStrictAttach(), WithReadOnly())
+	c, err := NewClient(lockPath, shmKey, opts...)
+	if err != nil {
+		return err
+	}
+	_, err = c.GetUnixTime()
+
+	return FirstError(err, c.Close())
+}
+
+func (c *Client) lock() {
+	if c.synced {
+		c.mu.Lock()
+	}
+}
+
+func (c *Client) unlock() {
+	if c.synced {
+		c.mu.Unlock()
+	}
+}
+
+// Close closes the client instance. When WithCleanupOnClose is set, clockd's
+// semaphore is also unlinked. Close is idempotent, closing a closed client
+// does nothing and returns nil. Reads made after Close fail with ErrClosed
+// until the client is attached again by Reattach.
+func (c *Client) Close() error {
+	c.lock()
+	defer c.unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	var err error
+	if c.cfg.cleanupOnClose && c.mutex != nil {
+		if uerr := c.mutex.Unlink(); !errors.Is(uerr, os.ErrNotExist) {
+			err = uerr
+		}
+	}
+
+	return FirstError(err, c.close())
+}
+
+func (c *Client) close() (err error) {
+	c.data = nil
+	if c.seg != nil {
+		err = FirstError(err, c.seg.detach())
+		c.seg = nil
 	}
 	if c.mutex != nil {
 		err = FirstError(err, c.mutex.Close())
@@ -153,117 +641,1136 @@ func (c *Client) Close() (err error) {
 // deadline.
 func (c *Client) WaitUntil(deadline UnixTime) error {
 	_, upper := deadline.Bounds()
+	return waitUntil(context.Background(), c, upper)
+}
+
+// WaitUntilPast does not return until the current time is definitely after
+// the specified UnixTime, i.e. until After(ut) becomes true, which is the
+// commit wait in externally consistent systems. It returns ctx.Err() when
+// the context is done first. Errors such as ErrStopped and ErrNotReady are
+// returned immediately rather than being retried.
+func (c *Client) WaitUntilPast(ctx context.Context, ut UnixTime) error {
+	_, upper := ut.Bounds()
+	return waitUntil(ctx, c, upper+1)
+}
+
+// After returns a boolean value indicating whether the current time is
+// definitely after the specified UnixTime, i.e. the lower bound of the current
+// time is strictly greater than the upper bound of ut. Degraded readings are
+// still used, with ErrDegraded returned together with the result.
+func (c *Client) After(ut UnixTime) (bool, error) {
+	now, err := c.GetUnixTime()
+	if err != nil && !errors.Is(err, ErrDegraded) {
+		return false, err
+	}
+
+	return after(now, ut), err
+}
+
+// Before returns a boolean value indicating whether the current time is
+// definitely before the specified UnixTime, i.e. the upper bound of the
+// current time is strictly less than the lower bound of ut. Degraded readings
+// are still used, with ErrDegraded returned together with the result.
+func (c *Client) Before(ut UnixTime) (bool, error) {
+	now, err := c.GetUnixTime()
+	if err != nil && !errors.Is(err, ErrDegraded) {
+		return false, err
+	}
+
+	return before(now, ut), err
+}
+
+// BoundsAt returns the bounds of the actual time at the instant the local
+// clock reads target, which can be in the future or in the past. The current
+// bounded time is read and shifted to target, its uncertainty is widened by
+// the clock uncertainty accumulated over the distance between now and target
+// according to the configured DriftModel, e.g. for scheduling work no earlier
+// than a future instant. Degraded readings are still used, with ErrDegraded
+// returned together with the result.
+func (c *Client) BoundsAt(target time.Time) (time.Time, time.Time, error) {
+	now, err := c.GetUnixTime()
+	if err != nil && !errors.Is(err, ErrDegraded) {
+		return time.Time{}, time.Time{}, err
+	}
+	lower, upper := boundsAt(now, target, c.cfg.driftModel)
+
+	return lower, upper, err
+}
+
+// boundsAt shifts now to target and widens its uncertainty by the clock
+// uncertainty accumulated over the absolute distance between them.
+func boundsAt(now UnixTime, target time.Time,
+	model DriftModel) (time.Time, time.Time) {
+	delta := target.Sub(now.ToTime())
+	abs := delta
+	if abs < 0 {
+		abs = -abs
+	}
+	// the absolute value of the min duration overflows
+	if abs < 0 {
+		abs = math.MaxInt64
+	}
+	at := now.Add(delta).AddDispersion(model.Uncertainty(int64(abs)))
+
+	return at.BoundsTime()
+}
+
+func after(now UnixTime, ut UnixTime) bool {
+	return now.DefinitelyAfter(ut)
+}
+
+func before(now UnixTime, ut UnixTime) bool {
+	return now.DefinitelyBefore(ut)
+}
+
+// getUnixTimeContext reads the current time from the source, the read is
+// interruptible when the source is a BoundedClock.
+func getUnixTimeContext(ctx context.Context, source TimeSource) (UnixTime, error) {
+	if c, ok := source.(BoundedClock); ok {
+		return c.GetUnixTimeContext(ctx)
+	}
+
+	return source.GetUnixTime()
+}
+
+// waitUntil blocks until the lower bound of the time reported by source is
+// equal to or later than target in nanoseconds. Rather than polling, it sleeps
+// for the computed remaining time, including the dispersion expected to
+// accumulate during the sleep, and confirms with a single read. When the
+// dispersion grew more than expected, e.g. clockd is degraded, it sleeps again
+// for the remainder until ctx is done. Degraded readings are used as they are
+// still correct.
+func waitUntil(ctx context.Context, source TimeSource, target uint64) error {
+	var model DriftModel = LinearDriftModel(MaxClockDrift)
+	if c, ok := source.(*Client); ok {
+		model = c.cfg.driftModel
+	}
+	var timer *time.Timer
 	for {
-		now, err := c.GetUnixTime()
-		if err != nil {
+		now, err := getUnixTimeContext(ctx, source)
+		if err != nil && (!errors.Is(err, ErrDegraded) || now.IsEmpty()) {
 			return err
 		}
 		nl, _ := now.Bounds()
-		if nl >= upper {
+		if nl >= target {
 			return nil
 		}
-		diff := (upper-nl)/1000 + 1
-		time.Sleep(time.Duration(diff) * time.Microsecond)
+		diff := waitDuration(target-nl, model)
+		if timer == nil {
+			timer = time.NewTimer(diff)
+			defer timer.Stop()
+		} else {
+			timer.Reset(diff)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
 	}
 }
 
+// waitDuration returns how long to sleep for the lower bound of the current
+// time to advance by gap nanoseconds, the clock uncertainty accumulated over
+// the sleep according to model widens the lower bound and is thus slept on
+// top of gap. The result is rounded up to the microsecond. The uncertainty is
+// overestimated for models with a constant error, e.g. AffineDriftModel, as
+// the constant is already covered by the current reading.
+func waitDuration(gap uint64, model DriftModel) time.Duration {
+	if gap > math.MaxInt64/2 {
+		return math.MaxInt64
+	}
+	d := gap + model.Uncertainty(int64(gap))
+	// the uncertainty accumulated over the extra sleep is also covered
+	d += model.Uncertainty(int64(d-gap)) + 1
+
+	return time.Duration(d/1000+1) * time.Microsecond
+}
+
 // GetUnixTime returns the UnixTime instance that represents the current time
 // with reported uncertainty.
 func (c *Client) GetUnixTime() (UnixTime, error) {
-	data, sec, nsec, err := c.read()
+	return c.getUnixTime(context.Background(), &c.info, &c.sample, nil, true)
+}
+
+// GetUnixTimeNoStaleCheck is similar to GetUnixTime, but it returns the
+// reading derived from a valid and locked record even when Count hasn't
+// advanced for longer than the stale threshold, i.e. ErrStopped is never
+// returned. This is intended for one-shot callers, e.g. a CLI reading the time
+// once, which don't care about staleness across calls. Note that this
+// sacrifices the detection of a crashed or wedged clockd, the dispersion of the
+// returned reading keeps growing with the age of the record but nothing
+// indicates that clockd stopped updating it.
+func (c *Client) GetUnixTimeNoStaleCheck() (UnixTime, error) {
+	info := ClientInfo{}
+	sample := UnixTime{}
+	return c.getUnixTime(context.Background(), &info, &sample, nil, false)
+}
+
+// GetUnixTimeOrFallback is similar to GetUnixTime, but when clockd is not
+// available, i.e. on ErrStopped or ErrNotReady, it falls back to the local
+// clock with the fallback dispersion, see WithFallbackDispersion, and returns
+// true together with a nil error. The fallback reading is a best effort
+// timestamp for non-critical paths, callers requiring bounded time must check
+// the returned boolean value. Other errors are returned as is.
+func (c *Client) GetUnixTimeOrFallback() (UnixTime, bool, error) {
+	ut, err := c.GetUnixTime()
+	if err == nil || !ut.IsEmpty() {
+		return ut, false, err
+	}
+	if !errors.Is(err, ErrStopped) && !errors.Is(err, ErrNotReady) {
+		return UnixTime{}, false, err
+	}
+	c.lock()
+	sec, nsec := c.now()
+	c.unlock()
+
+	return UnixTime{
+		Sec:        sec,
+		NSec:       nsec,
+		Dispersion: uint64(c.cfg.fallbackDispersion),
+	}, true, nil
+}
+
+// GetUnixTimeInto is similar to GetUnixTime, it also fills the provided
+// ClientInfo with the record published by clockd from which the returned
+// UnixTime is derived, e.g. for callers to branch on its LeapState. High
+// frequency callers can reuse the same ClientInfo across calls to avoid
+// copying the record.
+func (c *Client) GetUnixTimeInto(info *ClientInfo) (UnixTime, error) {
+	sample := UnixTime{}
+	return c.getUnixTime(context.Background(), info, &sample, nil, true)
+}
+
+// DetailedReading is a reading returned by GetUnixTimeDetailed. Time remains
+// the authoritative bounded time, Sample and Reference are the inputs it is
+// derived from and are provided for correlation and diagnostics only.
+type DetailedReading struct {
+	// Time is the bounded time, the same as returned by GetUnixTime.
+	Time UnixTime
+	// Sample is the local clock time sampled when reading clockd's record,
+	// its Dispersion covers both samples when SampleBracket is used.
+	Sample UnixTime
+	// Reference is the time of clockd's reference together with the
+	// dispersion published by clockd.
+	Reference UnixTime
+}
+
+// GetUnixTimeDetailed is similar to GetUnixTime, it also returns the local
+// clock time sampled by the read and the reference published by clockd, e.g.
+// for correlating the reading with other local events. Sample and Reference
+// are also set when the read fails after clockd's record was read, e.g. with
+// ErrNotLocked, Time is then empty.
+func (c *Client) GetUnixTimeDetailed() (DetailedReading, error) {
+	info := ClientInfo{}
+	r := DetailedReading{}
+	ut, err := c.getUnixTime(context.Background(), &info, &r.Sample, nil, true)
+	r.Time = ut
+	r.Reference = UnixTime{
+		Sec:        info.Sec,
+		NSec:       info.NSec,
+		Dispersion: info.Dispersion,
+	}
+
+	return r, err
+}
+
+// GetUnixTimeBreakdown is similar to GetUnixTime, it also returns the split of
+// the Dispersion of the returned UnixTime into the dispersion published by
+// clockd and the uncertainty accumulated locally, e.g. for tuning and
+// debugging. The Dispersion of the returned UnixTime is unchanged, it equals
+// the Total of the breakdown. The breakdown is empty when no UnixTime is
+// returned.
+func (c *Client) GetUnixTimeBreakdown() (UnixTime, DispersionBreakdown, error) {
+	info := ClientInfo{}
+	sample := UnixTime{}
+	ut, err := c.getUnixTime(context.Background(), &info, &sample, nil, true)
+	if ut.IsEmpty() {
+		return ut, DispersionBreakdown{}, err
+	}
+	b, berr := getDispersionBreakdown(info,
+		sample.Sec, sample.NSec, c.cfg.driftModel)
+	if berr != nil {
+		// unreachable, the same inputs have already been accepted by the read
+		return UnixTime{}, DispersionBreakdown{}, berr
+	}
+	// the dispersion is saturated, Read is whatever is left on top of the
+	// saturated sum of the other components
+	if base := b.Total(); ut.Dispersion > base {
+		b.Read = ut.Dispersion - base
+	}
+
+	return ut, b, err
+}
+
+// GetUnixTimeContext is similar to GetUnixTime, but it stops waiting for
+// clockd's semaphore and returns ctx.Err() once the context is canceled or
+// its deadline is exceeded, e.g. when clockd holds the semaphore after being
+// wedged. The semaphore is waited in the calling goroutine, no goroutine is
+// leaked when the wait is abandoned. Note that for clients created by
+// NewSyncClient, waiting for other concurrent readers is not interruptible.
+func (c *Client) GetUnixTimeContext(ctx context.Context) (UnixTime, error) {
+	info := ClientInfo{}
+	sample := UnixTime{}
+	return c.getUnixTime(ctx, &info, &sample, nil, true)
+}
+
+// GetWithOSComparison returns the current bounded time together with the OS
+// wall clock time sampled within the same critical section, which is exactly
+// the local time used for computing the dispersion of the returned UnixTime.
+// It allows callers to compare the two without separate time reads
+// introducing their own skew.
+func (c *Client) GetWithOSComparison() (UnixTime, time.Time, error) {
+	info := ClientInfo{}
+	sample := UnixTime{}
+	ut, err := c.getUnixTime(context.Background(), &info, &sample, nil, true)
+	if err != nil && sample.IsEmpty() {
+		return ut, time.Time{}, err
+	}
+
+	return ut, sample.ToTime(), err
+}
+
+// Healthy performs a single read and returns a boolean value indicating
+// whether clockd is healthy, i.e. its record is valid, locked and not stale.
+// It is intended for readiness probes. When unhealthy, the returned error
+// describes why, e.g. ErrNotLocked or a StoppedError, and the State method
+// classifies it. Degraded readings are still safe to use, clockd is reported
+// as healthy with ErrDegraded returned as a warning.
+func (c *Client) Healthy() (bool, error) {
+	info := ClientInfo{}
+	sample := UnixTime{}
+	_, err := c.getUnixTime(context.Background(), &info, &sample, nil, true)
+	if err != nil && !errors.Is(err, ErrDegraded) {
+		return false, err
+	}
+
+	return true, err
+}
+
+// State returns the state of clockd observed by the most recent read.
+func (c *Client) State() State {
+	c.lock()
+	defer c.unlock()
+
+	return c.state
+}
+
+// OnUpdate registers fn to be invoked with the reading whenever a read
+// observes that clockd's Count advanced, i.e. the reading is derived from a
+// genuinely new clockd record rather than a repeated poll of the same one.
+// Reads returning no UnixTime, e.g. with ErrNotTrusted, never invoke fn. fn is
+// invoked synchronously by the reading goroutine after the Client's internal
+// lock is released, it must be fast as it delays the read. A nil fn removes
+// the registered callback.
+func (c *Client) OnUpdate(fn func(UnixTime)) {
+	c.lock()
+	defer c.unlock()
+
+	c.onUpdate = fn
+}
+
+// OnReconnect registers fn to be invoked whenever a read successfully
+// reattaches the client to clockd's semaphore and shared memory segment after
+// a failure, fn is invoked with the error that required the reattachment, e.g.
+// to count reconnects as a stability metric. The attachment made by the
+// constructor and explicit Reattach calls never invoke fn. fn is invoked
+// synchronously by the reading goroutine after the Client's internal lock is
+// released. A nil fn removes the registered callback.
+func (c *Client) OnReconnect(fn func(err error)) {
+	c.lock()
+	defer c.unlock()
+
+	c.onReconnect = fn
+}
+
+// OnDisconnect registers fn to be invoked whenever a read fails with an error
+// that requires the client to reattach to clockd, e.g. ErrNotLocked or
+// ErrStopped, fn is invoked with that error. Failed reads before the client is
+// reattached don't invoke fn again, e.g. for alerting on clockd flapping. fn
+// is invoked synchronously by the reading goroutine after the Client's
+// internal lock is released. A nil fn removes the registered callback.
+func (c *Client) OnDisconnect(fn func(err error)) {
+	c.lock()
+	defer c.unlock()
+
+	c.onDisconnect = fn
+}
+
+// LastReadLatency returns how long the last read took, including waiting for
+// the client's lock and clockd's semaphore, copying and validating clockd's
+// record, but excluding any callbacks. It is 0 before the first read.
+func (c *Client) LastReadLatency() time.Duration {
+	c.lock()
+	defer c.unlock()
+
+	return c.lastLatency
+}
+
+// DebugInfo returns a copy of the last ClientInfo record decoded from clockd's
+// shared memory region, including records later rejected, e.g. because clockd
+// is not locked. It is a diagnostic API for operators to compare what clockd
+// published against the readings returned by the client, it takes the
+// client's lock and shouldn't be used on hot paths. An ErrNotReady is
+// returned when no record has been decoded yet.
+func (c *Client) DebugInfo() (ClientInfo, error) {
+	c.lock()
+	defer c.unlock()
+
+	if !c.decoded.ok {
+		return ClientInfo{}, fmt.Errorf("%w: no record decoded yet", ErrNotReady)
+	}
+
+	return c.decoded.info, nil
+}
+
+// StalenessAge returns how long clockd's Count has stayed unchanged, i.e. the
+// age of the last fresh record observed by the client. It is measured on the
+// monotonic readings of the configured Clock when available, see
+// MonotonicClock, and ErrStopped is returned by reads once it exceeds
+// StaleThreshold. An ErrNotReady is returned when no Count has been observed
+// yet.
+func (c *Client) StalenessAge() (time.Duration, error) {
+	c.lock()
+	defer c.unlock()
+
+	if c.last.mono == 0 {
+		return 0, fmt.Errorf("%w: no count observed yet", ErrNotReady)
+	}
+	sec, nsec := c.now()
+	mono := c.monotonic(UnixTime{Sec: sec, NSec: nsec})
+
+	return time.Duration(mono - c.last.mono), nil
+}
+
+// StaleThreshold returns the effective stale threshold of the client, see
+// WithStaleThreshold.
+func (c *Client) StaleThreshold() time.Duration {
+	return c.cfg.staleThreshold
+}
+
+// Attached returns a boolean value indicating whether the client is currently
+// attached to clockd's semaphore and shared memory segment. The client is
+// detached after Close or after a failed reattach.
+func (c *Client) Attached() bool {
+	c.lock()
+	defer c.unlock()
+
+	return c.data != nil
+}
+
+// Reattach detaches the client from clockd's semaphore and shared memory
+// segment and attaches it again, e.g. to proactively reconnect after clockd
+// is restarted rather than waiting for the next failed read to do so. It can
+// be called repeatedly and after Close. The reconnect backoff is not applied,
+// on failure, the client is left detached and the next read retries.
+func (c *Client) Reattach() error {
+	c.lock()
+	defer c.unlock()
+
+	c.closed = false
+	c.resetRequired = false
+	err := reset(c)
+	c.logReset(err)
+	c.cfg.metrics.ObserveReset(err)
 	if err != nil {
 		c.resetRequired = true
-		return UnixTime{}, err
+		return err
+	}
+	c.reconnect.attempts = 0
+	c.reconnect.cause = nil
+
+	return nil
+}
+
+// GetUnixTimes returns n UnixTime instances derived from the same clockd
+// record, each with its own freshly sampled local clock time, taken in
+// ascending order while clockd's semaphore is held only once. It allows a
+// burst of bounded timestamps, e.g. for a batch of events, to be obtained
+// without paying the semaphore cost per timestamp. Validity and staleness are
+// checked for the batch as a whole, no UnixTime is returned on error other
+// than ErrDegraded.
+func (c *Client) GetUnixTimes(n int) ([]UnixTime, error) {
+	if n <= 0 {
+		return nil, nil
 	}
 	info := ClientInfo{}
-	if err := UnmarshalClientInfo(data, &info); err != nil {
-		panic(err)
+	sample := UnixTime{}
+	result := make([]UnixTime, n)
+	ut, err := c.getUnixTime(context.Background(),
+		&info, &sample, result[1:], true)
+	if err != nil && !errors.Is(err, ErrDegraded) {
+		return nil, err
+	}
+	result[0] = ut
+
+	return result, err
+}
+
+// getUnixTime reads clockd's record into info and returns the derived
+// UnixTime. The local sys clock time sampled when reading the shared memory
+// region is stored into sample. When extra is not empty, additional UnixTime
+// values derived from the same record are stored into it.
+func (c *Client) getUnixTime(ctx context.Context, info *ClientInfo,
+	sample *UnixTime, extra []UnixTime, staleCheck bool) (UnixTime, error) {
+	_, nop := c.cfg.metrics.(NopMetricsObserver)
+	observed := !nop
+	start := time.Now()
+	c.lock()
+	c.last.advanced = false
+	ut, err := c.readUnixTime(ctx, start, info, sample, extra, staleCheck)
+	c.lastLatency = time.Since(start)
+	if err != nil && (errors.Is(err, ErrNotReady) || errors.Is(err, ErrStopped)) {
+		err = &ReadError{
+			Err:       err,
+			Latency:   c.lastLatency,
+			LastCount: c.last.count,
+			Seen:      c.last.mono != 0,
+		}
+	}
+	// abandoning the wait says nothing about the state of clockd
+	var old State
+	changed := false
+	if err == nil || err != ctx.Err() {
+		old, changed = c.observe(err)
+	}
+	var onUpdate func(UnixTime)
+	if c.last.advanced && !ut.IsEmpty() {
+		onUpdate = c.onUpdate
+	}
+	onReconnect, onDisconnect := c.onReconnect, c.onDisconnect
+	reconnected, disconnected := c.events.reconnected, c.events.disconnected
+	c.events.reconnected, c.events.disconnected = nil, nil
+	c.unlock()
+	// callbacks are never invoked with the semaphore or the mutex held
+	if reconnected != nil && onReconnect != nil {
+		onReconnect(reconnected)
+	}
+	if disconnected != nil && onDisconnect != nil {
+		onDisconnect(disconnected)
+	}
+	if changed {
+		c.logStateChange(ctx, old, err)
+		if c.cfg.onStateChange != nil {
+			c.cfg.onStateChange(old, stateOf(err))
+		}
+	}
+	if onUpdate != nil {
+		onUpdate(ut)
+	}
+	if observed {
+		c.cfg.metrics.ObserveRead(time.Since(start), ut.Dispersion, err)
+	}
+
+	return ut, err
+}
+
+// observe updates the observed state of clockd after a read, it returns the
+// previous state and whether the state changed.
+func (c *Client) observe(err error) (State, bool) {
+	state := stateOf(err)
+	if state == c.state {
+		return state, false
+	}
+	old := c.state
+	c.state = state
+
+	return old, true
+}
+
+// readUnixTime reads clockd's record and returns the derived UnixTime, start
+// is the time the read started.
+func (c *Client) readUnixTime(ctx context.Context, start time.Time,
+	info *ClientInfo, sample *UnixTime, extra []UnixTime,
+	staleCheck bool) (UnixTime, error) {
+	if c.closed {
+		return UnixTime{}, ErrClosed
+	}
+	local, err := c.read(ctx, info, extra)
+	*sample = local
+	if err != nil {
+		// contention or an abandoned wait doesn't require a reset
+		if err == ctx.Err() || errors.Is(err, ErrBusy) {
+			return UnixTime{}, err
+		}
+		return UnixTime{}, c.fail(err)
+	}
+	c.decoded.ok = true
+	c.decoded.info = *info
+	// a NSec value out of the [0, 1e9) range is a clockd bug, it is rejected
+	// rather than normalized as the rest of the record can't be trusted either
+	if info.NSec >= 1e9 {
+		return UnixTime{}, c.fail(ErrCorruptData)
 	}
 	if !info.Valid || !info.Locked {
-		c.resetRequired = true
-		return UnixTime{}, ErrNotReady
+		return UnixTime{}, c.fail(ErrNotLocked)
+	}
+	if c.cfg.strict && info.Dispersion == 0 {
+		return UnixTime{}, c.fail(ErrImplausibleReading)
 	}
 
+	dispersion, err := getDispersion(*info, local.Sec, local.NSec, c.cfg.driftModel)
+	if err != nil {
+		return UnixTime{}, err
+	}
+	dispersion = addSaturated(dispersion, local.Dispersion)
+	if c.cfg.latencyInDispersion {
+		// added before the reading is recorded as the latest one and in the
+		// history, so they match the returned reading
+		dispersion = addSaturated(dispersion, uint64(time.Since(start)))
+	}
 	ut := UnixTime{
-		Sec:        sec,
-		NSec:       nsec,
-		Dispersion: getDispersion(info, sec, nsec),
+		Sec:        local.Sec,
+		NSec:       local.NSec,
+		Dispersion: dispersion,
 	}
-	if c.updateStaled(ut, info.Count) {
-		c.resetRequired = true
-		return UnixTime{}, ErrStopped
+	newest := ut
+	for i := range extra {
+		extra[i].Dispersion, err = getDispersion(*info,
+			extra[i].Sec, extra[i].NSec, c.cfg.driftModel)
+		if err != nil {
+			return UnixTime{}, err
+		}
+		newest = extra[i]
+	}
+	mono := c.monotonic(newest)
+	if staleCheck && c.updateStaled(mono, info.Count) {
+		return UnixTime{}, c.fail(&StoppedError{
+			Count:     info.Count,
+			LastCount: c.last.count,
+			Frozen:    time.Duration(mono - c.last.mono),
+		})
 	}
 	if c.last.count != info.Count {
 		c.last.count = info.Count
-		c.last.time = ut
+		c.last.mono = mono
+		c.last.advanced = true
+	}
+	untrusted := c.cfg.minReadsBeforeTrust > 1 && !c.trust.trusted
+	if !c.trusted(info) {
+		return UnixTime{}, ErrNotTrusted
+	}
+	// readings are only recorded once trusted, the first trusted one is
+	// recorded even when its Count was observed before
+	if c.last.advanced || untrusted {
+		c.history.add(ut)
+	}
+	c.latest = ut
+	c.reconnect.attempts = 0
+	if c.cfg.maxDispersion > 0 &&
+		ut.Dispersion > uint64(c.cfg.maxDispersion) {
+		return ut, fmt.Errorf("%w: %s", ErrDispersionTooLarge,
+			time.Duration(ut.Dispersion))
+	}
+	if c.degraded(ut, info) {
+		return ut, ErrDegraded
 	}
 
 	return ut, nil
 }
 
-func (c *Client) updateStaled(ut UnixTime, count uint16) bool {
+// unmarshal unmarshals the record returned by getRecord, which is in the
+// legacy layout when it has the legacy size.
+func (c *Client) unmarshal(data []byte, info *ClientInfo) error {
+	if len(data) == legacyClientInfoSize {
+		return UnmarshalLegacyClientInfo(data, info)
+	}
+
+	return UnmarshalClientInfoByteOrder(data, info, c.cfg.byteOrder)
+}
+
+// fail marks the client as requiring a reset after the specified error.
+func (c *Client) fail(err error) error {
+	if !c.resetRequired {
+		c.reconnect.cause = err
+		c.events.disconnected = err
+	}
+	c.resetRequired = true
+	c.trust.reads = 0
+
+	return err
+}
+
+// trusted returns a boolean value indicating whether enough consecutive
+// consistent records have been observed for the client to trust clockd. Two
+// records are consistent when Count advanced or, for the same Count, the
+// published dispersion is unchanged.
+func (c *Client) trusted(info *ClientInfo) bool {
+	if c.trust.trusted || c.cfg.minReadsBeforeTrust <= 1 {
+		return true
+	}
+	prev := c.trust.prev
+	if c.trust.reads > 0 &&
+		(info.Count != prev.Count || info.Dispersion == prev.Dispersion) {
+		c.trust.reads++
+	} else {
+		c.trust.reads = 1
+	}
+	c.trust.prev = *info
+	c.trust.trusted = c.trust.reads >= c.cfg.minReadsBeforeTrust
+
+	return c.trust.trusted
+}
+
+func (c *Client) degraded(ut UnixTime, info *ClientInfo) bool {
+	if c.cfg.degradedAge <= 0 {
+		return false
+	}
+	ref := UnixTime{Sec: info.Sec, NSec: info.NSec}
+
+	return ut.Sub(ref) > int64(c.cfg.degradedAge)
+}
+
+// WaitForNewReading blocks until clockd publishes a record newer than the one
+// prev was derived from, i.e. a record with a different Count, and returns
+// the reading derived from the new record. prev is expected to be the latest
+// reading returned by the client, otherwise the record currently published
+// is considered as the one prev was derived from. Errors such as ErrStopped
+// encountered while polling are returned immediately. Degraded readings are
+// still used, a new degraded reading is returned together with ErrDegraded.
+func (c *Client) WaitForNewReading(ctx context.Context,
+	prev UnixTime) (UnixTime, error) {
+	info := ClientInfo{}
+	c.lock()
+	count := c.last.count
+	latest := c.latest
+	c.unlock()
+	if prev.IsEmpty() || prev != latest {
+		_, err := c.GetUnixTimeInto(&info)
+		if err != nil && !errors.Is(err, ErrDegraded) {
+			return UnixTime{}, err
+		}
+		count = info.Count
+	}
+	timer := time.NewTimer(newReadingPollInterval)
+	defer timer.Stop()
+	for {
+		ut, err := c.GetUnixTimeInto(&info)
+		if err != nil && !errors.Is(err, ErrDegraded) {
+			return UnixTime{}, err
+		}
+		if info.Count != count {
+			return ut, err
+		}
+		timer.Reset(newReadingPollInterval)
+		select {
+		case <-ctx.Done():
+			return UnixTime{}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) updateStaled(mono int64, count uint16) bool {
 	if c.last.count != count {
 		return false
 	}
-	if c.last.time.IsEmpty() {
+	if c.last.mono == 0 {
 		return false
 	}
 
-	return ut.Sub(c.last.time) > staleThresholdNanoseconds
+	return mono-c.last.mono > int64(c.cfg.staleThreshold)
 }
 
 func reset(c *Client) error {
-	_ = c.Close()
+	// the client is reattached anyway, a failure to detach leaks the old
+	// mapping and is thus reported
+	if err := c.close(); err != nil {
+		c.logDetachError(fmt.Errorf("failed to detach old segment: %w", err))
+	}
 
-	m, err := NewSemaphore(c.lockPath, uint32(os.O_RDWR), 1)
-	if err != nil {
-		return err
+	if c.cfg.region != nil {
+		return c.useRegion(c.cfg.region)
+	}
+	var m *Semaphore
+	var err error
+	if c.cfg.strictAttach {
+		m, err = OpenSemaphore(c.lockPath)
+		if errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("%w: %s", ErrNoSemaphore, c.lockPath)
+		}
+	} else {
+		m, err = NewSemaphore(c.lockPath, c.cfg.semaphoreMode, 1)
 	}
-	shmID, err := shm.Get(c.shmKey, ClientInfoSharedMemoryBufferSize, shm.IPC_CREAT|0600)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to open semaphore %s: %w", c.lockPath, err)
+	}
+	var seg *segment
+	if c.cfg.strictAttach || c.cfg.readOnly {
+		seg, err = attachSegment(c.shmKey, c.cfg.bufferSize, c.cfg.readOnly)
+	} else {
+		seg, err = openSegment(c.shmKey, c.cfg.bufferSize)
+	}
+	if err == nil && c.cfg.strictAttach && len(seg.data) != c.cfg.bufferSize {
+		err = fmt.Errorf("%w: segment has %d bytes",
+			ErrSegmentSizeMismatch, len(seg.data))
+		_ = seg.detach()
 	}
-	data, err := shm.At(shmID, 0, 0)
 	if err != nil {
-		return err
+		_ = m.Close()
+		return fmt.Errorf("failed to attach %d bytes of new segment %d: %w",
+			c.cfg.bufferSize, c.shmKey, err)
 	}
-
 	c.mutex = m
-	c.shmID = shmID
+	c.seg = seg
+
+	return c.useRegion(seg)
+}
+
+// useRegion makes the client read clockd's records from the region.
+func (c *Client) useRegion(region ShmRegion) error {
+	data := region.Bytes()
+	if len(data) < c.cfg.bufferSize {
+		return fmt.Errorf("%w: region has %d bytes, expected %d",
+			ErrSegmentSizeMismatch, len(data), c.cfg.bufferSize)
+	}
+	// the segment created by clockd can be larger than requested, it is
+	// copied as a whole so records sized for it are never truncated
+	if len(data) > len(c.buf) {
+		c.buf = make([]byte, len(data))
+	}
+	if c.cfg.mlock {
+		if err := lockMemory(data); err != nil {
+			c.logMlockError(err)
+		}
+	}
+	if c.cfg.preTouch {
+		// copying the region reads every page of it, the content is discarded
+		copy(c.buf, data)
+	}
 	c.data = data
 
 	return nil
 }
 
+// tryReset resets the client when required. Repeated resets are throttled by
+// the configured reconnect backoff, when throttled, the error of the last
+// failed reset is returned, or the existing attachment keeps being used when
+// the last reset succeeded.
 func (c *Client) tryReset() error {
-	if c.resetRequired {
-		c.resetRequired = false
-		if err := reset(c); err != nil {
-			c.resetRequired = true
-			return err
-		}
+	if !c.resetRequired {
+		return nil
+	}
+	now := time.Now()
+	if c.cfg.reconnectBase > 0 &&
+		c.reconnect.attempts > 0 && now.Before(c.reconnect.next) {
+		return c.reconnect.err
+	}
+	c.resetRequired = false
+	err := reset(c)
+	c.reconnect.next = now.Add(backoffDelay(c.cfg.reconnectBase,
+		c.cfg.reconnectMax, c.reconnect.attempts))
+	c.reconnect.attempts++
+	c.reconnect.err = err
+	c.logReset(err)
+	c.cfg.metrics.ObserveReset(err)
+	if err != nil {
+		c.resetRequired = true
+		return err
+	}
+	if c.reconnect.cause != nil {
+		c.events.reconnected = c.reconnect.cause
+		c.reconnect.cause = nil
 	}
 
 	return nil
 }
 
-func (c *Client) read() (data []byte, sec uint64, nsec uint32, err error) {
+// read copies clockd's record out of the shared memory region, decodes it
+// into info and returns the local sys clock time sampled according to the
+// configured SamplePlacement. Additional local sys clock times are sampled
+// into extra before the semaphore is released. The semaphore is skipped when
+// the seqlock is enabled and the record carries the Seq field. The Dispersion
+// of the returned sample is the uncertainty introduced by the sampling itself.
+// The record is decoded straight from c.buf, so nothing is allocated.
+func (c *Client) read(ctx context.Context,
+	info *ClientInfo, extra []UnixTime) (sample UnixTime, err error) {
 	if err := c.tryReset(); err != nil {
-		return nil, 0, 0, err
+		return UnixTime{}, err
+	}
+	if c.cfg.seqlock &&
+		c.cfg.byteOrder.Uint16(c.data) == uint16(clientInfoSize) {
+		return c.readSeqlock(info, extra)
 	}
 
-	if err := c.mutex.Wait(); err != nil {
-		return nil, 0, 0, err
+	if err := c.wait(ctx); err != nil {
+		if err != ctx.Err() &&
+			!errors.Is(err, ErrBusy) && !errors.Is(err, ErrStopped) {
+			c.logSemaphoreError("wait", err)
+		}
+		return UnixTime{}, err
 	}
 	defer func() {
-		err = c.mutex.Post()
+		if perr := c.post(); perr != nil {
+			c.logSemaphoreError("post", perr)
+			err = FirstError(err, perr)
+		}
 	}()
-	sec, nsec = getSysClockTime()
+	var prefix uint16
+	if c.cfg.doubleRead {
+		prefix = c.cfg.byteOrder.Uint16(c.data)
+	}
+	sample = c.copy(extra)
+	record, err := getRecord(c.buf, prefix, c.cfg.doubleRead, c.layout())
+	if err != nil {
+		return UnixTime{}, err
+	}
+
+	return sample, c.unmarshal(record, info)
+}
+
+// readSeqlock is similar to read, but instead of holding the semaphore, it
+// retries until the Seq field is even and unchanged across the copy, i.e.
+// the copied record isn't torn by a concurrent update. ErrBusy is returned
+// when no consistent record can be copied after seqlockRetries attempts.
+func (c *Client) readSeqlock(info *ClientInfo,
+	extra []UnixTime) (UnixTime, error) {
+	for i := 0; i < seqlockRetries; i++ {
+		seq := loadSeq(c.data, c.cfg.byteOrder)
+		if seq%2 == 1 {
+			runtime.Gosched()
+			continue
+		}
+		sample := c.copy(extra)
+		if loadSeq(c.data, c.cfg.byteOrder) != seq {
+			continue
+		}
+		record, err := getRecord(c.buf, 0, false, layout{order: c.cfg.byteOrder})
+		if err != nil {
+			return UnixTime{}, err
+		}
+		return sample, c.unmarshal(record, info)
+	}
+
+	return UnixTime{}, ErrBusy
+}
+
+// copy copies the shared memory region into c.buf and returns the local sys
+// clock time sampled according to the configured SamplePlacement, additional
+// local sys clock times are sampled into extra right after the copy.
+func (c *Client) copy(extra []UnixTime) UnixTime {
+	var before UnixTime
+	if c.cfg.placement != SampleAfterCopy {
+		before.Sec, before.NSec = c.now()
+	}
 	copy(c.buf, c.data)
-	datalen := binary.BigEndian.Uint16(c.buf)
+	if c.afterCopy != nil {
+		c.afterCopy()
+	}
+	sample := before
+	if c.cfg.placement != SampleBeforeCopy {
+		var after UnixTime
+		after.Sec, after.NSec = c.now()
+		sample = after
+		if c.cfg.placement == SampleBracket {
+			sample = bracket(before, after)
+		}
+	}
+	for i := range extra {
+		extra[i].Sec, extra[i].NSec = c.now()
+	}
+
+	return sample
+}
+
+// wait waits for the semaphore, it returns ctx.Err() when the context is
+// done before the semaphore is acquired and ErrStopped when the semaphore
+// isn't acquired within the configured lock timeout. ErrBusy is returned
+// immediately when the semaphore is held and WithTryLock is set.
+func (c *Client) wait(ctx context.Context) error {
+	if c.mutex == nil {
+		c.cfg.regionLock.Lock()
+		return nil
+	}
+	if c.cfg.tryLock {
+		acquired, err := c.mutex.TryWait()
+		if err != nil {
+			return err
+		}
+		if !acquired {
+			return ErrBusy
+		}
+		return nil
+	}
+	if ctx.Done() == nil && c.cfg.lockTimeout <= 0 {
+		if c.cfg.lockRecovery > 0 {
+			return c.waitRobust()
+		}
+		return c.mutex.Wait()
+	}
+	start := time.Now()
+	var deadline time.Time
+	if c.cfg.lockTimeout > 0 {
+		deadline = time.Now().Add(c.cfg.lockTimeout)
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		timeout := semaphorePollInterval
+		if d, ok := ctx.Deadline(); ok {
+			timeout = min(timeout, time.Until(d))
+		}
+		if !deadline.IsZero() {
+			if ctx.Done() == nil {
+				timeout = time.Until(deadline)
+			} else {
+				timeout = min(timeout, time.Until(deadline))
+			}
+		}
+		err := c.mutex.TimedWait(timeout)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrTimeout) {
+			return err
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return fmt.Errorf("%w: semaphore held for %s", ErrStopped,
+				c.cfg.lockTimeout)
+		}
+		if c.cfg.lockRecovery > 0 && time.Since(start) >= c.cfg.lockRecovery {
+			if err := c.mutex.reinitialize(); err != nil {
+				return err
+			}
+			c.lockRecovered()
+			start = time.Now()
+		}
+	}
+}
+
+// post releases the semaphore, or the lock of the region provided by
+// WithShmRegion.
+func (c *Client) post() error {
+	if c.mutex == nil {
+		c.cfg.regionLock.Unlock()
+		return nil
+	}
+
+	return c.mutex.Post()
+}
+
+// waitRobust waits for the semaphore indefinitely, the semaphore is recovered
+// each time it is held for longer than the lock recovery timeout.
+func (c *Client) waitRobust() error {
+	for {
+		recovered, err := c.mutex.WaitRobust(c.cfg.lockRecovery)
+		if recovered {
+			c.lockRecovered()
+		}
+		if !errors.Is(err, ErrTimeout) {
+			return err
+		}
+	}
+}
+
+func (c *Client) lockRecovered() {
+	c.cfg.logger.LogAttrs(context.Background(), slog.LevelWarn,
+		"recovered semaphore abandoned by clockd",
+		slog.String("lock_path", c.lockPath))
+	if c.cfg.onLockRecovered != nil {
+		c.cfg.onLockRecovered()
+	}
+}
+
+// now samples the configured Clock, the sys clock is sampled directly when
+// no Clock is configured to avoid the interface call.
+func (c *Client) now() (uint64, uint32) {
+	if c.cfg.clock == nil {
+		return getSysClockTime()
+	}
+
+	return c.cfg.clock.Now()
+}
+
+// monotonic returns the reading used for measuring how long clockd's Count
+// stays unchanged, ut is the latest sample of the configured Clock. The
+// monotonic reading of the Clock is used when available so wall clock steps
+// neither trip nor mask the staleness detection. Clocks without monotonic
+// readings are assumed to never step, ut is used as is.
+func (c *Client) monotonic(ut UnixTime) int64 {
+	if c.cfg.clock == nil {
+		return int64(SystemClock{}.Monotonic())
+	}
+	if mc, ok := c.cfg.clock.(MonotonicClock); ok {
+		return int64(mc.Monotonic())
+	}
+
+	return int64(ut.Sec)*1e9 + int64(ut.NSec)
+}
+
+// bracket returns the midpoint of the two samples with the dispersion set to
+// cover both of them.
+func bracket(before UnixTime, after UnixTime) UnixTime {
+	bl, _ := before.Bounds()
+	al, _ := after.Bounds()
+	if al < bl {
+		bl, al = al, bl
+	}
+
+	return fromBounds(bl, al)
+}
+
+// layout describes the expected layout of clockd's record.
+type layout struct {
+	// order is the byte order of the record and its datalen prefix
+	order binary.ByteOrder
+	// legacy indicates whether records in the legacy layout are accepted
+	legacy bool
+}
+
+func (c *Client) layout() layout {
+	return layout{order: c.cfg.byteOrder, legacy: c.cfg.legacyLayout}
+}
+
+// getRecord returns the ClientInfo record copied into buf, see getDataLen.
+func getRecord(buf []byte,
+	prefix uint16, doubleRead bool, l layout) ([]byte, error) {
+	datalen, err := getDataLen(buf, prefix, doubleRead, l)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf[2 : 2+datalen], nil
+}
+
+// getDataLen returns the length of the ClientInfo record copied into buf.
+// When doubleRead is set, prefix is the datalen read from the shared memory
+// before the copy and it must match the one found in buf. The datalen is
+// encoded in the byte order of the layout, except for records in the legacy
+// layout which are always big endian and only accepted when l.legacy is set.
+func getDataLen(buf []byte,
+	prefix uint16, doubleRead bool, l layout) (uint16, error) {
+	datalen := l.order.Uint16(buf)
+	if doubleRead && datalen != prefix {
+		return 0, ErrCorruptData
+	}
 	if datalen == 0 {
-		return nil, 0, 0, ErrNotReady
+		return 0, ErrUninitializedSegment
+	}
+	if int(datalen) == clientInfoSize {
+		return checkDataLen(buf, datalen)
+	}
+	if legacy := binary.BigEndian.Uint16(buf); int(legacy) == legacyClientInfoSize {
+		if !l.legacy {
+			return 0, ErrVersionMismatch
+		}
+		return checkDataLen(buf, legacy)
+	}
+	// clockd encodes its record in a different byte order
+	if int(bits.ReverseBytes16(datalen)) == clientInfoSize {
+		return 0, ErrVersionMismatch
+	}
+
+	return 0, ErrCorruptData
+}
+
+// checkDataLen makes sure that the record never extends beyond buf whatever
+// the record sizes are.
+func checkDataLen(buf []byte, datalen uint16) (uint16, error) {
+	if int(datalen) > len(buf)-2 {
+		return 0, ErrCorruptData
 	}
 
-	return c.buf[2 : 2+datalen], sec, nsec, nil
+	return datalen, nil
 }
@@ -15,11 +15,124 @@
 package thymef
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+var testClockdInstance atomic.Uint32
+
+// testClockd plays the role of clockd, it owns a dedicated semaphore and
+// shared memory segment and publishes ClientInfo records into it.
+type testClockd struct {
+	t        testing.TB
+	lockPath string
+	shmKey   int
+	seg      *segment
+	mutex    *Semaphore
+	data     []byte
+}
+
+func newTestClockd(t testing.TB) *testClockd {
+	return newTestClockdWithSize(t, ClientInfoSharedMemoryBufferSize)
+}
+
+func newTestClockdWithSize(t testing.TB, size int) *testClockd {
+	n := int(testClockdInstance.Add(1))
+	pid := os.Getpid()
+	d := &testClockd{
+		t:        t,
+		lockPath: fmt.Sprintf("thymef.test.%d.%d.lock", pid, n),
+		shmKey:   0x7f000000 | (pid&0xffff)<<8 | n&0xff,
+	}
+	m, err := NewSemaphore(d.lockPath, 0600, 1)
+	require.NoError(t, err)
+	d.mutex = m
+	seg, err := openSegment(d.shmKey, size)
+	require.NoError(t, err)
+	d.seg = seg
+	d.data = seg.data
+	t.Cleanup(func() {
+		assert.NoError(t, d.seg.detach())
+		assert.NoError(t, d.seg.remove())
+		assert.NoError(t, d.mutex.Unlink())
+		assert.NoError(t, d.mutex.Close())
+	})
+
+	return d
+}
+
+func (d *testClockd) newClient(opts ...Option) *Client {
+	c, err := NewClient(d.lockPath, d.shmKey, opts...)
+	require.NoError(d.t, err)
+	d.t.Cleanup(func() {
+		assert.NoError(d.t, c.Close())
+	})
+
+	return c
+}
+
+func (d *testClockd) publish(info ClientInfo) {
+	d.write(func(data []byte) {
+		binary.BigEndian.PutUint16(data, uint16(clientInfoSize))
+		_, err := info.Marshal(data[2:])
+		assert.NoError(d.t, err)
+	})
+}
+
+// publishSeqlock updates the shared memory region following the seqlock
+// protocol without holding the lock.
+func (d *testClockd) publishSeqlock(info ClientInfo) {
+	seq := loadSeq(d.data, Encoder) + 1
+	storeSeq(d.data, seq, Encoder)
+	binary.BigEndian.PutUint16(d.data, uint16(clientInfoSize))
+	info.Seq = seq
+	_, err := info.Marshal(d.data[2:])
+	assert.NoError(d.t, err)
+	storeSeq(d.data, seq+1, Encoder)
+}
+
+var (
+	testLayout       = layout{order: Encoder}
+	testLegacyLayout = layout{order: Encoder, legacy: true}
+)
+
+// write updates the shared memory region while holding the lock.
+func (d *testClockd) write(fn func(data []byte)) {
+	assert.NoError(d.t, d.mutex.Wait())
+	defer func() {
+		assert.NoError(d.t, d.mutex.Post())
+	}()
+	fn(d.data)
+}
+
+// lockedInfo returns a valid and locked ClientInfo record using the current
+// sys clock time as its reference.
+func lockedInfo(count uint16, dispersion uint64) ClientInfo {
+	sec, nsec := getSysClockTime()
+	return ClientInfo{
+		Valid:      true,
+		Locked:     true,
+		Count:      count,
+		Dispersion: dispersion,
+		Sec:        sec,
+		NSec:       nsec,
+	}
+}
+
 func TestClientInfoMarshalAndUnmarshal(t *testing.T) {
 	buf := make([]byte, ClientInfoSharedMemoryBufferSize)
 	c := ClientInfo{
@@ -33,7 +146,2239 @@ func TestClientInfoMarshalAndUnmarshal(t *testing.T) {
 
 	data, err := c.Marshal(buf)
 	assert.NoError(t, err)
+	assert.Len(t, data, clientInfoSize)
 	result := ClientInfo{}
 	assert.NoError(t, UnmarshalClientInfo(data, &result))
 	assert.Equal(t, c, result)
+
+	c.Seq = 0x01020304
+	data, err = c.Marshal(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, c.Seq, loadSeq(append([]byte{0, 0}, data...), Encoder))
+	assert.NoError(t, UnmarshalClientInfo(data, &result))
+	assert.Equal(t, c, result)
+}
+
+func TestUnmarshalLegacyClientInfo(t *testing.T) {
+	data := make([]byte, legacyClientInfoSize)
+	data[0] = 1
+	data[1] = 1
+	binary.BigEndian.PutUint16(data[2:], 123)
+	binary.BigEndian.PutUint64(data[4:], 3456789012)
+	binary.BigEndian.PutUint64(data[12:], 123456789)
+	binary.BigEndian.PutUint32(data[20:], 9876543)
+	result := ClientInfo{Seq: 100}
+	assert.NoError(t, UnmarshalLegacyClientInfo(data, &result))
+	assert.Equal(t, ClientInfo{
+		Valid:      true,
+		Locked:     true,
+		Count:      123,
+		Dispersion: 3456789012,
+		Sec:        123456789,
+		NSec:       9876543,
+	}, result)
+}
+
+func TestGetDataLen(t *testing.T) {
+	tests := []struct {
+		datalen uint16
+		result  uint16
+		err     error
+	}{
+		{0, 0, ErrUninitializedSegment},
+		{uint16(clientInfoSize), uint16(clientInfoSize), nil},
+		{uint16(legacyClientInfoSize), 0, ErrVersionMismatch},
+		{uint16(clientInfoSize) - 1, 0, ErrCorruptData},
+		{uint16(clientInfoSize) + 1, 0, ErrCorruptData},
+		{uint16(ClientInfoSharedMemoryBufferSize), 0, ErrCorruptData},
+		{0xFFFF, 0, ErrCorruptData},
+	}
+
+	for idx, tt := range tests {
+		buf := make([]byte, ClientInfoSharedMemoryBufferSize)
+		binary.BigEndian.PutUint16(buf, tt.datalen)
+		result, err := getDataLen(buf, 0, false, testLayout)
+		assert.Equal(t, tt.err, err, idx)
+		assert.Equal(t, tt.result, result, idx)
+	}
+
+	buf := make([]byte, ClientInfoSharedMemoryBufferSize)
+	binary.BigEndian.PutUint16(buf, uint16(legacyClientInfoSize))
+	result, err := getDataLen(buf, 0, false, testLegacyLayout)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(legacyClientInfoSize), result)
+}
+
+func TestVersionMismatch(t *testing.T) {
+	c := lockedInfo(1, 1000)
+	data, err := c.Marshal(make([]byte, clientInfoSize))
+	require.NoError(t, err)
+	data[2] = ClientInfoVersion + 1
+	assert.Equal(t, ErrVersionMismatch, UnmarshalClientInfo(data, &c))
+	data[2] = ClientInfoVersion
+	data[0] ^= 0xFF
+	assert.Equal(t, ErrVersionMismatch, UnmarshalClientInfo(data, &c))
+
+	// a clockd publishing the record of an incompatible version
+	d := newTestClockd(t)
+	d.write(func(region []byte) {
+		binary.BigEndian.PutUint16(region, uint16(clientInfoSize))
+		copy(region[2:], data)
+	})
+	cli := d.newClient()
+	_, err = cli.GetUnixTime()
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+	assert.Equal(t, StateNotReady, cli.State())
+}
+
+func TestLeapState(t *testing.T) {
+	for _, s := range []LeapState{LeapNone,
+		LeapSmearing, LeapPendingInsert, LeapPendingDelete} {
+		info := lockedInfo(1, 1000)
+		info.LeapState = s
+		data, err := info.Marshal(make([]byte, clientInfoSize))
+		require.NoError(t, err)
+		var v ClientInfo
+		require.NoError(t, UnmarshalClientInfo(data, &v))
+		assert.Equal(t, s, v.LeapState)
+		assert.NotEqual(t, "unknown", s.String())
+	}
+
+	info := lockedInfo(1, 1000)
+	data, err := info.Marshal(make([]byte, clientInfoSize))
+	require.NoError(t, err)
+	data[5] = uint8(LeapPendingDelete) + 1
+	var v ClientInfo
+	assert.ErrorIs(t, UnmarshalClientInfo(data, &v), ErrCorruptData)
+	assert.Equal(t, "unknown", LeapState(data[5]).String())
+
+	// readings are widened at the end of the day while a leap second is
+	// pending
+	d := newTestClockd(t)
+	c := d.newClient(WithClock(ClockFunc(func() (uint64, uint32) {
+		return 17167*secondsPerDay - 1, 5e8
+	})))
+	info.LeapState = LeapPendingInsert
+	info.Sec, info.NSec = 17167*secondsPerDay-1, 0
+	d.publish(info)
+	ut, err := c.GetUnixTimeInto(&v)
+	require.NoError(t, err)
+	assert.Equal(t, LeapPendingInsert, v.LeapState)
+	assert.Greater(t, ut.Dispersion, leapSecondUncertainty)
+}
+
+func TestLegacyLayout(t *testing.T) {
+	d := newTestClockd(t)
+	info := lockedInfo(1, 1000)
+	d.write(func(region []byte) {
+		binary.BigEndian.PutUint16(region, uint16(legacyClientInfoSize))
+		data := region[2:]
+		data[0] = 1
+		data[1] = 1
+		binary.BigEndian.PutUint16(data[2:], info.Count)
+		binary.BigEndian.PutUint64(data[4:], info.Dispersion)
+		binary.BigEndian.PutUint64(data[12:], info.Sec)
+		binary.BigEndian.PutUint32(data[20:], info.NSec)
+	})
+	c := d.newClient()
+	_, err := c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+
+	c = d.newClient(WithLegacyLayout())
+	result := ClientInfo{}
+	_, err = c.GetUnixTimeInto(&result)
+	require.NoError(t, err)
+	assert.Equal(t, info, result)
+}
+
+func TestGetDataLenDetectsChangingPrefix(t *testing.T) {
+	buf := make([]byte, ClientInfoSharedMemoryBufferSize)
+	binary.BigEndian.PutUint16(buf, uint16(clientInfoSize))
+	// the prefix observed before the copy was 0 while clockd was mid-update
+	_, err := getDataLen(buf, 0, true, testLayout)
+	assert.Equal(t, ErrCorruptData, err)
+	// a torn prefix with only the high byte updated
+	_, err = getDataLen(buf, uint16(clientInfoSize)<<8, true, testLayout)
+	assert.Equal(t, ErrCorruptData, err)
+	// without the double read option, the change goes unnoticed
+	result, err := getDataLen(buf, 0, false, testLayout)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(clientInfoSize), result)
+	// stable prefix
+	result, err = getDataLen(buf, uint16(clientInfoSize), true, testLayout)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(clientInfoSize), result)
+}
+
+func TestClientGetUnixTime(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	info := lockedInfo(1, 1000)
+	d.publish(info)
+	ut, err := c.GetUnixTime()
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, ut.Dispersion, info.Dispersion)
+	ref := UnixTime{Sec: info.Sec, NSec: info.NSec}
+	assert.GreaterOrEqual(t, ut.Sub(ref), int64(0))
+}
+
+func TestStrictValidationRejectsZeroDispersion(t *testing.T) {
+	d := newTestClockd(t)
+	strict := d.newClient(WithStrictValidation())
+	lenient := d.newClient()
+	d.publish(lockedInfo(1, 0))
+
+	_, err := strict.GetUnixTime()
+	assert.Equal(t, ErrImplausibleReading, err)
+	_, err = lenient.GetUnixTime()
+	assert.NoError(t, err)
+
+	// not locked records are reported as not ready in both modes
+	info := lockedInfo(2, 0)
+	info.Locked = false
+	d.publish(info)
+	_, err = strict.GetUnixTime()
+	assert.ErrorIs(t, err, ErrNotLocked)
+	_, err = lenient.GetUnixTime()
+	assert.ErrorIs(t, err, ErrNotLocked)
+
+	d.publish(lockedInfo(3, 100))
+	_, err = strict.GetUnixTime()
+	assert.NoError(t, err)
+}
+
+func TestGetUnixTimeInto(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	published := lockedInfo(1, 1000)
+	d.publish(published)
+
+	info := ClientInfo{}
+	ut, err := c.GetUnixTimeInto(&info)
+	require.NoError(t, err)
+	assert.Equal(t, published, info)
+	assert.Equal(t, dispersionOf(t, info, ut.Sec, ut.NSec), ut.Dispersion)
+
+	other, err := c.GetUnixTime()
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, other.Sub(ut), int64(0))
+	assert.GreaterOrEqual(t, other.Dispersion, ut.Dispersion)
+	lower, _ := ut.Bounds()
+	_, upper := other.Bounds()
+	assert.Less(t, lower, upper)
+}
+
+func TestGetUnixTimeIntoDoesNotAllocate(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	d.publish(lockedInfo(1, 1000))
+
+	info := ClientInfo{}
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := c.GetUnixTimeInto(&info); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func TestReadPathDoesNotAllocate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		publish func(d *testClockd, info ClientInfo)
+	}{
+		{"semaphore", nil, (*testClockd).publish},
+		{"double read", []Option{WithDoubleRead()}, (*testClockd).publish},
+		{"seqlock", []Option{WithSeqlock()}, (*testClockd).publishSeqlock},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestClockd(t)
+			c := d.newClient(append(tt.opts, WithStaleThreshold(time.Hour))...)
+			tt.publish(d, lockedInfo(1, 1000))
+			ctx := context.Background()
+			allocs := testing.AllocsPerRun(100, func() {
+				if _, err := c.GetUnixTime(); err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if _, err := c.GetUnixTimeContext(ctx); err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+			})
+			assert.Equal(t, float64(0), allocs)
+		})
+	}
+}
+
+func TestOutOfRangeNSecIsRejected(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	for _, nsec := range []uint32{1e9, 1e9 + 1} {
+		info := lockedInfo(1, 1000)
+		info.Sec--
+		info.NSec = nsec
+		d.publish(info)
+		_, err := c.GetUnixTime()
+		assert.Equal(t, ErrCorruptData, err, nsec)
+	}
+	info := lockedInfo(2, 1000)
+	info.Sec--
+	info.NSec = 1e9 - 1
+	d.publish(info)
+	_, err := c.GetUnixTime()
+	assert.NoError(t, err)
+}
+
+func TestWaitForNewReading(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	d.publish(lockedInfo(1, 1000))
+	prev, err := c.GetUnixTime()
+	require.NoError(t, err)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		d.publish(lockedInfo(2, 1000000))
+	}()
+	ut, err := c.WaitForNewReading(context.Background(), prev)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, ut.Dispersion, uint64(1000000))
+	assert.Greater(t, ut.Sub(prev), int64(0))
+	assert.Equal(t, uint16(2), c.last.count)
+}
+
+func TestWaitForNewReadingDegraded(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient(WithDegradedThreshold(time.Second))
+	// the reference is frozen, all readings are degraded
+	info := lockedInfo(1, 1000)
+	info.Sec -= 2
+	d.publish(info)
+	prev, err := c.GetUnixTime()
+	require.ErrorIs(t, err, ErrDegraded)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		info.Count++
+		d.publish(info)
+	}()
+	ut, err := c.WaitForNewReading(context.Background(), prev)
+	assert.ErrorIs(t, err, ErrDegraded)
+	assert.False(t, ut.IsEmpty())
+	assert.Equal(t, uint16(2), c.last.count)
+}
+
+func TestWaitForNewReadingIsCanceled(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	d.publish(lockedInfo(1, 1000))
+	prev, err := c.GetUnixTime()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = c.WaitForNewReading(ctx, prev)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestFrozenReferenceIsReportedAsDegraded(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient(WithDegradedThreshold(time.Second))
+	plain := d.newClient()
+	info := lockedInfo(1, 1000)
+	info.Sec -= 2
+	d.publish(info)
+
+	ut, err := c.GetUnixTime()
+	assert.Equal(t, ErrDegraded, err)
+	assert.False(t, ut.IsEmpty())
+	assert.Greater(t, ut.Dispersion, info.Dispersion+GetClockUncertainty(2e9)-1)
+	ut, err = plain.GetUnixTime()
+	assert.NoError(t, err)
+	assert.False(t, ut.IsEmpty())
+
+	d.publish(lockedInfo(2, 1000))
+	_, err = c.GetUnixTime()
+	assert.NoError(t, err)
+}
+
+func TestMaxUsableDispersion(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient(WithMaxUsableDispersion(time.Millisecond),
+		WithDegradedThreshold(time.Second))
+	d.publish(lockedInfo(1, 1000))
+	_, err := c.GetUnixTime()
+	require.NoError(t, err)
+
+	d.publish(lockedInfo(2, uint64(time.Millisecond)+1))
+	ut, err := c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrDispersionTooLarge)
+	assert.Greater(t, ut.Dispersion, uint64(time.Millisecond))
+	assert.False(t, ut.IsEmpty())
+	assert.Equal(t, StateDegraded, c.State())
+	_, err = c.After(UnixTime{})
+	assert.ErrorIs(t, err, ErrDispersionTooLarge)
+
+	// takes precedence over ErrDegraded
+	info := lockedInfo(3, 1000)
+	info.Sec -= 2
+	d.publish(info)
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrDispersionTooLarge)
+
+	d.publish(lockedInfo(4, 1000))
+	_, err = c.GetUnixTime()
+	assert.NoError(t, err)
+	assert.Equal(t, StateReady, c.State())
+
+	_, err = NewClient(d.lockPath, d.shmKey, WithMaxUsableDispersion(-1))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestNeverReadyIsDistinguished(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	// clockd never published anything
+	_, err := c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrNeverReady)
+	assert.ErrorIs(t, err, ErrNotReady)
+
+	d.publish(lockedInfo(1, 1000))
+	_, err = c.GetUnixTime()
+	require.NoError(t, err)
+
+	// clockd was working but lost its lock
+	info := lockedInfo(2, 1000)
+	info.Locked = false
+	d.publish(info)
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrNotReady)
+	assert.ErrorIs(t, err, ErrNotLocked)
+	assert.NotErrorIs(t, err, ErrNeverReady)
+}
+
+func TestNotReadyCausesAreDistinguished(t *testing.T) {
+	assert.True(t, errors.Is(ErrUninitializedSegment, ErrNotReady))
+	assert.True(t, errors.Is(ErrNotLocked, ErrNotReady))
+	assert.False(t, errors.Is(ErrUninitializedSegment, ErrNotLocked))
+	assert.False(t, errors.Is(ErrNotLocked, ErrUninitializedSegment))
+
+	// segment never written
+	buf := make([]byte, ClientInfoSharedMemoryBufferSize)
+	_, err := getDataLen(buf, 0, false, testLayout)
+	assert.ErrorIs(t, err, ErrUninitializedSegment)
+
+	// written but not valid or not locked
+	d := newTestClockd(t)
+	c := d.newClient()
+	for _, flags := range [][2]bool{{false, false}, {false, true}, {true, false}} {
+		info := lockedInfo(1, 1000)
+		info.Valid, info.Locked = flags[0], flags[1]
+		d.publish(info)
+		_, err := c.GetUnixTime()
+		assert.ErrorIs(t, err, ErrNotLocked, flags)
+		assert.True(t, errors.Is(err, ErrNotReady), flags)
+	}
+}
+
+func TestGetWithOSComparison(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	info := lockedInfo(1, 1000)
+	d.publish(info)
+
+	ut, os, err := c.GetWithOSComparison()
+	require.NoError(t, err)
+	assert.Equal(t, int64(ut.Sec), os.Unix())
+	assert.Equal(t, int64(ut.NSec), int64(os.Nanosecond()))
+	sec, nsec := uint64(os.Unix()), uint32(os.Nanosecond())
+	assert.Equal(t, dispersionOf(t, info, sec, nsec), ut.Dispersion)
+
+	info.Valid = false
+	d.publish(info)
+	_, _, err = c.GetWithOSComparison()
+	assert.ErrorIs(t, err, ErrNotLocked)
+}
+
+func TestMinReadsBeforeTrust(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient(WithMinReadsBeforeTrust(3))
+	d.publish(lockedInfo(1, 1000))
+	_, err := c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrNotTrusted)
+	assert.True(t, errors.Is(err, ErrNotReady))
+	// same count with a different dispersion is inconsistent
+	d.publish(lockedInfo(1, 2000))
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrNotTrusted)
+	d.publish(lockedInfo(2, 2000))
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrNotTrusted)
+	// rejected readings are not recorded
+	assert.Empty(t, c.Recent())
+	// third consecutive consistent read
+	ut, err := c.GetUnixTime()
+	assert.NoError(t, err)
+	assert.Equal(t, []UnixTime{ut}, c.Recent())
+	// trusted from now on
+	d.publish(lockedInfo(2, 3000))
+	_, err = c.GetUnixTime()
+	assert.NoError(t, err)
+	assert.Len(t, c.Recent(), 1)
+	d.publish(lockedInfo(3, 3000))
+	next, err := c.GetUnixTime()
+	assert.NoError(t, err)
+	assert.Equal(t, []UnixTime{ut, next}, c.Recent())
+}
+
+func TestMinReadsBeforeTrustRestartsAfterError(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient(WithMinReadsBeforeTrust(3))
+	d.publish(lockedInfo(1, 1000))
+	_, err := c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrNotTrusted)
+	d.publish(lockedInfo(2, 1000))
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrNotTrusted)
+	info := lockedInfo(3, 1000)
+	info.Locked = false
+	d.publish(info)
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrNotLocked)
+	for i := 0; i < 2; i++ {
+		d.publish(lockedInfo(uint16(4+i), 1000))
+		_, err = c.GetUnixTime()
+		assert.ErrorIs(t, err, ErrNotTrusted)
+	}
+	_, err = c.GetUnixTime()
+	assert.NoError(t, err)
+}
+
+func TestReadErrorIsNotOverwrittenByPost(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	// never written segment
+	_, err := c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrUninitializedSegment)
+	// bad datalen
+	d.write(func(data []byte) {
+		binary.BigEndian.PutUint16(data, 0xFFFF)
+	})
+	_, err = c.GetUnixTime()
+	assert.Equal(t, ErrCorruptData, err)
+	// the semaphore was posted after both failed reads
+	d.publish(lockedInfo(1, 1000))
+	_, err = c.GetUnixTime()
+	assert.NoError(t, err)
+}
+
+func TestSamplePlacement(t *testing.T) {
+	const delay = uint64(100000)
+	d := newTestClockd(t)
+	info := lockedInfo(1, 1000)
+	d.publish(info)
+	ref, _ := (&UnixTime{Sec: info.Sec, NSec: info.NSec}).Bounds()
+	start := ref + uint64(time.Millisecond)
+	// the copy takes exactly delay nanoseconds, its midpoint is the truth
+	truth := start + delay/2
+
+	bias := func(placement SamplePlacement) (int64, UnixTime) {
+		c := d.newClient(WithSamplePlacement(placement))
+		now := start
+		c.cfg.clock = ClockFunc(func() (uint64, uint32) {
+			return now / 1e9, uint32(now % 1e9)
+		})
+		c.afterCopy = func() {
+			now += delay
+		}
+		ut, err := c.GetUnixTime()
+		require.NoError(t, err)
+		return ut.Sub(UnixTime{Sec: truth / 1e9, NSec: uint32(truth % 1e9)}), ut
+	}
+
+	before, beforeUT := bias(SampleBeforeCopy)
+	after, afterUT := bias(SampleAfterCopy)
+	bracketed, bracketedUT := bias(SampleBracket)
+	assert.Equal(t, -int64(delay/2), before)
+	assert.Equal(t, int64(delay/2), after)
+	assert.Equal(t, int64(0), bracketed)
+	// bracketing widens the dispersion to cover the whole copy
+	assert.GreaterOrEqual(t, bracketedUT.Dispersion, beforeUT.Dispersion+delay/2)
+	lower, upper := bracketedUT.Bounds()
+	bl, _ := beforeUT.Bounds()
+	_, au := afterUT.Bounds()
+	assert.LessOrEqual(t, lower, bl)
+	assert.GreaterOrEqual(t, upper+GetClockUncertainty(int64(delay)), au)
+}
+
+func TestGetUnixTimeBreakdown(t *testing.T) {
+	d := newTestClockd(t)
+	info := lockedInfo(1, 1000)
+	d.publish(info)
+	ref := int64(info.Sec)*1e9 + int64(info.NSec)
+	now := ref + int64(time.Second)
+	clock := WithClock(ClockFunc(func() (uint64, uint32) {
+		return uint64(now / 1e9), uint32(now % 1e9)
+	}))
+	c := d.newClient(clock)
+	ut, b, err := c.GetUnixTimeBreakdown()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1000), b.Clockd)
+	assert.Equal(t, GetClockUncertainty(int64(time.Second)), b.Local)
+	assert.Zero(t, b.Read)
+	assert.Equal(t, ut.Dispersion, b.Total())
+
+	// the bracketed samples are accounted as added by the read, the midpoint
+	// is used with half of their span as dispersion
+	c = d.newClient(clock, WithSamplePlacement(SampleBracket))
+	c.afterCopy = func() {
+		now += 100
+	}
+	ut, b, err = c.GetUnixTimeBreakdown()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1000), b.Clockd)
+	assert.Equal(t, uint64(50), b.Read)
+	assert.Equal(t, ut.Dispersion, b.Total())
+
+	// huge dispersions are saturated
+	d.publish(lockedInfo(2, math.MaxUint64-1))
+	ut, b, err = c.GetUnixTimeBreakdown()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(math.MaxUint64), ut.Dispersion)
+	assert.Equal(t, uint64(math.MaxUint64-1), b.Clockd)
+	assert.Zero(t, b.Read)
+	assert.Equal(t, ut.Dispersion, b.Total())
+
+	info.Locked = false
+	d.publish(info)
+	ut, b, err = c.GetUnixTimeBreakdown()
+	assert.ErrorIs(t, err, ErrNotLocked)
+	assert.True(t, ut.IsEmpty())
+	assert.Equal(t, DispersionBreakdown{}, b)
+}
+
+func TestReadDispersionSaturates(t *testing.T) {
+	d := newTestClockd(t)
+	d.publish(lockedInfo(1, 0))
+	model := DriftModelFunc(func(int64) uint64 {
+		return math.MaxUint64 - 1
+	})
+	c := d.newClient(WithDriftModel(model),
+		WithSamplePlacement(SampleBracket))
+	c.afterCopy = func() {
+		time.Sleep(time.Microsecond)
+	}
+	ut, err := c.GetUnixTime()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(math.MaxUint64), ut.Dispersion)
+}
+
+func TestSyncClientConcurrentReads(t *testing.T) {
+	d := newTestClockd(t)
+	c, err := NewSyncClient(d.lockPath, d.shmKey)
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, c.Close())
+	}()
+	d.publish(lockedInfo(1, 1000))
+
+	var wg sync.WaitGroup
+	var failures atomic.Uint32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if j%50 == 0 && i == 0 {
+					d.publish(lockedInfo(uint16(j+2), 1000))
+				}
+				if _, err := c.GetUnixTime(); err != nil {
+					failures.Add(1)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, uint32(0), failures.Load())
+}
+
+func TestGetUnixTimeContext(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	d.publish(lockedInfo(1, 1000))
+
+	ut, err := c.GetUnixTimeContext(context.Background())
+	require.NoError(t, err)
+	assert.False(t, ut.IsEmpty())
+
+	// a wedged clockd holding the semaphore
+	require.NoError(t, d.mutex.Wait())
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = c.GetUnixTimeContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, StateReady, c.state)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = c.GetUnixTimeContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	require.NoError(t, d.mutex.Post())
+	_, err = c.GetUnixTimeContext(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestLockTimeout(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient(WithLockTimeout(20 * time.Millisecond))
+	d.publish(lockedInfo(1, 1000))
+	_, err := c.GetUnixTime()
+	require.NoError(t, err)
+
+	require.NoError(t, d.mutex.Wait())
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrStopped)
+	assert.Equal(t, StateStopped, c.state)
+
+	require.NoError(t, d.mutex.Post())
+	_, err = c.GetUnixTime()
+	assert.NoError(t, err)
+}
+
+func TestLockRecovery(t *testing.T) {
+	var recovered int
+	d := newTestClockd(t)
+	c := d.newClient(WithLockRecovery(20*time.Millisecond, func() {
+		recovered++
+	}))
+	d.publish(lockedInfo(1, 1000))
+
+	// clockd died while holding the semaphore
+	require.NoError(t, d.mutex.Wait())
+	_, err := c.GetUnixTime()
+	require.NoError(t, err)
+	assert.Equal(t, 1, recovered)
+	_, err = c.GetUnixTime()
+	require.NoError(t, err)
+	assert.Equal(t, 1, recovered)
+
+	// the recovered semaphore is held by the next incarnation of clockd which
+	// dies again, reads using a cancellable context recover from it as well
+	m, err := NewSemaphore(d.lockPath, 0600, 1)
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, m.Close())
+	}()
+	require.NoError(t, m.Wait())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = c.GetUnixTimeContext(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, recovered)
+}
+
+func TestTryLock(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient(WithTryLock())
+	d.publish(lockedInfo(1, 1000))
+	_, err := c.GetUnixTime()
+	require.NoError(t, err)
+
+	require.NoError(t, d.mutex.Wait())
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrBusy)
+	assert.ErrorIs(t, err, ErrNotReady)
+	assert.False(t, c.resetRequired)
+
+	require.NoError(t, d.mutex.Post())
+	_, err = c.GetUnixTime()
+	assert.NoError(t, err)
+}
+
+func TestAfterAndBefore(t *testing.T) {
+	now := UnixTime{Sec: 100, NSec: 1000, Dispersion: 100}
+	tests := []struct {
+		name   string
+		ut     UnixTime
+		after  bool
+		before bool
+	}{
+		{"same", now, false, false},
+		{"overlapping earlier", UnixTime{Sec: 100, NSec: 850, Dispersion: 100}, false, false},
+		{"overlapping later", UnixTime{Sec: 100, NSec: 1150, Dispersion: 100}, false, false},
+		{"adjacent earlier", UnixTime{Sec: 100, NSec: 800, Dispersion: 100}, false, false},
+		{"adjacent later", UnixTime{Sec: 100, NSec: 1200, Dispersion: 100}, false, false},
+		{"disjoint earlier", UnixTime{Sec: 100, NSec: 799, Dispersion: 100}, true, false},
+		{"disjoint later", UnixTime{Sec: 100, NSec: 1201, Dispersion: 100}, false, true},
+		{"previous second", UnixTime{Sec: 99, NSec: 999999999}, true, false},
+		{"next second", UnixTime{Sec: 101}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.after, after(now, tt.ut))
+			assert.Equal(t, tt.before, before(now, tt.ut))
+		})
+	}
+}
+
+func TestClientAfterAndBefore(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	_, err := c.After(UnixTime{})
+	assert.ErrorIs(t, err, ErrNotReady)
+
+	d.publish(lockedInfo(1, 1000))
+	now, err := c.GetUnixTime()
+	require.NoError(t, err)
+	ok, err := c.After(UnixTime{Sec: now.Sec - 1})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = c.Before(UnixTime{Sec: now.Sec - 1})
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = c.Before(UnixTime{Sec: now.Sec + 60})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = c.After(UnixTime{Sec: now.Sec + 60})
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestCommitWaitedTimestampsAreOrdered(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	prev := uint64(0)
+	for i := 0; i < 20; i++ {
+		// a wide dispersion makes inverted tokens likely without the wait
+		d.publish(lockedInfo(uint16(i+1), uint64(i%4)*uint64(time.Millisecond)))
+		ut, err := c.GetUnixTime()
+		require.NoError(t, err)
+		ts := ut.CommitTimestamp()
+		assert.Greater(t, ts, prev, i)
+		require.NoError(t, c.WaitUntilPast(context.Background(), ut))
+		prev = ts
+	}
+}
+
+func TestWaitUntilPast(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	assert.ErrorIs(t, c.WaitUntilPast(context.Background(), UnixTime{}),
+		ErrNotReady)
+
+	d.publish(lockedInfo(1, 1000))
+	now, err := c.GetUnixTime()
+	require.NoError(t, err)
+	ut := now
+	ut.NSec += 5000000
+	if ut.NSec >= 1e9 {
+		ut.Sec++
+		ut.NSec -= 1e9
+	}
+	require.NoError(t, c.WaitUntilPast(context.Background(), ut))
+	ok, err := c.After(ut)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ut.Sec += 60
+	assert.ErrorIs(t, c.WaitUntilPast(ctx, ut), context.DeadlineExceeded)
+}
+
+// countingSource counts the reads made on the wrapped TimeSource.
+type countingSource struct {
+	source TimeSource
+	reads  int
+}
+
+func (s *countingSource) GetUnixTime() (UnixTime, error) {
+	s.reads++
+	return s.source.GetUnixTime()
+}
+
+func TestWaitUntilSleepsOnce(t *testing.T) {
+	d := newTestClockd(t)
+	d.publish(lockedInfo(1, 1000))
+	c := d.newClient(WithStaleThreshold(time.Hour))
+	now, err := c.GetUnixTime()
+	require.NoError(t, err)
+	_, upper := now.Bounds()
+	src := &countingSource{source: c}
+	target := upper + uint64(5*time.Millisecond)
+	require.NoError(t, waitUntil(context.Background(), src, target))
+	// a single computed sleep followed by a confirming read
+	assert.Equal(t, 2, src.reads)
+}
+
+func TestWaitUntilUsesDegradedReadings(t *testing.T) {
+	d := newTestClockd(t)
+	info := lockedInfo(1, 1000)
+	info.Sec--
+	d.publish(info)
+	c := d.newClient(WithDegradedThreshold(time.Millisecond),
+		WithStaleThreshold(time.Hour))
+	now, err := c.GetUnixTime()
+	require.ErrorIs(t, err, ErrDegraded)
+	require.NoError(t, c.WaitUntilPast(context.Background(), now))
+}
+
+func TestWaitDuration(t *testing.T) {
+	gap := uint64(5 * time.Millisecond)
+	d := waitDuration(gap, LinearDriftModel(MaxClockDrift))
+	// the lower bound advances by at least gap after sleeping d
+	assert.GreaterOrEqual(t,
+		uint64(d)-GetClockUncertainty(int64(d)), gap)
+	assert.Less(t, d, time.Duration(gap)+10*time.Microsecond)
+	assert.Equal(t, time.Duration(math.MaxInt64),
+		waitDuration(math.MaxUint64, LinearDriftModel(MaxClockDrift)))
+}
+
+func TestMaxClockDrift(t *testing.T) {
+	d := newTestClockd(t)
+	for _, ppb := range []int64{0, -1, maxClockDriftCeiling + 1} {
+		_, err := NewClient(d.lockPath, d.shmKey, WithMaxClockDrift(ppb))
+		assert.ErrorIs(t, err, ErrInvalidOption)
+	}
+
+	info := lockedInfo(1, 1000)
+	d.publish(info)
+	for _, ppb := range []int64{50000, MaxClockDrift, maxClockDriftCeiling} {
+		c := d.newClient(WithMaxClockDrift(ppb))
+		c.cfg.clock = ClockFunc(func() (uint64, uint32) {
+			return info.Sec + 1, info.NSec
+		})
+		ut, err := c.GetUnixTime()
+		require.NoError(t, err)
+		assert.Equal(t, 1000+uint64(ppb), ut.Dispersion)
+	}
+}
+
+func TestNewClientWithOptions(t *testing.T) {
+	d := newTestClockd(t)
+	d.publish(lockedInfo(1, 1000))
+	c, err := NewClientWithOptions(WithLockPath(d.lockPath),
+		WithShmKey(d.shmKey), WithMaxClockDrift(50000))
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, c.Close())
+	}()
+	assert.Equal(t, d.lockPath, c.lockPath)
+	assert.Equal(t, d.shmKey, c.shmKey)
+	assert.Equal(t, int64(50000), c.cfg.maxClockDrift)
+	_, err = c.GetUnixTime()
+	assert.NoError(t, err)
+
+	_, err = NewClientWithOptions(WithLockPath(""))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Option
+	}{
+		{"stale threshold", WithStaleThreshold(0)},
+		{"reconnect backoff",
+			WithReconnectBackoff(time.Second, time.Millisecond)},
+		{"lock recovery", WithLockRecovery(-1, nil)},
+		{"max usable dispersion", WithMaxUsableDispersion(-1)},
+		{"fallback dispersion", WithFallbackDispersion(-1)},
+		{"history size", WithHistorySize(-1)},
+		{"semaphore mode", WithSemaphoreMode(01000)},
+		{"buffer size", WithBufferSize(minBufferSize - 1)},
+		{"byte order", WithByteOrder(nil)},
+		{"max clock drift", WithMaxClockDrift(-1)},
+		{"sample placement", WithSamplePlacement(SampleBracket + 1)},
+		{"negative sample placement", WithSamplePlacement(-1)},
+		{"lock timeout", WithLockTimeout(-1)},
+		{"degraded threshold", WithDegradedThreshold(-1)},
+		{"min reads before trust", WithMinReadsBeforeTrust(-1)},
+		{"linear drift model", WithDriftModel(LinearDriftModel(-1))},
+		{"affine drift model", WithDriftModel(AffineDriftModel{PPB: -1})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newMemRegion(ClientInfoSharedMemoryBufferSize)
+			_, err := NewClientWithOptions(WithShmRegion(r, &r.mu), tt.opt)
+			assert.ErrorIs(t, err, ErrInvalidOption)
+		})
+	}
+
+	// the boundaries are valid
+	r := newMemRegion(ClientInfoSharedMemoryBufferSize)
+	c, err := NewClientWithOptions(WithShmRegion(r, &r.mu),
+		WithSamplePlacement(SampleBracket), WithLockTimeout(0),
+		WithDegradedThreshold(0), WithMinReadsBeforeTrust(0),
+		WithDriftModel(AffineDriftModel{}))
+	require.NoError(t, err)
+	assert.NoError(t, c.Close())
+}
+
+func TestStaleThreshold(t *testing.T) {
+	d := newTestClockd(t)
+	_, err := NewClient(d.lockPath, d.shmKey, WithStaleThreshold(0))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+
+	info := lockedInfo(1, 1000)
+	d.publish(info)
+	tests := []struct {
+		opts    []Option
+		elapsed time.Duration
+		stopped bool
+	}{
+		{nil, 200 * time.Millisecond, false},
+		{nil, 400 * time.Millisecond, true},
+		{[]Option{WithStaleThreshold(100 * time.Millisecond)}, 200 * time.Millisecond, true},
+		{[]Option{WithStaleThreshold(time.Second)}, 900 * time.Millisecond, false},
+	}
+	for idx, tt := range tests {
+		var elapsed time.Duration
+		clock := ClockFunc(func() (uint64, uint32) {
+			ns := int64(info.Sec)*1e9 + int64(info.NSec) + int64(elapsed)
+			return uint64(ns / 1e9), uint32(ns % 1e9)
+		})
+		c := d.newClient(append(tt.opts, WithClock(clock))...)
+		_, err := c.GetUnixTime()
+		require.NoError(t, err)
+		elapsed = tt.elapsed
+		_, err = c.GetUnixTime()
+		assert.Equal(t, tt.stopped, errors.Is(err, ErrStopped), idx)
+	}
+}
+
+func TestClockInversion(t *testing.T) {
+	d := newTestClockd(t)
+	info := lockedInfo(1, 1000)
+	d.publish(info)
+	var behind time.Duration
+	c := d.newClient(WithClock(ClockFunc(func() (uint64, uint32) {
+		ns := int64(info.Sec)*1e9 + int64(info.NSec) - int64(behind)
+		return uint64(ns / 1e9), uint32(ns % 1e9)
+	})))
+	_, err := c.GetUnixTime()
+	require.NoError(t, err)
+
+	// the local clock momentarily behind clockd's reference is an error
+	behind = time.Millisecond
+	ut, err := c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrClockInversion)
+	assert.True(t, ut.IsEmpty())
+	assert.Equal(t, StateNotReady, c.state)
+
+	// reads recover once the clocks agree again
+	behind = 0
+	_, err = c.GetUnixTime()
+	assert.NoError(t, err)
+}
+
+func TestStoppedError(t *testing.T) {
+	d := newTestClockd(t)
+	info := lockedInfo(7, 1000)
+	d.publish(info)
+	c := d.newClient()
+	var elapsed time.Duration
+	c.cfg.clock = ClockFunc(func() (uint64, uint32) {
+		ns := int64(info.Sec)*1e9 + int64(info.NSec) + int64(elapsed)
+		return uint64(ns / 1e9), uint32(ns % 1e9)
+	})
+	_, err := c.GetUnixTime()
+	require.NoError(t, err)
+	elapsed = 400 * time.Millisecond
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrStopped)
+	var se *StoppedError
+	require.ErrorAs(t, err, &se)
+	assert.Equal(t, uint16(7), se.Count)
+	assert.Equal(t, uint16(7), se.LastCount)
+	assert.Equal(t, 400*time.Millisecond, se.Frozen)
+	assert.Equal(t, "bounded time service stopped: count 7 frozen for 400ms",
+		se.Error())
+	assert.Equal(t, StateStopped, c.state)
+}
+
+func TestReadError(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	// nothing observed yet
+	_, err := c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrUninitializedSegment)
+	assert.ErrorIs(t, err, ErrNotReady)
+	var re *ReadError
+	require.ErrorAs(t, err, &re)
+	assert.False(t, re.Seen)
+	assert.Equal(t, c.lastLatency, re.Latency)
+	assert.Contains(t, err.Error(), "no count observed")
+
+	d.publish(lockedInfo(5, 1000))
+	_, err = c.GetUnixTime()
+	require.NoError(t, err)
+	info := lockedInfo(6, 1000)
+	info.Locked = false
+	d.publish(info)
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrNotLocked)
+	require.ErrorAs(t, err, &re)
+	assert.Equal(t, ErrNotLocked, re.Err)
+	assert.True(t, re.Seen)
+	assert.Equal(t, uint16(5), re.LastCount)
+	assert.Equal(t, c.lastLatency, re.Latency)
+	assert.Contains(t, err.Error(), "last count 5")
+
+	// stopped errors are wrapped as well
+	info = lockedInfo(7, 1000)
+	d.publish(info)
+	var elapsed time.Duration
+	c.cfg.clock = ClockFunc(func() (uint64, uint32) {
+		ns := int64(info.Sec)*1e9 + int64(info.NSec) + int64(elapsed)
+		return uint64(ns / 1e9), uint32(ns % 1e9)
+	})
+	_, err = c.GetUnixTime()
+	require.NoError(t, err)
+	elapsed = time.Second
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrStopped)
+	require.ErrorAs(t, err, &re)
+	assert.Equal(t, uint16(7), re.LastCount)
+	var se *StoppedError
+	require.ErrorAs(t, err, &se)
+	assert.Equal(t, uint16(7), se.Count)
+}
+
+func TestGetUnixTimeNoStaleCheck(t *testing.T) {
+	d := newTestClockd(t)
+	info := lockedInfo(7, 1000)
+	d.publish(info)
+	c := d.newClient()
+	var elapsed time.Duration
+	c.cfg.clock = ClockFunc(func() (uint64, uint32) {
+		ns := int64(info.Sec)*1e9 + int64(info.NSec) + int64(elapsed)
+		return uint64(ns / 1e9), uint32(ns % 1e9)
+	})
+	_, err := c.GetUnixTime()
+	require.NoError(t, err)
+	elapsed = time.Second
+	ut, err := c.GetUnixTimeNoStaleCheck()
+	require.NoError(t, err)
+	assert.Equal(t, info.Sec+1, ut.Sec)
+	assert.Equal(t, StateReady, c.state)
+	// the default behavior is unchanged
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrStopped)
+	// records not locked are still rejected
+	info.Locked = false
+	d.publish(info)
+	_, err = c.GetUnixTimeNoStaleCheck()
+	assert.ErrorIs(t, err, ErrNotLocked)
+}
+
+func TestClone(t *testing.T) {
+	d := newTestClockd(t)
+	d.publish(lockedInfo(1, 1000))
+	c := d.newClient(WithCleanupOnClose())
+	_, err := c.GetUnixTime()
+	require.NoError(t, err)
+	clone, err := c.Clone()
+	require.NoError(t, err)
+	assert.Equal(t, c.lockPath, clone.lockPath)
+	assert.Equal(t, c.shmKey, clone.shmKey)
+	assert.False(t, clone.synced)
+	assert.False(t, clone.cfg.cleanupOnClose)
+	assert.NotSame(t, &c.buf[0], &clone.buf[0])
+	assert.Zero(t, clone.last.mono)
+	_, err = clone.GetUnixTime()
+	require.NoError(t, err)
+	// closing the clone doesn't affect c
+	require.NoError(t, clone.Close())
+	assert.False(t, clone.Attached())
+	assert.True(t, c.Attached())
+	d.publish(lockedInfo(2, 1000))
+	_, err = c.GetUnixTime()
+	assert.NoError(t, err)
+	sem, err := OpenSemaphore(d.lockPath)
+	require.NoError(t, err)
+	assert.NoError(t, sem.Close())
+	// the semaphore is unlinked by d
+	c.cfg.cleanupOnClose = false
+}
+
+func TestCloneCallbacks(t *testing.T) {
+	d := newTestClockd(t)
+	var changes atomic.Int32
+	c := d.newClient(WithStateChangeCallback(func(State, State) {
+		changes.Add(1)
+	}))
+	var updates int
+	c.OnUpdate(func(UnixTime) {
+		updates++
+	})
+	clone, err := c.Clone()
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, clone.Close())
+	}()
+
+	// the option set callback is inherited, OnUpdate is not
+	d.publish(lockedInfo(1, 1000))
+	_, err = clone.GetUnixTime()
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), changes.Load())
+	assert.Equal(t, 0, updates)
+	_, err = c.GetUnixTime()
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), changes.Load())
+	assert.Equal(t, 1, updates)
+}
+
+func TestCloneSyncClient(t *testing.T) {
+	d := newTestClockd(t)
+	d.publish(lockedInfo(1, 1000))
+	c, err := NewSyncClient(d.lockPath, d.shmKey)
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, c.Close())
+	}()
+	clone, err := c.Clone()
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, clone.Close())
+	}()
+	assert.True(t, clone.synced)
+	_, err = clone.GetUnixTime()
+	assert.NoError(t, err)
+}
+
+func TestOnReconnectAndOnDisconnect(t *testing.T) {
+	d := newTestClockd(t)
+	d.publish(lockedInfo(1, 1000))
+	c := d.newClient()
+	var reconnects, disconnects []error
+	c.OnReconnect(func(err error) {
+		reconnects = append(reconnects, err)
+	})
+	c.OnDisconnect(func(err error) {
+		disconnects = append(disconnects, err)
+	})
+	_, err := c.GetUnixTime()
+	require.NoError(t, err)
+	assert.Empty(t, reconnects)
+	assert.Empty(t, disconnects)
+
+	info := lockedInfo(2, 1000)
+	info.Locked = false
+	d.publish(info)
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrNotLocked)
+	assert.Empty(t, reconnects)
+	assert.Equal(t, []error{ErrNotLocked}, disconnects)
+
+	d.publish(lockedInfo(3, 1000))
+	_, err = c.GetUnixTime()
+	require.NoError(t, err)
+	_, err = c.GetUnixTime()
+	require.NoError(t, err)
+	assert.Equal(t, []error{ErrNotLocked}, reconnects)
+	assert.Equal(t, []error{ErrNotLocked}, disconnects)
+}
+
+func TestOnDisconnectNotRepeatedWhileDetached(t *testing.T) {
+	d := newTestClockd(t)
+	// a segment removed by the test, the semaphore of d guards it
+	seg, err := openSegment(d.shmKey^0x01000000, ClientInfoSharedMemoryBufferSize)
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, seg.detach())
+	}()
+	d = &testClockd{t: t, lockPath: d.lockPath, shmKey: d.shmKey ^ 0x01000000,
+		seg: seg, mutex: d.mutex, data: seg.data}
+	d.publish(lockedInfo(1, 1000))
+	c := d.newClient(WithStrictAttach(), WithReconnectBackoff(0, 0))
+	disconnects := 0
+	c.OnDisconnect(func(err error) {
+		disconnects++
+	})
+	reconnects := 0
+	c.OnReconnect(func(err error) {
+		reconnects++
+	})
+	// the record is reported as corrupted and the segment is gone
+	d.write(func(data []byte) {
+		binary.BigEndian.PutUint16(data, 1)
+	})
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrCorruptData)
+	require.NoError(t, seg.remove())
+	for i := 0; i < 3; i++ {
+		_, err = c.GetUnixTime()
+		assert.ErrorIs(t, err, ErrNoSegment)
+	}
+	assert.Equal(t, 1, disconnects)
+	assert.Zero(t, reconnects)
+}
+
+func TestPreTouch(t *testing.T) {
+	d := newTestClockd(t)
+	d.publish(lockedInfo(1, 1000))
+	c := d.newClient(WithPreTouch())
+	// the region has been read when attaching
+	assert.Equal(t, d.data[:len(c.buf)], c.buf)
+	_, err := c.GetUnixTime()
+	assert.NoError(t, err)
+}
+
+// steppedClock is a MonotonicClock whose wall clock readings can be stepped
+// independently of its monotonic readings.
+type steppedClock struct {
+	wall time.Duration
+	mono time.Duration
+}
+
+func (s *steppedClock) Now() (uint64, uint32) {
+	ns := int64(s.wall)
+	return uint64(ns / 1e9), uint32(ns % 1e9)
+}
+
+func (s *steppedClock) Monotonic() time.Duration {
+	return s.mono
+}
+
+func TestStalenessIgnoresWallClockSteps(t *testing.T) {
+	d := newTestClockd(t)
+	info := lockedInfo(7, 1000)
+	d.publish(info)
+	clock := &steppedClock{
+		wall: time.Duration(info.Sec)*time.Second + time.Duration(info.NSec) +
+			2*time.Hour,
+		mono: time.Hour,
+	}
+	c := d.newClient(WithClock(clock))
+	_, err := c.GetUnixTime()
+	require.NoError(t, err)
+
+	// the wall clock stepped forward, clockd is not stopped
+	clock.wall += time.Hour
+	clock.mono += 10 * time.Millisecond
+	_, err = c.GetUnixTime()
+	assert.NotErrorIs(t, err, ErrStopped)
+
+	// the wall clock stepped backward, the frozen Count is still detected
+	clock.wall -= 2 * time.Hour
+	clock.mono += 390 * time.Millisecond
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrStopped)
+	var se *StoppedError
+	require.ErrorAs(t, err, &se)
+	assert.Equal(t, 400*time.Millisecond, se.Frozen)
+}
+
+func TestStalenessAge(t *testing.T) {
+	d := newTestClockd(t)
+	info := lockedInfo(7, 1000)
+	d.publish(info)
+	clock := &steppedClock{
+		wall: time.Duration(info.Sec)*time.Second + time.Duration(info.NSec),
+		mono: time.Hour,
+	}
+	c := d.newClient(WithClock(clock), WithStaleThreshold(time.Second))
+	assert.Equal(t, time.Second, c.StaleThreshold())
+	_, err := c.StalenessAge()
+	assert.ErrorIs(t, err, ErrNotReady)
+
+	_, err = c.GetUnixTime()
+	require.NoError(t, err)
+	age, err := c.StalenessAge()
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), age)
+
+	clock.wall += time.Hour
+	clock.mono += 300 * time.Millisecond
+	_, err = c.GetUnixTime()
+	require.NoError(t, err)
+	age, err = c.StalenessAge()
+	require.NoError(t, err)
+	assert.Equal(t, 300*time.Millisecond, age)
+
+	// the age is reset once the Count changes
+	info.Count++
+	d.publish(info)
+	_, err = c.GetUnixTime()
+	require.NoError(t, err)
+	clock.mono += 100 * time.Millisecond
+	age, err = c.StalenessAge()
+	require.NoError(t, err)
+	assert.Equal(t, 100*time.Millisecond, age)
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	d := newTestClockd(t)
+	_, err := NewClient(d.lockPath, d.shmKey,
+		WithReconnectBackoff(time.Second, time.Millisecond))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+
+	tests := []struct {
+		opts     []Option
+		attempts int
+	}{
+		{[]Option{WithReconnectBackoff(time.Hour, time.Hour)}, 1},
+		{[]Option{WithReconnectBackoff(0, 0)}, 10},
+	}
+	for _, tt := range tests {
+		c := d.newClient(tt.opts...)
+		// makes every reset fail
+		c.lockPath = "invalid/lock/path"
+		c.resetRequired = true
+		var first error
+		for i := 0; i < 10; i++ {
+			_, err := c.GetUnixTime()
+			require.Error(t, err)
+			if first == nil {
+				first = err
+			}
+			assert.Equal(t, first, err)
+		}
+		assert.Equal(t, tt.attempts, c.reconnect.attempts)
+	}
+}
+
+func TestReconnectBackoffKeepsAttachment(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient(WithReconnectBackoff(time.Hour, time.Hour))
+	_, err := c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrUninitializedSegment)
+	assert.Equal(t, 0, c.reconnect.attempts)
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrUninitializedSegment)
+	assert.Equal(t, 1, c.reconnect.attempts)
+
+	// throttled, the existing attachment is used
+	d.publish(lockedInfo(1, 1000))
+	_, err = c.GetUnixTime()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, c.reconnect.attempts)
+}
+
+func TestOnUpdate(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	var updates []UnixTime
+	c.OnUpdate(func(ut UnixTime) {
+		updates = append(updates, ut)
+	})
+	d.publish(lockedInfo(1, 1000))
+
+	ut, err := c.GetUnixTime()
+	require.NoError(t, err)
+	require.Len(t, updates, 1)
+	assert.Equal(t, ut, updates[0])
+	// repeated polls of the same record
+	for i := 0; i < 3; i++ {
+		_, err = c.GetUnixTime()
+		require.NoError(t, err)
+	}
+	assert.Len(t, updates, 1)
+
+	d.publish(lockedInfo(2, 1000))
+	ut, err = c.GetUnixTime()
+	require.NoError(t, err)
+	require.Len(t, updates, 2)
+	assert.Equal(t, ut, updates[1])
+
+	d.publish(ClientInfo{})
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrNotReady)
+	assert.Len(t, updates, 2)
+
+	c.OnUpdate(nil)
+	d.publish(lockedInfo(3, 1000))
+	_, err = c.GetUnixTime()
+	require.NoError(t, err)
+	assert.Len(t, updates, 2)
+}
+
+func TestBufferSize(t *testing.T) {
+	d := newTestClockdWithSize(t, 128)
+	c := d.newClient(WithBufferSize(64))
+	// the larger segment created by clockd is copied as a whole
+	assert.Len(t, c.buf, 128)
+	d.publish(lockedInfo(1, 1000))
+	_, err := c.GetUnixTime()
+	require.NoError(t, err)
+
+	// a datalen not advertised by the record version is rejected
+	d.write(func(data []byte) {
+		binary.BigEndian.PutUint16(data, 64)
+	})
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrCorruptData)
+
+	// clockd created a smaller region than requested
+	_, err = NewClient(d.lockPath, d.shmKey, WithBufferSize(256))
+	assert.Error(t, err)
+	_, err = NewClient(d.lockPath, d.shmKey, WithBufferSize(minBufferSize-1))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+	c, err = NewClient(d.lockPath, d.shmKey, WithBufferSize(minBufferSize))
+	require.NoError(t, err)
+	assert.Len(t, c.buf, 128)
+	assert.NoError(t, c.Close())
+}
+
+func TestStrictAttach(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient(WithStrictAttach())
+	d.publish(lockedInfo(1, 1000))
+	_, err := c.GetUnixTime()
+	require.NoError(t, err)
+
+	_, err = NewClient(d.lockPath, d.shmKey,
+		WithStrictAttach(), WithBufferSize(2*ClientInfoSharedMemoryBufferSize))
+	assert.ErrorIs(t, err, ErrSegmentSizeMismatch)
+
+	// a wrong key is reported rather than creating an empty segment
+	key := d.shmKey ^ 0x00800000
+	_, err = NewClient(d.lockPath, key, WithStrictAttach())
+	assert.ErrorIs(t, err, ErrNoSegment)
+	assert.NotErrorIs(t, err, ErrNotReady)
+	_, err = attachSegment(key, ClientInfoSharedMemoryBufferSize, false)
+	assert.ErrorIs(t, err, ErrNoSegment)
+}
+
+func TestReadOnly(t *testing.T) {
+	d := newTestClockd(t)
+	d.publish(lockedInfo(1, 1000))
+	c := d.newClient(WithReadOnly(), WithStaleThreshold(time.Hour))
+	_, err := c.GetUnixTime()
+	require.NoError(t, err)
+	_, err = c.GetUnixTime()
+	require.NoError(t, err)
+	c = d.newClient(WithReadOnly(), WithSeqlock(), WithStaleThreshold(time.Hour))
+	d.publishSeqlock(lockedInfo(2, 1000))
+	_, err = c.GetUnixTime()
+	require.NoError(t, err)
+
+	_, err = NewClient(d.lockPath, d.shmKey^0x00800000, WithReadOnly())
+	assert.ErrorIs(t, err, ErrNoSegment)
+}
+
+func TestGetUnixTimeOrFallback(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	// clockd never published anything
+	ut, fellBack, err := c.GetUnixTimeOrFallback()
+	require.NoError(t, err)
+	assert.True(t, fellBack)
+	assert.False(t, ut.IsEmpty())
+	assert.Equal(t, uint64(time.Minute), ut.Dispersion)
+
+	info := lockedInfo(1, 1000)
+	d.publish(info)
+	ut, fellBack, err = c.GetUnixTimeOrFallback()
+	require.NoError(t, err)
+	assert.False(t, fellBack)
+	assert.Less(t, ut.Dispersion, uint64(time.Second))
+
+	c = d.newClient(WithFallbackDispersion(time.Hour))
+	info.Locked = false
+	d.publish(info)
+	ut, fellBack, err = c.GetUnixTimeOrFallback()
+	require.NoError(t, err)
+	assert.True(t, fellBack)
+	assert.Equal(t, uint64(time.Hour), ut.Dispersion)
+
+	// errors other than ErrNotReady and ErrStopped are not masked
+	d.write(func(data []byte) {
+		binary.BigEndian.PutUint16(data, 0xFFFF)
+	})
+	_, fellBack, err = c.GetUnixTimeOrFallback()
+	assert.ErrorIs(t, err, ErrCorruptData)
+	assert.False(t, fellBack)
+
+	_, err = NewClient(d.lockPath, d.shmKey, WithFallbackDispersion(-1))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestValidate(t *testing.T) {
+	d := newTestClockd(t)
+	assert.ErrorIs(t, Validate(d.lockPath, d.shmKey), ErrNeverReady)
+	info := lockedInfo(1, 1000)
+	info.Locked = false
+	d.publish(info)
+	assert.ErrorIs(t, Validate(d.lockPath, d.shmKey), ErrNotLocked)
+	d.publish(lockedInfo(2, 1000))
+	assert.NoError(t, Validate(d.lockPath, d.shmKey))
+	assert.ErrorIs(t, Validate(d.lockPath, d.shmKey,
+		WithBufferSize(2*ClientInfoSharedMemoryBufferSize)),
+		ErrSegmentSizeMismatch)
+
+	// missing kernel objects are reported without being created
+	lockPath := d.lockPath + ".missing"
+	assert.ErrorIs(t, Validate(lockPath, d.shmKey), ErrNoSemaphore)
+	_, err := OpenSemaphore(lockPath)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	key := d.shmKey ^ 0x00800000
+	assert.ErrorIs(t, Validate(d.lockPath, key), ErrNoSegment)
+	_, err = attachSegment(key, ClientInfoSharedMemoryBufferSize, true)
+	assert.ErrorIs(t, err, ErrNoSegment)
+}
+
+func TestGetUnixTimeDetailed(t *testing.T) {
+	d := newTestClockd(t)
+	info := lockedInfo(1, 1000)
+	d.publish(info)
+	c := d.newClient(WithClock(ClockFunc(func() (uint64, uint32) {
+		return info.Sec + 1, info.NSec
+	})))
+	r, err := c.GetUnixTimeDetailed()
+	require.NoError(t, err)
+	assert.Equal(t, UnixTime{Sec: info.Sec + 1, NSec: info.NSec}, r.Sample)
+	assert.Equal(t, UnixTime{Sec: info.Sec, NSec: info.NSec, Dispersion: 1000},
+		r.Reference)
+	assert.Equal(t, r.Sample.Sec, r.Time.Sec)
+	assert.Equal(t, r.Sample.NSec, r.Time.NSec)
+	assert.Equal(t, dispersionOf(t, info, info.Sec+1, info.NSec),
+		r.Time.Dispersion)
+
+	info.Count++
+	info.Locked = false
+	d.publish(info)
+	r, err = c.GetUnixTimeDetailed()
+	assert.ErrorIs(t, err, ErrNotLocked)
+	assert.True(t, r.Time.IsEmpty())
+	assert.Equal(t, info.Sec, r.Reference.Sec)
+	assert.Equal(t, info.Sec+1, r.Sample.Sec)
+}
+
+func TestBoundsAt(t *testing.T) {
+	now := UnixTime{Sec: 1000, NSec: 500, Dispersion: 1000}
+	model := LinearDriftModel(MaxClockDrift)
+	for _, delta := range []time.Duration{0, time.Second, -time.Second, time.Hour} {
+		abs := delta
+		if abs < 0 {
+			abs = -abs
+		}
+		lower, upper := boundsAt(now, now.ToTime().Add(delta), model)
+		width := time.Duration(now.Dispersion + GetClockUncertainty(int64(abs)))
+		assert.Equal(t, now.ToTime().Add(delta-width), lower, delta)
+		assert.Equal(t, now.ToTime().Add(delta+width), upper, delta)
+	}
+	// the uncertainty grows with the distance in both directions
+	l1, u1 := boundsAt(now, now.ToTime().Add(time.Second), model)
+	l2, u2 := boundsAt(now, now.ToTime().Add(-time.Second), model)
+	assert.Equal(t, u1.Sub(l1), u2.Sub(l2))
+	lower, upper := boundsAt(now, time.Unix(0, math.MinInt64), model)
+	assert.Equal(t, time.Unix(0, 0), lower)
+	assert.False(t, upper.Before(lower))
+
+	d := newTestClockd(t)
+	c := d.newClient()
+	_, _, err := c.BoundsAt(time.Now())
+	assert.ErrorIs(t, err, ErrNotReady)
+	d.publish(lockedInfo(1, 1000))
+	target := time.Now().Add(time.Second)
+	lower, upper, err = c.BoundsAt(target)
+	require.NoError(t, err)
+	assert.True(t, lower.Before(target))
+	assert.True(t, upper.After(target))
+	assert.GreaterOrEqual(t, upper.Sub(lower),
+		2*time.Duration(GetClockUncertainty(int64(time.Second))))
+}
+
+func TestDebugInfo(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	_, err := c.DebugInfo()
+	assert.ErrorIs(t, err, ErrNotReady)
+
+	info := lockedInfo(1, 1000)
+	d.publish(info)
+	_, err = c.GetUnixTime()
+	require.NoError(t, err)
+	v, err := c.DebugInfo()
+	require.NoError(t, err)
+	assert.Equal(t, info, v)
+
+	// rejected records are still exposed
+	info.Locked = false
+	info.Count = 2
+	d.publish(info)
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrNotLocked)
+	v, err = c.DebugInfo()
+	require.NoError(t, err)
+	assert.Equal(t, info, v)
+}
+
+func TestReattach(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	d.publish(lockedInfo(1, 1000))
+	assert.True(t, c.Attached())
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, c.Reattach())
+		assert.True(t, c.Attached())
+		assert.False(t, c.resetRequired)
+	}
+	_, err := c.GetUnixTime()
+	assert.NoError(t, err)
+
+	require.NoError(t, c.Close())
+	assert.False(t, c.Attached())
+	require.NoError(t, c.Reattach())
+	assert.True(t, c.Attached())
+	_, err = c.GetUnixTime()
+	assert.NoError(t, err)
+}
+
+func TestDoubleClose(t *testing.T) {
+	d := newTestClockd(t)
+	d.publish(lockedInfo(1, 1000))
+	c := d.newClient(WithCleanupOnClose())
+	require.NoError(t, c.Close())
+	// the semaphore recreated by clockd is not unlinked by a second Close
+	m, err := NewSemaphore(d.lockPath, 0600, 1)
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, m.Close())
+	}()
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, c.Close())
+	}
+	sem, err := OpenSemaphore(d.lockPath)
+	require.NoError(t, err)
+	assert.NoError(t, sem.Close())
+}
+
+func TestReadAfterClose(t *testing.T) {
+	d := newTestClockd(t)
+	d.publish(lockedInfo(1, 1000))
+	c := d.newClient()
+	require.NoError(t, c.Close())
+	for i := 0; i < 2; i++ {
+		_, err := c.GetUnixTime()
+		assert.ErrorIs(t, err, ErrClosed)
+	}
+	assert.False(t, c.resetRequired)
+	// reset after close
+	require.NoError(t, c.Reattach())
+	_, err := c.GetUnixTime()
+	assert.NoError(t, err)
+	require.NoError(t, c.Close())
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestResetReportsDetachError(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, nil))
+	d := newTestClockd(t)
+	d.publish(lockedInfo(1, 1000))
+	c := d.newClient(WithLogger(logger))
+	// the old segment can't be detached again
+	require.NoError(t, c.seg.detach())
+	require.NoError(t, c.Reattach())
+	records := decodeLogs(t, buf)
+	require.Len(t, records, 1)
+	assert.Equal(t, "failed to detach from clockd", records[0]["msg"])
+	assert.Contains(t, records[0]["error"], "failed to detach old segment")
+	_, err := c.GetUnixTime()
+	assert.NoError(t, err)
+
+	// failures to attach the new segment are told apart
+	_, err = NewClient(d.lockPath, d.shmKey,
+		WithStrictAttach(), WithBufferSize(2*ClientInfoSharedMemoryBufferSize))
+	assert.ErrorIs(t, err, ErrSegmentSizeMismatch)
+	assert.Contains(t, err.Error(), "failed to attach")
+	_, err = NewClient(d.lockPath+".missing", d.shmKey, WithStrictAttach())
+	assert.ErrorIs(t, err, ErrNoSemaphore)
+	assert.Contains(t, err.Error(), "failed to open semaphore")
+}
+
+func TestHealthy(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient(WithDegradedThreshold(time.Hour))
+	assert.Equal(t, StateUnknown, c.State())
+	ok, err := c.Healthy()
+	assert.False(t, ok)
+	assert.ErrorIs(t, err, ErrUninitializedSegment)
+	assert.Equal(t, StateNotReady, c.State())
+
+	info := lockedInfo(1, 1000)
+	info.Locked = false
+	d.publish(info)
+	ok, err = c.Healthy()
+	assert.False(t, ok)
+	assert.ErrorIs(t, err, ErrNotLocked)
+
+	d.publish(lockedInfo(2, 1000))
+	ok, err = c.Healthy()
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, StateReady, c.State())
+
+	info = lockedInfo(3, 1000)
+	info.Sec -= 7200
+	d.publish(info)
+	ok, err = c.Healthy()
+	assert.True(t, ok)
+	assert.ErrorIs(t, err, ErrDegraded)
+	assert.Equal(t, StateDegraded, c.State())
+}
+
+type testMetricsObserver struct {
+	NopMetricsObserver
+	reads  []error
+	resets int
+	last   uint64
+}
+
+func (o *testMetricsObserver) ObserveRead(latency time.Duration,
+	dispersion uint64, err error) {
+	o.reads = append(o.reads, err)
+	o.last = dispersion
+}
+
+func (o *testMetricsObserver) ObserveReset(err error) {
+	o.resets++
+}
+
+func TestMetricsObserver(t *testing.T) {
+	d := newTestClockd(t)
+	o := &testMetricsObserver{}
+	c := d.newClient(WithMetricsObserver(o), WithReconnectBackoff(0, 0))
+	_, err := c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrNotReady)
+	d.publish(lockedInfo(1, 1000))
+	ut, err := c.GetUnixTime()
+	require.NoError(t, err)
+
+	require.Len(t, o.reads, 2)
+	assert.ErrorIs(t, o.reads[0], ErrNotReady)
+	assert.NoError(t, o.reads[1])
+	assert.Equal(t, ut.Dispersion, o.last)
+	assert.Equal(t, 1, o.resets)
+
+	assert.NotPanics(t, func() {
+		c := d.newClient(WithMetricsObserver(nil))
+		_, _ = c.GetUnixTime()
+	})
+}
+
+func TestGetUnixTimes(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	_, err := c.GetUnixTimes(3)
+	assert.ErrorIs(t, err, ErrNotReady)
+
+	info := lockedInfo(1, 1000)
+	d.publish(info)
+	uts, err := c.GetUnixTimes(0)
+	assert.NoError(t, err)
+	assert.Empty(t, uts)
+
+	uts, err = c.GetUnixTimes(16)
+	require.NoError(t, err)
+	require.Len(t, uts, 16)
+	for i, ut := range uts {
+		assert.Equal(t, dispersionOf(t, info, ut.Sec, ut.NSec),
+			ut.Dispersion)
+		if i > 0 {
+			assert.GreaterOrEqual(t, ut.Sub(uts[i-1]), int64(0))
+		}
+	}
+}
+
+func TestGetUnixTimesStaleness(t *testing.T) {
+	d := newTestClockd(t)
+	info := lockedInfo(1, 1000)
+	d.publish(info)
+	var now uint64
+	ref, _ := (&UnixTime{Sec: info.Sec, NSec: info.NSec}).Bounds()
+	c := d.newClient(WithClock(ClockFunc(func() (uint64, uint32) {
+		// each sample is 100ms after the previous one
+		now += uint64(100 * time.Millisecond)
+		v := ref + now
+		return v / 1e9, uint32(v % 1e9)
+	})))
+	_, err := c.GetUnixTime()
+	require.NoError(t, err)
+	_, err = c.GetUnixTimes(2)
+	require.NoError(t, err)
+	_, err = c.GetUnixTimes(4)
+	assert.ErrorIs(t, err, ErrStopped)
+}
+
+func TestSeqlock(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient(WithSeqlock())
+	d.publishSeqlock(lockedInfo(1, 1000))
+	// a crashed clockd holding the lock doesn't block seqlock readers
+	require.NoError(t, d.mutex.Wait())
+	defer func() {
+		assert.NoError(t, d.mutex.Post())
+	}()
+	_, err := c.GetUnixTime()
+	require.NoError(t, err)
+
+	// a crashed clockd in the middle of an update
+	seq := loadSeq(d.data, Encoder)
+	storeSeq(d.data, seq+1, Encoder)
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrBusy)
+	storeSeq(d.data, seq+2, Encoder)
+	_, err = c.GetUnixTime()
+	assert.NoError(t, err)
+}
+
+func TestSeqlockNeverObservesTornRecords(t *testing.T) {
+	d := newTestClockd(t)
+	stopper := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := uint64(1); ; i++ {
+			select {
+			case <-stopper:
+				return
+			default:
+			}
+			// all fields are derived from i so torn records are detectable
+			info := lockedInfo(uint16(i), i)
+			info.NSec = uint32(i % 1e9)
+			d.publishSeqlock(info)
+		}
+	}()
+	for loadSeq(d.data, Encoder) < 2 {
+		runtime.Gosched()
+	}
+
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			c, err := NewClient(d.lockPath, d.shmKey, WithSeqlock())
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer func() {
+				assert.NoError(t, c.Close())
+			}()
+			for i := 0; i < 2000; i++ {
+				info := ClientInfo{}
+				_, err := c.read(context.Background(), &info, nil)
+				if errors.Is(err, ErrBusy) {
+					continue
+				}
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Zero(t, info.Seq%2)
+				assert.Equal(t, uint16(info.Dispersion), info.Count)
+				assert.Equal(t, uint32(info.Dispersion%1e9), info.NSec)
+			}
+		}()
+	}
+	readers.Wait()
+	close(stopper)
+	wg.Wait()
+}
+
+func TestGetDataLenBeyondBuffer(t *testing.T) {
+	buf := make([]byte, legacyClientInfoSize+2+1)
+	binary.BigEndian.PutUint16(buf, uint16(clientInfoSize))
+	_, err := getDataLen(buf, 0, false, testLayout)
+	assert.Equal(t, ErrCorruptData, err)
+	binary.BigEndian.PutUint16(buf, uint16(legacyClientInfoSize))
+	datalen, err := getDataLen(buf, 0, false, testLegacyLayout)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(legacyClientInfoSize), datalen)
+}
+
+func FuzzGetRecord(f *testing.F) {
+	valid := make([]byte, ClientInfoSharedMemoryBufferSize)
+	binary.BigEndian.PutUint16(valid, uint16(clientInfoSize))
+	info := lockedInfo(1, 1000)
+	_, err := info.Marshal(valid[2:])
+	require.NoError(f, err)
+	f.Add(valid)
+	f.Add([]byte{})
+	f.Add([]byte{0xFF, 0xFF})
+	f.Add([]byte{0, byte(legacyClientInfoSize), 1, 1})
+
+	f.Fuzz(func(t *testing.T, region []byte) {
+		buf := make([]byte, ClientInfoSharedMemoryBufferSize)
+		copy(buf, region)
+		record, err := getRecord(buf, 0, false, testLegacyLayout)
+		if err != nil {
+			if !errors.Is(err, ErrNotReady) &&
+				!errors.Is(err, ErrVersionMismatch) {
+				assert.ErrorIs(t, err, ErrCorruptData)
+			}
+			return
+		}
+		assert.LessOrEqual(t, len(record), len(buf)-2)
+		var result ClientInfo
+		if len(record) == legacyClientInfoSize {
+			assert.NoError(t, UnmarshalLegacyClientInfo(record, &result))
+			return
+		}
+		err = UnmarshalClientInfo(record, &result)
+		if err != nil {
+			assert.Equal(t, ErrVersionMismatch, err)
+		}
+	})
+}
+
+func TestClientInfoInvalidLength(t *testing.T) {
+	c := lockedInfo(1, 1000)
+	_, err := c.Marshal(make([]byte, clientInfoSize-1))
+	assert.ErrorIs(t, err, ErrInvalidLength)
+	assert.ErrorIs(t, err, ErrCorruptData)
+
+	for _, n := range []int{0, legacyClientInfoSize - 1, clientInfoSize + 1} {
+		assert.ErrorIs(t, UnmarshalClientInfo(make([]byte, n), &c),
+			ErrInvalidLength)
+		assert.ErrorIs(t, UnmarshalLegacyClientInfo(make([]byte, n), &c),
+			ErrInvalidLength)
+		assert.Panics(t, func() {
+			MustUnmarshalClientInfo(make([]byte, n), &c)
+		})
+	}
+	data, err := c.Marshal(make([]byte, clientInfoSize))
+	require.NoError(t, err)
+	assert.NotPanics(t, func() {
+		MustUnmarshalClientInfo(data, &c)
+	})
+}
+
+func FuzzUnmarshalClientInfo(f *testing.F) {
+	info := lockedInfo(1, 1000)
+	data, err := info.Marshal(make([]byte, clientInfoSize))
+	require.NoError(f, err)
+	f.Add(data)
+	f.Add(data[:legacyClientInfoSize])
+	f.Add([]byte{})
+	f.Add([]byte{1, 1, 0xFF})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var result ClientInfo
+		if err := UnmarshalClientInfo(data, &result); err != nil {
+			if !errors.Is(err, ErrVersionMismatch) {
+				assert.ErrorIs(t, err, ErrCorruptData)
+			}
+			return
+		}
+		// unmarshaled records round trip
+		out, err := result.Marshal(make([]byte, clientInfoSize))
+		assert.NoError(t, err)
+		var v ClientInfo
+		assert.NoError(t, UnmarshalClientInfo(out, &v))
+		assert.Equal(t, result, v)
+	})
+}
+
+func TestByteOrder(t *testing.T) {
+	_, err := NewClientWithOptions(WithByteOrder(nil))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+
+	orders := []binary.ByteOrder{
+		binary.BigEndian, binary.LittleEndian, binary.NativeEndian,
+	}
+	for _, order := range orders {
+		d := newTestClockd(t)
+		info := lockedInfo(1, 1000)
+		d.write(func(region []byte) {
+			order.PutUint16(region, uint16(clientInfoSize))
+			_, err := info.MarshalByteOrder(region[2:], order)
+			assert.NoError(t, err)
+		})
+		for _, expected := range orders {
+			c := d.newClient(WithByteOrder(expected))
+			result := ClientInfo{}
+			_, err := c.GetUnixTimeInto(&result)
+			if isLittleEndian(order) == isLittleEndian(expected) {
+				require.NoError(t, err)
+				assert.Equal(t, info, result)
+			} else {
+				assert.ErrorIs(t, err, ErrVersionMismatch)
+			}
+		}
+	}
+}
+
+func TestSeqlockByteOrder(t *testing.T) {
+	d := newTestClockd(t)
+	order := binary.LittleEndian
+	info := lockedInfo(1, 1000)
+	info.Seq = 2
+	storeSeq(d.data, 1, order)
+	order.PutUint16(d.data, uint16(clientInfoSize))
+	_, err := info.MarshalByteOrder(d.data[2:], order)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), loadSeq(d.data, order))
+
+	c := d.newClient(WithSeqlock(), WithByteOrder(order))
+	require.NoError(t, d.mutex.Wait())
+	defer func() {
+		assert.NoError(t, d.mutex.Post())
+	}()
+	_, err = c.GetUnixTime()
+	assert.NoError(t, err)
+}
+
+func TestLastReadLatency(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	assert.Equal(t, time.Duration(0), c.LastReadLatency())
+	d.publish(lockedInfo(1, 1000))
+	_, err := c.GetUnixTime()
+	require.NoError(t, err)
+	assert.Greater(t, c.LastReadLatency(), time.Duration(0))
+
+	c.afterCopy = func() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, err = c.GetUnixTime()
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, c.LastReadLatency(), 10*time.Millisecond)
+}
+
+func TestLatencyInDispersion(t *testing.T) {
+	d := newTestClockd(t)
+	info := lockedInfo(1, 1000)
+	d.publish(info)
+	clock := WithClock(ClockFunc(func() (uint64, uint32) {
+		return info.Sec, info.NSec
+	}))
+	plain := d.newClient(clock)
+	ut, err := plain.GetUnixTime()
+	require.NoError(t, err)
+
+	c := d.newClient(clock, WithLatencyInDispersion())
+	c.afterCopy = func() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	v, err := c.GetUnixTime()
+	require.NoError(t, err)
+	assert.LessOrEqual(t, v.Dispersion, ut.Dispersion+uint64(c.LastReadLatency()))
+	assert.GreaterOrEqual(t, v.Dispersion-ut.Dispersion,
+		uint64(10*time.Millisecond))
+	// the recorded readings match the returned one
+	assert.Equal(t, []UnixTime{v}, c.Recent())
+	c.lock()
+	assert.Equal(t, v, c.latest)
+	c.unlock()
+}
+
+// The benchmarks below run against the shared memory region owned by
+// testClockd, no clockd is required. The same record is read repeatedly, the
+// stale threshold is thus relaxed.
+
+func BenchmarkGetUnixTime(b *testing.B) {
+	d := newTestClockd(b)
+	d.publish(lockedInfo(1, 1000))
+	c := d.newClient(WithStaleThreshold(time.Hour))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.GetUnixTime(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkWaitUntilPast reports how long wait oversleeps past the point the
+// current time is definitely after the target.
+func benchmarkWaitUntilPast(b *testing.B,
+	wait func(c *Client, ut UnixTime) error) {
+	d := newTestClockd(b)
+	d.publish(lockedInfo(1, 1000))
+	c := d.newClient(WithStaleThreshold(time.Hour))
+
+	var oversleep time.Duration
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ut, err := c.GetUnixTime()
+		if err != nil {
+			b.Fatal(err)
+		}
+		ut = ut.Add(100 * time.Microsecond)
+		if err := wait(c, ut); err != nil {
+			b.Fatal(err)
+		}
+		now, err := c.GetUnixTime()
+		if err != nil {
+			b.Fatal(err)
+		}
+		nl, _ := now.Bounds()
+		_, upper := ut.Bounds()
+		oversleep += time.Duration(nl - upper)
+	}
+	b.ReportMetric(float64(oversleep)/float64(b.N), "oversleep-ns/op")
+}
+
+func BenchmarkWaitUntilPast(b *testing.B) {
+	benchmarkWaitUntilPast(b, func(c *Client, ut UnixTime) error {
+		return c.WaitUntilPast(context.Background(), ut)
+	})
+}
+
+func BenchmarkWaitUntilPastPolling(b *testing.B) {
+	benchmarkWaitUntilPast(b, func(c *Client, ut UnixTime) error {
+		for {
+			ok, err := c.After(ut)
+			if err != nil || ok {
+				return err
+			}
+			time.Sleep(10 * time.Microsecond)
+		}
+	})
+}
+
+func BenchmarkGetUnixTimeSeqlock(b *testing.B) {
+	d := newTestClockd(b)
+	d.publishSeqlock(lockedInfo(1, 1000))
+	c := d.newClient(WithStaleThreshold(time.Hour), WithSeqlock())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.GetUnixTime(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkClientInfoMarshal(b *testing.B) {
+	info := lockedInfo(1, 1000)
+	buf := make([]byte, clientInfoSize)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := info.Marshal(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkClientInfoUnmarshal(b *testing.B) {
+	info := lockedInfo(1, 1000)
+	data, err := info.Marshal(make([]byte, clientInfoSize))
+	require.NoError(b, err)
+	var v ClientInfo
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := UnmarshalClientInfo(data, &v); err != nil {
+			b.Fatal(err)
+		}
+	}
 }
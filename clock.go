@@ -0,0 +1,65 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"time"
+)
+
+// Clock is the local clock sampled by the Client when reading clockd's record.
+// The dispersion of returned UnixTime values grows with the time elapsed on
+// the Clock since clockd's reference was taken.
+type Clock interface {
+	// Now returns the current Unix time in seconds and nanoseconds.
+	Now() (sec uint64, nsec uint32)
+}
+
+// ClockFunc is an adapter to allow the use of ordinary functions as Clock.
+type ClockFunc func() (uint64, uint32)
+
+// Now implements the Clock interface.
+func (f ClockFunc) Now() (uint64, uint32) {
+	return f()
+}
+
+// MonotonicClock is a Clock that also provides monotonic readings. The Client
+// measures how long clockd's Count stays unchanged on the monotonic readings
+// when available, so its staleness detection is not fooled by steps of the
+// Clock, e.g. the sys clock being stepped by NTP or an operator.
+type MonotonicClock interface {
+	Clock
+	// Monotonic returns a reading of a monotonic clock, only the difference
+	// between two readings is meaningful.
+	Monotonic() time.Duration
+}
+
+// monotonicBase is the reference of the monotonic readings of SystemClock.
+var monotonicBase = time.Now()
+
+// SystemClock is the Clock backed by the sys clock, i.e. time.Now(). It is
+// the default Clock of the Client.
+type SystemClock struct{}
+
+var _ MonotonicClock = SystemClock{}
+
+// Now implements the Clock interface.
+func (SystemClock) Now() (uint64, uint32) {
+	return getSysClockTime()
+}
+
+// Monotonic implements the MonotonicClock interface.
+func (SystemClock) Monotonic() time.Duration {
+	return time.Since(monotonicBase)
+}
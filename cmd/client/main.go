@@ -15,41 +15,145 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"os"
 	"time"
 
 	"github.com/lni/thymef"
 )
 
+const usage = `usage: client [flags] [now]
+
+By default, the current bounded time is printed once, the exit status is
+non-zero when it is not available, e.g. clockd is not ready. With -watch, the
+bounded time is read every 100ms and the elapsed hours, the dispersion, its
+clockd and local components in nanoseconds and the read latency in
+microseconds are printed for visualization.
+
+flags:
+`
+
+// nowJSON is the JSON output of the one-shot mode.
+type nowJSON struct {
+	Time         string `json:"time"`
+	Lower        string `json:"lower"`
+	Upper        string `json:"upper"`
+	DispersionNS uint64 `json:"dispersion_ns"`
+}
+
 // this is a toy test client, provided as an example
-// it also print out various latencies and dispersions for visualization
+// it can print the bounded time once for scripting and health checks, or
+// print out various latencies and dispersions for visualization
 func main() {
-	client, err := thymef.NewClient(thymef.DefaultLockPath, thymef.DefaultShmKey)
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	lockPath := fs.String("lock", thymef.DefaultLockPath,
+		"name of clockd's semaphore")
+	shmKey := fs.Int("shmkey", thymef.DefaultShmKey,
+		"key of clockd's shared memory segment")
+	legacy := fs.Bool("legacy", false,
+		"accept records published by clockd in the legacy layout")
+	asJSON := fs.Bool("json", false, "print the bounded time as JSON")
+	watch := fs.Bool("watch", false, "keep printing readings for visualization")
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() > 1 || (fs.NArg() == 1 && fs.Arg(0) != "now") {
+		fs.Usage()
+		return 2
+	}
+
+	var opts []thymef.Option
+	if !*watch {
+		// a one-shot read never creates clockd's semaphore or segment
+		opts = append(opts, thymef.WithStrictAttach())
+	}
+	if *legacy {
+		opts = append(opts, thymef.WithLegacyLayout())
+	}
+	client, err := thymef.NewClient(*lockPath, *shmKey, opts...)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(stderr, "failed to create client: %v\n", err)
+		return 1
 	}
 	defer func() {
 		_ = client.Close()
 	}()
+	if *watch {
+		visualize(client, stdout)
+		return 0
+	}
+
+	return now(client, *asJSON, stdout, stderr)
+}
+
+// now prints the current bounded time once.
+func now(client *thymef.Client, asJSON bool,
+	stdout io.Writer, stderr io.Writer) int {
+	// there is no previous read to detect a stopped clockd from
+	ut, err := client.GetUnixTimeNoStaleCheck()
+	if err != nil {
+		fmt.Fprintf(stderr, "bounded time not available: %v\n", err)
+		return 1
+	}
+	if !asJSON {
+		fmt.Fprintln(stdout, ut.String())
+		return 0
+	}
+	lower, upper := ut.BoundsTime()
+	data, err := json.Marshal(nowJSON{
+		Time:         ut.ToTime().UTC().Format(time.RFC3339Nano),
+		Lower:        lower.UTC().Format(time.RFC3339Nano),
+		Upper:        upper.UTC().Format(time.RFC3339Nano),
+		DispersionNS: ut.Dispersion,
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to marshal: %v\n", err)
+		return 1
+	}
+	fmt.Fprintln(stdout, string(data))
+
+	return 0
+}
 
+// visualize keeps reading the bounded time and printing the elapsed hours,
+// dispersions and read latency.
+func visualize(client *thymef.Client, stdout io.Writer) {
 	start := time.Now()
 	for {
 		time.Sleep(100 * time.Millisecond)
 		st := time.Now()
-		ut, err := client.GetUnixTime()
+		ut, b, err := client.GetUnixTimeBreakdown()
 		cost := time.Since(st)
 		tt := time.Since(start)
-		if err == thymef.ErrStopped {
-			fmt.Printf("thymed stopped\n")
+		if errors.Is(err, thymef.ErrStopped) {
+			fmt.Fprintf(stdout, "thymed stopped\n")
 			continue
 		}
-		if err == thymef.ErrNotReady {
-			fmt.Printf("thymed is not ready yet\n")
+		if errors.Is(err, thymef.ErrNotReady) {
+			fmt.Fprintf(stdout, "thymed is not ready yet\n")
 			continue
 		}
-		fmt.Printf("%g %d %d\n",
+		fmt.Fprintf(stdout, "%g %d %d %d %d\n",
 			float64(tt.Milliseconds())/3600000.0,
 			ut.Dispersion,
+			b.Clockd,
+			b.Local,
 			cost.Microseconds())
 	}
 }
@@ -0,0 +1,155 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gen2brain/shm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lni/thymef"
+)
+
+// testClockd owns the semaphore and the shared memory segment read by the
+// client, it publishes records the way clockd does.
+type testClockd struct {
+	lockPath string
+	shmKey   int
+	data     []byte
+}
+
+func newTestClockd(t *testing.T) *testClockd {
+	pid := os.Getpid()
+	d := &testClockd{
+		lockPath: fmt.Sprintf("thymef.client.test.%d.lock", pid),
+		shmKey:   0x7e000000 | (pid&0xffff)<<8,
+	}
+	m, err := thymef.NewSemaphore(d.lockPath, 0600, 1)
+	require.NoError(t, err)
+	id, err := shm.Get(d.shmKey,
+		thymef.ClientInfoSharedMemoryBufferSize, shm.IPC_CREAT|0600)
+	require.NoError(t, err)
+	d.data, err = shm.At(id, 0, 0)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, shm.Dt(d.data))
+		assert.NoError(t, shm.Rm(id))
+		assert.NoError(t, m.Unlink())
+		assert.NoError(t, m.Close())
+	})
+
+	return d
+}
+
+// publish publishes a valid and locked record using the current time as its
+// reference.
+func (d *testClockd) publish(t *testing.T) {
+	now := time.Now()
+	require.NoError(t, thymef.WriteRecord(d.data, thymef.ClientInfo{
+		Valid:      true,
+		Locked:     true,
+		Count:      1,
+		Dispersion: 1000,
+		Sec:        uint64(now.Unix()),
+		NSec:       uint32(now.Nanosecond()),
+	}))
+}
+
+// publishLegacy publishes a valid and locked record in the legacy layout.
+func (d *testClockd) publishLegacy() {
+	now := time.Now()
+	binary.BigEndian.PutUint16(d.data, 24)
+	record := d.data[2:]
+	record[0], record[1] = 1, 1
+	binary.BigEndian.PutUint16(record[2:], 1)
+	binary.BigEndian.PutUint64(record[4:], 1000)
+	binary.BigEndian.PutUint64(record[12:], uint64(now.Unix()))
+	binary.BigEndian.PutUint32(record[20:], uint32(now.Nanosecond()))
+}
+
+func TestRun(t *testing.T) {
+	d := newTestClockd(t)
+	target := func(args ...string) []string {
+		return append([]string{
+			"-lock", d.lockPath, "-shmkey", fmt.Sprint(d.shmKey)}, args...)
+	}
+	tests := []struct {
+		name      string
+		args      []string
+		legacy    bool
+		code      int
+		stdout    string
+		stderr    string
+		checkJSON bool
+	}{
+		{name: "help", args: []string{"-h"}, code: 0, stderr: "usage: client"},
+		{name: "unknown flag", args: []string{"-bogus"}, code: 2,
+			stderr: "flag provided but not defined"},
+		{name: "unknown command", args: target("later"), code: 2,
+			stderr: "usage: client"},
+		{name: "too many arguments", args: target("now", "now"), code: 2,
+			stderr: "usage: client"},
+		{name: "no segment",
+			args:   []string{"-lock", d.lockPath, "-shmkey", fmt.Sprint(d.shmKey + 1)},
+			code:   1,
+			stderr: "failed to create client"},
+		{name: "text", args: target(), code: 0, stdout: " ±"},
+		{name: "text now", args: target("now"), code: 0, stdout: " ±"},
+		{name: "json", args: target("-json"), code: 0, checkJSON: true},
+		{name: "legacy record", args: target(), legacy: true, code: 1,
+			stderr: "version mismatch"},
+		{name: "legacy", args: target("-legacy", "-json"), legacy: true,
+			code: 0, checkJSON: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.legacy {
+				d.publishLegacy()
+			} else {
+				d.publish(t)
+			}
+			var stdout, stderr bytes.Buffer
+			code := run(tt.args, &stdout, &stderr)
+			assert.Equal(t, tt.code, code, stderr.String())
+			assert.Contains(t, stdout.String(), tt.stdout)
+			assert.Contains(t, stderr.String(), tt.stderr)
+			if !tt.checkJSON {
+				return
+			}
+			var v nowJSON
+			require.NoError(t, json.Unmarshal(stdout.Bytes(), &v))
+			ts, err := time.Parse(time.RFC3339Nano, v.Time)
+			require.NoError(t, err)
+			lower, err := time.Parse(time.RFC3339Nano, v.Lower)
+			require.NoError(t, err)
+			upper, err := time.Parse(time.RFC3339Nano, v.Upper)
+			require.NoError(t, err)
+			assert.GreaterOrEqual(t, v.DispersionNS, uint64(1000))
+			assert.Equal(t, ts.Add(-time.Duration(v.DispersionNS)), lower)
+			assert.Equal(t, ts.Add(time.Duration(v.DispersionNS)), upper)
+			assert.WithinDuration(t, time.Now(), ts, time.Second)
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// DefaultConfigFile is the well known path of the file clockd publishes its
+// lock path and shm key to, see NewClientFromConfigFile.
+const DefaultConfigFile = "/run/clockd/client.json"
+
+// ErrInvalidConfigFile indicates that the file published by clockd doesn't
+// describe a valid lock path and shm key.
+var ErrInvalidConfigFile = errors.New("invalid clockd config file")
+
+// ConfigFile is the content of the file published by clockd at startup for
+// clients to discover its lock path and shm key. The file is a JSON object,
+// e.g.
+//
+//	{"lock_path": "clockd.client.lock", "shm_key": 55356}
+//
+// Both fields are optional, DefaultLockPath and DefaultShmKey are used for
+// absent fields. Unknown fields are rejected so typos don't silently fall back
+// to the defaults.
+type ConfigFile struct {
+	// LockPath is the name of clockd's semaphore, it can't contain any slash
+	// other than a leading one.
+	LockPath string `json:"lock_path,omitempty"`
+	// ShmKey is the key of clockd's shared memory segment, it must be a
+	// positive 32 bits integer.
+	ShmKey int `json:"shm_key,omitempty"`
+}
+
+// ReadConfigFile reads and validates the file published by clockd at path.
+// The returned ConfigFile has absent fields set to their defaults.
+func ReadConfigFile(path string) (ConfigFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ConfigFile{}, err
+	}
+	cf := ConfigFile{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cf); err != nil {
+		return ConfigFile{}, fmt.Errorf("%w: %s: %v",
+			ErrInvalidConfigFile, path, err)
+	}
+	if dec.More() {
+		return ConfigFile{}, fmt.Errorf("%w: %s: trailing data",
+			ErrInvalidConfigFile, path)
+	}
+	if cf.LockPath == "" {
+		cf.LockPath = DefaultLockPath
+	}
+	if cf.ShmKey == 0 {
+		cf.ShmKey = DefaultShmKey
+	}
+	if strings.Contains(strings.TrimPrefix(cf.LockPath, "/"), "/") {
+		return ConfigFile{}, fmt.Errorf("%w: %s: invalid lock path %q",
+			ErrInvalidConfigFile, path, cf.LockPath)
+	}
+	if cf.ShmKey < 0 || cf.ShmKey > math.MaxInt32 {
+		return ConfigFile{}, fmt.Errorf("%w: %s: invalid shm key %d",
+			ErrInvalidConfigFile, path, cf.ShmKey)
+	}
+
+	return cf, nil
+}
+
+// NewClientFromConfigFile creates a new Client instance for the clockd
+// instance that published its lock path and shm key to the file at path, see
+// ConfigFile for the schema. It decouples clients from the configuration of
+// clockd, a custom clockd deployment doesn't require clients to be rebuilt
+// with matching constants. opts are applied before the lock path and shm key
+// read from the file.
+func NewClientFromConfigFile(path string, opts ...Option) (*Client, error) {
+	cf, err := ReadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClient(cf.LockPath, cf.ShmKey, opts...)
+}
@@ -0,0 +1,80 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "client.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestReadConfigFile(t *testing.T) {
+	tests := []struct {
+		content string
+		want    ConfigFile
+		valid   bool
+	}{
+		{`{"lock_path": "custom.lock", "shm_key": 1234}`,
+			ConfigFile{LockPath: "custom.lock", ShmKey: 1234}, true},
+		{`{"lock_path": "/custom.lock"}`,
+			ConfigFile{LockPath: "/custom.lock", ShmKey: DefaultShmKey}, true},
+		{`{"shm_key": 1234}`,
+			ConfigFile{LockPath: DefaultLockPath, ShmKey: 1234}, true},
+		{`{}`, ConfigFile{LockPath: DefaultLockPath, ShmKey: DefaultShmKey}, true},
+		{`{"lock_path": "a/b"}`, ConfigFile{}, false},
+		{`{"shm_key": -1}`, ConfigFile{}, false},
+		{`{"shm_key": 4294967296}`, ConfigFile{}, false},
+		{`{"shm_key": "1234"}`, ConfigFile{}, false},
+		{`{"shmkey": 1234}`, ConfigFile{}, false},
+		{`{} {}`, ConfigFile{}, false},
+		{``, ConfigFile{}, false},
+	}
+	for idx, tt := range tests {
+		cf, err := ReadConfigFile(writeConfigFile(t, tt.content))
+		if tt.valid {
+			require.NoError(t, err, idx)
+			assert.Equal(t, tt.want, cf, idx)
+		} else {
+			assert.ErrorIs(t, err, ErrInvalidConfigFile, idx)
+		}
+	}
+
+	_, err := ReadConfigFile(filepath.Join(t.TempDir(), "missing.json"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestNewClientFromConfigFile(t *testing.T) {
+	d := newTestClockd(t)
+	d.publish(lockedInfo(1, 1000))
+	path := writeConfigFile(t, fmt.Sprintf(`{"lock_path": %q, "shm_key": %d}`,
+		d.lockPath, d.shmKey))
+	c, err := NewClientFromConfigFile(path, WithStrictAttach())
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, c.Close())
+	}()
+	_, err = c.GetUnixTime()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,63 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+// DriftModel models the uncertainty introduced by the local clock over time,
+// it is used by the Client to widen the dispersion published by clockd by the
+// uncertainty accumulated since clockd's reference was taken. Models can be
+// calibrated from the hardware, e.g. from the Allan deviation of the local
+// oscillator.
+type DriftModel interface {
+	// Uncertainty returns the max error in nanoseconds accumulated by the
+	// local clock over elapsedNs nanoseconds, elapsedNs is never negative.
+	// It must be monotonically non-decreasing in elapsedNs.
+	Uncertainty(elapsedNs int64) uint64
+}
+
+// LinearDriftModel is the DriftModel of a clock drifting at most the
+// specified number of ppb, it is the default DriftModel of the Client with
+// MaxClockDrift ppb, see also WithMaxClockDrift.
+type LinearDriftModel int64
+
+var _ DriftModel = LinearDriftModel(MaxClockDrift)
+
+// Uncertainty implements the DriftModel interface.
+func (m LinearDriftModel) Uncertainty(elapsedNs int64) uint64 {
+	return getClockUncertainty(elapsedNs, int64(m))
+}
+
+// AffineDriftModel is the DriftModel of a clock drifting at most PPB ppb with
+// a constant error of Base nanoseconds on top, e.g. to account for a fixed
+// measurement error of the local clock.
+type AffineDriftModel struct {
+	Base uint64
+	PPB  int64
+}
+
+var _ DriftModel = AffineDriftModel{}
+
+// Uncertainty implements the DriftModel interface.
+func (m AffineDriftModel) Uncertainty(elapsedNs int64) uint64 {
+	return m.Base + getClockUncertainty(elapsedNs, m.PPB)
+}
+
+// DriftModelFunc is an adapter to allow the use of ordinary functions as
+// DriftModel.
+type DriftModelFunc func(elapsedNs int64) uint64
+
+// Uncertainty implements the DriftModel interface.
+func (f DriftModelFunc) Uncertainty(elapsedNs int64) uint64 {
+	return f(elapsedNs)
+}
@@ -0,0 +1,55 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriftModels(t *testing.T) {
+	for _, ns := range []int64{0, 1, 1e6, 1e9, 3600e9} {
+		assert.Equal(t, GetClockUncertainty(ns),
+			LinearDriftModel(MaxClockDrift).Uncertainty(ns), ns)
+		assert.Equal(t, 100+getClockUncertainty(ns, 50000),
+			AffineDriftModel{Base: 100, PPB: 50000}.Uncertainty(ns), ns)
+	}
+	f := DriftModelFunc(func(ns int64) uint64 {
+		return uint64(ns) / 2
+	})
+	assert.Equal(t, uint64(500), f.Uncertainty(1000))
+}
+
+func TestWithDriftModel(t *testing.T) {
+	d := newTestClockd(t)
+	info := lockedInfo(1, 1000)
+	d.publish(info)
+	clock := WithClock(ClockFunc(func() (uint64, uint32) {
+		return info.Sec + 1, info.NSec
+	}))
+	model := AffineDriftModel{Base: 5000, PPB: 50000}
+	c := d.newClient(clock, WithDriftModel(model), WithMaxClockDrift(1))
+	ut, err := c.GetUnixTime()
+	require.NoError(t, err)
+	assert.Equal(t, info.Dispersion+model.Uncertainty(1e9), ut.Dispersion)
+
+	c = d.newClient(clock, WithMaxClockDrift(50000))
+	ut, err = c.GetUnixTime()
+	require.NoError(t, err)
+	assert.Equal(t, info.Dispersion+getClockUncertainty(1e9, 50000),
+		ut.Dispersion)
+}
@@ -0,0 +1,70 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+// defaultHistorySize is the default number of readings retained by the
+// Client, see WithHistorySize.
+const defaultHistorySize = 16
+
+// history is a fixed size ring buffer of readings, the oldest reading is
+// overwritten once it is full.
+type history struct {
+	buf  []UnixTime
+	next int
+	full bool
+}
+
+func newHistory(size int) history {
+	return history{buf: make([]UnixTime, size)}
+}
+
+// add appends ut to the history, it is a no-op for an empty history.
+func (h *history) add(ut UnixTime) {
+	if len(h.buf) == 0 {
+		return
+	}
+	h.buf[h.next] = ut
+	h.next++
+	if h.next == len(h.buf) {
+		h.next = 0
+		h.full = true
+	}
+}
+
+// readings returns a copy of the retained readings from the oldest to the
+// latest.
+func (h *history) readings() []UnixTime {
+	if !h.full {
+		return append([]UnixTime(nil), h.buf[:h.next]...)
+	}
+	result := make([]UnixTime, 0, len(h.buf))
+	result = append(result, h.buf[h.next:]...)
+
+	return append(result, h.buf[:h.next]...)
+}
+
+// Recent returns the readings derived from the most recent records published
+// by clockd, from the oldest to the latest, one reading per observed Count.
+// Readings rejected with ErrNotTrusted are not recorded, see
+// WithMinReadsBeforeTrust. The number of retained readings is bounded, see
+// WithHistorySize. It is a diagnostic API, e.g. for charting the dispersion
+// over time or for post incident forensics, the returned slice is a copy
+// owned by the caller.
+func (c *Client) Recent() []UnixTime {
+	c.lock()
+	defer c.unlock()
+
+	return c.history.readings()
+}
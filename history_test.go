@@ -0,0 +1,70 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	h := newHistory(3)
+	assert.Empty(t, h.readings())
+	for i := 1; i <= 5; i++ {
+		h.add(UnixTime{Sec: uint64(i)})
+		n := min(i, 3)
+		readings := h.readings()
+		require.Len(t, readings, n)
+		for j, r := range readings {
+			assert.Equal(t, uint64(i-n+j+1), r.Sec)
+		}
+	}
+
+	empty := newHistory(0)
+	empty.add(UnixTime{Sec: 1})
+	assert.Empty(t, empty.readings())
+}
+
+func TestRecent(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient(WithHistorySize(2), WithStaleThreshold(time.Hour))
+	assert.Empty(t, c.Recent())
+
+	var latest []UnixTime
+	for i := 1; i <= 3; i++ {
+		d.publish(lockedInfo(uint16(i), uint64(i)*1000))
+		ut, err := c.GetUnixTime()
+		require.NoError(t, err)
+		// repeated reads of the same Count are not retained
+		_, err = c.GetUnixTime()
+		require.NoError(t, err)
+		latest = append(latest, ut)
+	}
+	assert.Equal(t, latest[1:], c.Recent())
+
+	// the returned slice is a copy
+	c.Recent()[0] = UnixTime{}
+	assert.Equal(t, latest[1:], c.Recent())
+
+	c = d.newClient(WithHistorySize(0))
+	_, err := c.GetUnixTime()
+	require.NoError(t, err)
+	assert.Empty(t, c.Recent())
+	_, err = NewClient(d.lockPath, d.shmKey, WithHistorySize(-1))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
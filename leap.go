@@ -0,0 +1,80 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+// LeapState is the leap second status published by clockd.
+type LeapState uint8
+
+const (
+	// LeapNone indicates that no leap second is in progress or scheduled. It
+	// is also reported for records published in the legacy layout.
+	LeapNone LeapState = iota
+	// LeapSmearing indicates that clockd is smearing a leap second, i.e. the
+	// published time is slewed over a window around the leap second instead
+	// of being stepped. The uncertainty of the smeared time is accounted for
+	// in clockd's own dispersion.
+	LeapSmearing
+	// LeapPendingInsert indicates that a leap second will be inserted at the
+	// end of the current UTC day.
+	LeapPendingInsert
+	// LeapPendingDelete indicates that a leap second will be deleted at the
+	// end of the current UTC day.
+	LeapPendingDelete
+)
+
+// leapSecondUncertainty is the extra dispersion applied to readings taken
+// around the end of the UTC day while a leap second is pending, whether the
+// step has been applied to the local clock is unknown when the reading is
+// taken. Readings are only widened within leapSecondUncertainty of midnight
+// UTC, a pending leap second is advertised for the whole day before it.
+const leapSecondUncertainty uint64 = 1e9
+
+const secondsPerDay = 86400
+
+// nearLeapSecond returns a boolean value indicating whether the Unix time sec
+// and nsec is within leapSecondUncertainty of midnight UTC, i.e. of the
+// instant a leap second is inserted or deleted. Midnight is considered on
+// both sides as the pending state can still be published right after it.
+func nearLeapSecond(sec uint64, nsec uint32) bool {
+	ns := (sec%secondsPerDay)*1e9 + uint64(nsec)
+	return ns < leapSecondUncertainty ||
+		ns >= secondsPerDay*1e9-leapSecondUncertainty
+}
+
+// String returns the name of the leap state.
+func (s LeapState) String() string {
+	switch s {
+	case LeapNone:
+		return "none"
+	case LeapSmearing:
+		return "smearing"
+	case LeapPendingInsert:
+		return "pending-insert"
+	case LeapPendingDelete:
+		return "pending-delete"
+	default:
+		return "unknown"
+	}
+}
+
+// Pending returns a boolean value indicating whether a leap second step is
+// scheduled.
+func (s LeapState) Pending() bool {
+	return s == LeapPendingInsert || s == LeapPendingDelete
+}
+
+func (s LeapState) valid() bool {
+	return s <= LeapPendingDelete
+}
@@ -0,0 +1,91 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardHandler is the slog.Handler of the default logger, it is never
+// enabled so log calls are reduced to a level check.
+type discardHandler struct{}
+
+var _ slog.Handler = discardHandler{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+var discardLogger = slog.New(discardHandler{})
+
+// logStateChange logs the state transition observed after a read, not ready
+// is logged as a warning and stopped as an error.
+func (c *Client) logStateChange(ctx context.Context, old State, err error) {
+	level := slog.LevelInfo
+	switch stateOf(err) {
+	case StateNotReady, StateDegraded:
+		level = slog.LevelWarn
+	case StateStopped:
+		level = slog.LevelError
+	}
+	if !c.cfg.logger.Enabled(ctx, level) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("old", old.String()),
+		slog.String("new", stateOf(err).String()),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	c.cfg.logger.LogAttrs(ctx, level, "bounded time service state changed", attrs...)
+}
+
+// logReset logs the outcome of attaching to clockd's semaphore and shared
+// memory segment.
+func (c *Client) logReset(err error) {
+	if err != nil {
+		c.cfg.logger.LogAttrs(context.Background(), slog.LevelError,
+			"failed to attach to clockd", slog.String("lock_path", c.lockPath),
+			slog.Int("shm_key", c.shmKey), slog.Any("error", err))
+		return
+	}
+	c.cfg.logger.LogAttrs(context.Background(), slog.LevelDebug,
+		"attached to clockd", slog.String("lock_path", c.lockPath),
+		slog.Int("shm_key", c.shmKey))
+}
+
+// logMlockError logs the failure of locking clockd's region in memory.
+func (c *Client) logMlockError(err error) {
+	c.cfg.logger.LogAttrs(context.Background(), slog.LevelWarn,
+		"failed to lock clockd's region in memory",
+		slog.String("lock_path", c.lockPath), slog.Any("error", err))
+}
+
+// logDetachError logs the failure of detaching from clockd before reattaching.
+func (c *Client) logDetachError(err error) {
+	c.cfg.logger.LogAttrs(context.Background(), slog.LevelError,
+		"failed to detach from clockd", slog.String("lock_path", c.lockPath),
+		slog.Int("shm_key", c.shmKey), slog.Any("error", err))
+}
+
+// logSemaphoreError logs the failure of the semaphore operation op.
+func (c *Client) logSemaphoreError(op string, err error) {
+	c.cfg.logger.LogAttrs(context.Background(), slog.LevelError,
+		"semaphore "+op+" failed", slog.String("lock_path", c.lockPath),
+		slog.Any("error", err))
+}
@@ -0,0 +1,85 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeLogs(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var records []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var r map[string]any
+		require.NoError(t, dec.Decode(&r))
+		records = append(records, r)
+	}
+
+	return records
+}
+
+func TestLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf,
+		&slog.HandlerOptions{Level: slog.LevelDebug}))
+	d := newTestClockd(t)
+	c := d.newClient(WithLogger(logger), WithLockTimeout(20*time.Millisecond))
+	records := decodeLogs(t, buf)
+	require.Len(t, records, 1)
+	assert.Equal(t, "DEBUG", records[0]["level"])
+	assert.Equal(t, "attached to clockd", records[0]["msg"])
+
+	// not ready, the same state is only logged once
+	for i := 0; i < 2; i++ {
+		_, err := c.GetUnixTime()
+		assert.ErrorIs(t, err, ErrNotReady)
+	}
+	d.publish(lockedInfo(1, 1000))
+	_, err := c.GetUnixTime()
+	require.NoError(t, err)
+	require.NoError(t, d.mutex.Wait())
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrStopped)
+	require.NoError(t, d.mutex.Post())
+
+	records = decodeLogs(t, buf)
+	var levels []any
+	for _, r := range records {
+		if r["msg"] == "bounded time service state changed" {
+			levels = append(levels, r["level"])
+		}
+	}
+	assert.Equal(t, []any{"WARN", "INFO", "ERROR"}, levels)
+	assert.Equal(t, "stopped", records[len(records)-1]["new"])
+
+	assert.NoError(t, c.Reattach())
+	records = decodeLogs(t, buf)
+	assert.Equal(t, "attached to clockd", records[len(records)-1]["msg"])
+}
+
+func TestDefaultLoggerDiscards(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient(WithLogger(nil))
+	assert.Same(t, discardLogger, c.cfg.logger)
+	assert.False(t, c.cfg.logger.Enabled(context.Background(), slog.LevelError))
+}
@@ -0,0 +1,42 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import "time"
+
+// MetricsObserver is the interface for observing Client activities, e.g. for
+// exporting them as Prometheus metrics. Implementations must not call back
+// into the Client.
+type MetricsObserver interface {
+	// ObserveRead is invoked after each read with the time spent on the read,
+	// the dispersion of the returned UnixTime and the returned error. Reads
+	// returning ErrNotReady or ErrStopped can be told apart using errors.Is.
+	ObserveRead(latency time.Duration, dispersion uint64, err error)
+	// ObserveReset is invoked after each attempt to reattach clockd's shared
+	// memory region and semaphore.
+	ObserveReset(err error)
+}
+
+// NopMetricsObserver is a MetricsObserver that does nothing. It is used by
+// default, it can also be embedded to implement a subset of the methods.
+type NopMetricsObserver struct{}
+
+var _ MetricsObserver = NopMetricsObserver{}
+
+// ObserveRead implements the MetricsObserver interface.
+func (NopMetricsObserver) ObserveRead(time.Duration, uint64, error) {}
+
+// ObserveReset implements the MetricsObserver interface.
+func (NopMetricsObserver) ObserveReset(error) {}
@@ -0,0 +1,251 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrNoQuorum indicates that fewer clients than the quorum of a MultiClient
+	// returned a usable reading. The errors returned by the failed clients are
+	// joined to it.
+	ErrNoQuorum = errors.New("bounded time quorum not reached")
+	// ErrSourcesDisagree indicates that the readings returned by the clients
+	// of a MultiClient don't overlap, meaning at least one clockd instance is
+	// faulty.
+	ErrSourcesDisagree = errors.New("bounded time sources disagree")
+)
+
+// MultiReading is the result of reading all clients of a MultiClient.
+type MultiReading struct {
+	// Time is the intersection of the surviving readings, or their union when
+	// Disjoint is true.
+	Time UnixTime
+	// Sources is the number of clients that returned a usable reading.
+	Sources int
+	// Disjoint indicates that the surviving readings don't overlap. Time is
+	// then the union of them, it still contains the actual time as long as at
+	// least one clockd instance is correct, but it is not backed by a quorum.
+	Disjoint bool
+}
+
+// MultiClient reads several independent clockd instances, each published on
+// its own shared memory segment and accessed via its own Client, and combines
+// their readings into a single bounded time that survives some of the
+// instances dying. The clients are read concurrently. Clients failing with any
+// error are discarded, not only those reporting the state of clockd such as
+// ErrNotReady or ErrStopped, but also e.g. ErrPermission or ErrClosed, so a
+// single misconfigured instance doesn't fail the whole read. Such errors are
+// joined to ErrNoQuorum when the quorum is not reached. Degraded readings are
+// still used. The surviving readings are intersected as all of them must
+// contain the actual time. MultiClient is thread safe when its clients are.
+type MultiClient struct {
+	clients []*Client
+	quorum  int
+}
+
+var _ BoundedClock = (*MultiClient)(nil)
+
+// NewMultiClient creates a new MultiClient instance over the specified
+// clients, at least quorum of them must return a usable reading for a read
+// to succeed. The MultiClient takes over the ownership of the clients, which
+// must not be used by the caller afterwards.
+func NewMultiClient(quorum int, clients ...*Client) *MultiClient {
+	if quorum <= 0 || quorum > len(clients) {
+		panic("invalid quorum")
+	}
+
+	return &MultiClient{
+		clients: clients,
+		quorum:  quorum,
+	}
+}
+
+// Close closes all clients.
+func (m *MultiClient) Close() error {
+	var err error
+	for _, c := range m.clients {
+		err = FirstError(err, c.Close())
+	}
+
+	return err
+}
+
+// GetUnixTime returns the intersection of the readings returned by the
+// clients. ErrSourcesDisagree is returned when the readings don't overlap.
+func (m *MultiClient) GetUnixTime() (UnixTime, error) {
+	return m.GetUnixTimeContext(context.Background())
+}
+
+// GetUnixTimeContext is similar to GetUnixTime, but gives up and returns
+// ctx.Err() once the context is done.
+func (m *MultiClient) GetUnixTimeContext(ctx context.Context) (UnixTime, error) {
+	r, err := m.Read(ctx)
+	if err != nil {
+		return UnixTime{}, err
+	}
+	if r.Disjoint {
+		return UnixTime{}, fmt.Errorf("%w: %d readings don't overlap",
+			ErrSourcesDisagree, r.Sources)
+	}
+
+	return r.Time, nil
+}
+
+// After returns a boolean value indicating whether the current time is
+// definitely after the specified UnixTime.
+func (m *MultiClient) After(ut UnixTime) (bool, error) {
+	now, err := m.GetUnixTime()
+	if err != nil {
+		return false, err
+	}
+
+	return after(now, ut), nil
+}
+
+// Before returns a boolean value indicating whether the current time is
+// definitely before the specified UnixTime.
+func (m *MultiClient) Before(ut UnixTime) (bool, error) {
+	now, err := m.GetUnixTime()
+	if err != nil {
+		return false, err
+	}
+
+	return before(now, ut), nil
+}
+
+// multiReadGrace is how long Read keeps waiting for the remaining clients
+// once the quorum has been reached, clients still waiting on the semaphore of
+// a wedged clockd are then abandoned.
+const multiReadGrace = 10 * time.Millisecond
+
+// Read reads all clients concurrently and combines their readings. As the
+// readings are taken at different moments, each of them is moved forward to
+// the moment the last read completed using the monotonic clock, with its
+// dispersion inflated by the uncertainty accumulated in between according to
+// the DriftModel of the client that took it. The union of the readings is
+// returned with Disjoint set when they don't overlap. Once quorum clients
+// returned a usable reading, the remaining ones are given multiReadGrace to
+// complete, so a single wedged clockd doesn't block the read. ErrNoQuorum is
+// returned when fewer than quorum clients returned a usable reading, context
+// errors are returned as is.
+func (m *MultiClient) Read(ctx context.Context) (MultiReading, error) {
+	type result struct {
+		sample multiSample
+		err    error
+	}
+	rctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan result, len(m.clients))
+	for _, c := range m.clients {
+		go func(c *Client) {
+			before := time.Now()
+			ut, err := c.GetUnixTimeContext(rctx)
+			results <- result{
+				sample: multiSample{ut, before, time.Now(), c.cfg.driftModel},
+				err:    err,
+			}
+		}(c)
+	}
+	samples := make([]multiSample, 0, len(m.clients))
+	var errs []error
+	var grace <-chan time.Time
+	for received := 0; received < len(m.clients); {
+		select {
+		case r := <-results:
+			received++
+			if r.err != nil && !errors.Is(r.err, ErrDegraded) {
+				errs = append(errs, r.err)
+				continue
+			}
+			samples = append(samples, r.sample)
+			if len(samples) == m.quorum && received < len(m.clients) {
+				timer := time.NewTimer(multiReadGrace)
+				defer timer.Stop()
+				grace = timer.C
+			}
+		case <-grace:
+			grace = nil
+			cancel()
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return MultiReading{}, err
+	}
+	if len(samples) < m.quorum {
+		return MultiReading{}, fmt.Errorf("%w: %d of %d sources available: %w",
+			ErrNoQuorum, len(samples), len(m.clients), errors.Join(errs...))
+	}
+
+	return mergeSamples(samples), nil
+}
+
+// multiSample is a reading taken by one of the clients of a MultiClient, the
+// reading was sampled somewhere within [before, after]. model is the
+// DriftModel of the client.
+type multiSample struct {
+	ut     UnixTime
+	before time.Time
+	after  time.Time
+	model  DriftModel
+}
+
+// mergeSamples moves the samples forward to the moment the last one completed
+// and returns their intersection, or their union when they don't overlap.
+func mergeSamples(samples []multiSample) MultiReading {
+	now := samples[0].after
+	for _, r := range samples[1:] {
+		if r.after.After(now) {
+			now = r.after
+		}
+	}
+	var il, iu, ul, uu uint64
+	for i, r := range samples {
+		minElapsed := uint64(now.Sub(r.after))
+		maxElapsed := now.Sub(r.before)
+		uct := r.model.Uncertainty(int64(maxElapsed))
+		lower, upper := r.ut.Bounds()
+		// clamped at the Unix epoch as Bounds does, saturated otherwise
+		lower = addSaturated(lower, minElapsed)
+		if lower > uct {
+			lower -= uct
+		} else {
+			lower = 0
+		}
+		upper = addSaturated(addSaturated(upper, uint64(maxElapsed)), uct)
+		if i == 0 {
+			il, iu, ul, uu = lower, upper, lower, upper
+			continue
+		}
+		il, iu = max(il, lower), min(iu, upper)
+		ul, uu = min(ul, lower), max(uu, upper)
+	}
+	if il > iu {
+		return MultiReading{
+			Time:     fromBounds(ul, uu),
+			Sources:  len(samples),
+			Disjoint: true,
+		}
+	}
+
+	return MultiReading{
+		Time:    fromBounds(il, iu),
+		Sources: len(samples),
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiClient(t *testing.T) {
+	d1 := newTestClockd(t)
+	d2 := newTestClockd(t)
+	d3 := newTestClockd(t)
+	m := NewMultiClient(2, d1.newClient(), d2.newClient(), d3.newClient())
+	d1.publish(lockedInfo(1, 1000))
+	d2.publish(lockedInfo(1, 2000))
+	d3.publish(lockedInfo(1, 3000))
+
+	r, err := m.Read(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, r.Sources)
+	assert.False(t, r.Disjoint)
+	ut, err := m.GetUnixTime()
+	require.NoError(t, err)
+	// no wider than the tightest reading moved forward across the reads
+	assert.Less(t, ut.Dispersion, uint64(time.Millisecond))
+	lower, upper := ut.Bounds()
+	now := uint64(time.Now().UnixNano())
+	assert.LessOrEqual(t, lower, now)
+	assert.GreaterOrEqual(t, upper+uint64(time.Millisecond), now)
+
+	// one instance died, the remaining two still form the quorum
+	d3.publish(ClientInfo{})
+	r, err = m.Read(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, r.Sources)
+
+	d2.publish(ClientInfo{})
+	_, err = m.GetUnixTime()
+	assert.ErrorIs(t, err, ErrNoQuorum)
+	assert.ErrorIs(t, err, ErrNotReady)
+}
+
+func TestMultiClientDisagree(t *testing.T) {
+	d1 := newTestClockd(t)
+	d2 := newTestClockd(t)
+	// the host of the second instance is 10 seconds ahead
+	ahead := ClockFunc(func() (uint64, uint32) {
+		sec, nsec := getSysClockTime()
+		return sec + 10, nsec
+	})
+	m := NewMultiClient(2, d1.newClient(), d2.newClient(WithClock(ahead)))
+	d1.publish(lockedInfo(1, 1000))
+	info := lockedInfo(1, 1000)
+	info.Sec += 10
+	d2.publish(info)
+
+	r, err := m.Read(context.Background())
+	require.NoError(t, err)
+	assert.True(t, r.Disjoint)
+	assert.Equal(t, 2, r.Sources)
+	assert.Greater(t, r.Time.Dispersion, uint64(5*time.Second))
+	_, err = m.GetUnixTime()
+	assert.ErrorIs(t, err, ErrSourcesDisagree)
+	_, err = m.After(UnixTime{})
+	assert.ErrorIs(t, err, ErrSourcesDisagree)
+}
+
+func TestMultiClientWedgedSource(t *testing.T) {
+	d1 := newTestClockd(t)
+	d2 := newTestClockd(t)
+	d3 := newTestClockd(t)
+	m := NewMultiClient(2, d1.newClient(), d2.newClient(), d3.newClient())
+	d1.publish(lockedInfo(1, 1000))
+	d2.publish(lockedInfo(1, 2000))
+	d3.publish(lockedInfo(1, 3000))
+
+	// a wedged clockd holding its semaphore doesn't block the quorum
+	require.NoError(t, d3.mutex.Wait())
+	start := time.Now()
+	r, err := m.Read(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, r.Sources)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// without the quorum, the read is bounded by the context
+	d2.publish(ClientInfo{})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = m.Read(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.NoError(t, d3.mutex.Post())
+	r, err = m.Read(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, r.Sources)
+}
+
+func TestMultiClientDiscardsAnyError(t *testing.T) {
+	d1 := newTestClockd(t)
+	d2 := newTestClockd(t)
+	d3 := newTestClockd(t)
+	c3 := d3.newClient()
+	m := NewMultiClient(2, d1.newClient(), d2.newClient(), c3)
+	d1.publish(lockedInfo(1, 1000))
+	d2.publish(lockedInfo(1, 2000))
+	d3.publish(lockedInfo(1, 3000))
+
+	// a client failing with an error not describing clockd is discarded too
+	require.NoError(t, c3.Close())
+	r, err := m.Read(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, r.Sources)
+
+	d2.publish(ClientInfo{})
+	_, err = m.Read(context.Background())
+	assert.ErrorIs(t, err, ErrNoQuorum)
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.ErrorIs(t, err, ErrNotReady)
+}
+
+func TestMultiClientContext(t *testing.T) {
+	d := newTestClockd(t)
+	m := NewMultiClient(1, d.newClient())
+	d.publish(lockedInfo(1, 1000))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := m.GetUnixTimeContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NotErrorIs(t, err, ErrNoQuorum)
+
+	assert.Panics(t, func() {
+		NewMultiClient(2, d.newClient())
+	})
+}
+
+func TestMergeSamplesClampsAtEpoch(t *testing.T) {
+	now := time.Now()
+	early := now.Add(-time.Second)
+	model := LinearDriftModel(MaxClockDrift)
+	// the clock uncertainty of a slow read exceeds the lower bound
+	samples := []multiSample{
+		{UnixTime{Sec: 0, NSec: 1000, Dispersion: 500}, early, now, model},
+		{UnixTime{Sec: 0, NSec: 1000, Dispersion: 1000}, early, now, model},
+	}
+	r := mergeSamples(samples)
+	assert.False(t, r.Disjoint)
+	lower, upper := r.Time.Bounds()
+	assert.Equal(t, uint64(0), lower)
+	assert.Greater(t, upper, uint64(1e9))
+
+	// huge dispersions saturate rather than wrap around
+	samples = []multiSample{
+		{UnixTime{Sec: 1, Dispersion: math.MaxUint64}, early, early, model},
+		{UnixTime{Sec: 1, Dispersion: 1000}, now, now, model},
+	}
+	r = mergeSamples(samples)
+	assert.False(t, r.Disjoint)
+	lower, upper = r.Time.Bounds()
+	assert.Equal(t, uint64(1e9-1000), lower)
+	assert.Equal(t, uint64(1e9+1000), upper)
+}
+
+func TestMergeSamplesUsesDriftModel(t *testing.T) {
+	now := time.Now()
+	before := now.Add(-time.Millisecond)
+	ut := UnixTime{Sec: 10, Dispersion: 1000}
+	samples := []multiSample{
+		{ut, before, now, AffineDriftModel{Base: 5000}},
+		{ut, before, now, AffineDriftModel{Base: 3000}},
+	}
+	r := mergeSamples(samples)
+	lower, upper := r.Time.Bounds()
+	// the tighter of the two models bounds the intersection
+	assert.Equal(t, uint64(10e9-4000), lower)
+	assert.GreaterOrEqual(t, upper, uint64(10e9+4000)+uint64(time.Millisecond))
+	assert.Less(t, upper, uint64(10e9+5000)+uint64(time.Millisecond))
+}
@@ -0,0 +1,59 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// Namespace derives a lock path and a shared memory key from the specified
+// tenant or service identifier. It allows multiple independent clockd
+// instances, each configured with the namespace of the applications it
+// serves, to coexist on the same host without the collisions caused by all
+// parties using DefaultLockPath and DefaultShmKey. The same identifier always
+// yields the same values.
+func Namespace(id string) (lockPath string, shmKey int) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+	sum := h.Sum32()
+	// the key is a positive int32 other than IPC_PRIVATE and DefaultShmKey
+	shmKey = int(sum & 0x7FFFFFFF)
+	if shmKey == 0 || shmKey == DefaultShmKey {
+		shmKey ^= 0x40000000
+	}
+	// the hash keeps identifiers that only differ in sanitized characters
+	// apart
+	lockPath = fmt.Sprintf("%s.%08x.%s", sanitize(id), sum, DefaultLockPath)
+
+	return lockPath, shmKey
+}
+
+// sanitize makes the identifier usable in a POSIX semaphore name, which can't
+// contain any slash and is limited in length.
+func sanitize(id string) string {
+	const maxLen = 128
+	if len(id) > maxLen {
+		id = id[:maxLen]
+	}
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+			(r >= '0' && r <= '9') || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, id)
+}
@@ -0,0 +1,48 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaceIsStable(t *testing.T) {
+	p1, k1 := Namespace("billing")
+	p2, k2 := Namespace("billing")
+	assert.Equal(t, p1, p2)
+	assert.Equal(t, k1, k2)
+}
+
+func TestNamespaceIsDistinct(t *testing.T) {
+	ids := []string{"", "billing", "billing2", "a/b", "a_b", "a.b",
+		strings.Repeat("x", 300)}
+	paths := make(map[string]struct{})
+	keys := make(map[int]struct{})
+	for _, id := range ids {
+		p, k := Namespace(id)
+		assert.NotEqual(t, DefaultLockPath, p)
+		assert.NotEqual(t, DefaultShmKey, k)
+		assert.Greater(t, k, 0)
+		assert.NotContains(t, p, "/")
+		assert.Less(t, len(p), 251)
+		paths[p] = struct{}{}
+		keys[k] = struct{}{}
+	}
+	assert.Len(t, paths, len(ids))
+	assert.Len(t, keys, len(ids))
+}
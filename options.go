@@ -0,0 +1,547 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// maxClockDriftCeiling is the max accepted clock drift in ppb, 10x the
+	// default MaxClockDrift, anything larger reflects a broken clock.
+	maxClockDriftCeiling int64 = 10 * MaxClockDrift
+	// minBufferSize is the min shared memory buffer size, enough for the
+	// datalen prefix followed by the current record.
+	minBufferSize = 2 + clientInfoSize
+)
+
+var (
+	// ErrInvalidOption indicates that an invalid option is specified when
+	// creating a Client.
+	ErrInvalidOption = errors.New("invalid option")
+)
+
+// SamplePlacement specifies when the local sys clock is sampled relative to
+// copying clockd's record out of the shared memory region.
+type SamplePlacement int
+
+const (
+	// SampleBeforeCopy samples the clock right before the copy. The sampled
+	// time precedes the read of clockd's record by the copy duration, biasing
+	// the returned time towards the past. It is the default.
+	SampleBeforeCopy SamplePlacement = iota
+	// SampleAfterCopy samples the clock right after the copy. The sampled time
+	// lags the read of clockd's record by the copy duration.
+	SampleAfterCopy
+	// SampleBracket samples the clock both before and after the copy and uses
+	// the midpoint, removing the systematic bias at the cost of an extra clock
+	// read. The dispersion is widened by half the bracket so the reading stays
+	// honest.
+	SampleBracket
+)
+
+// Option is used for setting optional configurations of the Client.
+type Option func(*config)
+
+type config struct {
+	doubleRead          bool
+	strict              bool
+	degradedAge         time.Duration
+	minReadsBeforeTrust int
+	placement           SamplePlacement
+	onStateChange       func(old State, new State)
+	lockTimeout         time.Duration
+	tryLock             bool
+	maxClockDrift       int64
+	lockPath            string
+	shmKey              int
+	staleThreshold      time.Duration
+	reconnectBase       time.Duration
+	reconnectMax        time.Duration
+	metrics             MetricsObserver
+	clock               Clock
+	seqlock             bool
+	legacyLayout        bool
+	byteOrder           binary.ByteOrder
+	lockRecovery        time.Duration
+	onLockRecovered     func()
+	maxDispersion       time.Duration
+	bufferSize          int
+	logger              *slog.Logger
+	cleanupOnClose      bool
+	strictAttach        bool
+	latencyInDispersion bool
+	driftModel          DriftModel
+	readOnly            bool
+	fallbackDispersion  time.Duration
+	historySize         int
+	semaphoreMode       uint32
+	region              ShmRegion
+	regionLock          sync.Locker
+	preTouch            bool
+	mlock               bool
+}
+
+// WithDoubleRead makes the Client read the datalen prefix of the shared
+// memory region twice, once before and once after copying the region, so a
+// prefix torn by a concurrent update is reported as ErrCorruptData rather
+// than being used to slice the copied content.
+func WithDoubleRead() Option {
+	return func(cfg *config) {
+		cfg.doubleRead = true
+	}
+}
+
+// WithStrictValidation enables the strict mode in which readings that are
+// physically implausible are rejected. Currently a locked clockd publishing
+// exactly zero dispersion, which usually signals a clockd initialization bug,
+// is reported as ErrImplausibleReading.
+func WithStrictValidation() Option {
+	return func(cfg *config) {
+		cfg.strict = true
+	}
+}
+
+// WithDegradedThreshold makes the Client report readings derived from a clockd
+// reference older than the specified age as degraded. Such readings are still
+// returned, with their dispersion honestly inflated, together with
+// ErrDegraded so callers can tell clockd has frozen even though the published
+// record is still valid and locked.
+func WithDegradedThreshold(age time.Duration) Option {
+	return func(cfg *config) {
+		cfg.degradedAge = age
+	}
+}
+
+// WithMaxUsableDispersion makes the Client report readings with a dispersion
+// larger than the specified value with ErrDispersionTooLarge, e.g. after a
+// long gap in clockd's updates, so latency sensitive callers can fail fast
+// rather than honoring a huge uncertainty window. Such readings are still
+// returned together with the error. ErrDispersionTooLarge takes precedence
+// over ErrDegraded. By default the dispersion is not capped.
+func WithMaxUsableDispersion(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.maxDispersion = d
+	}
+}
+
+// WithMinReadsBeforeTrust makes the Client return ErrNotTrusted, which is an
+// ErrNotReady, until it has observed n consecutive consistent records from
+// clockd, reducing the chance of trusting a transient garbage record at
+// startup. Records are consistent when Count advances or, for the same Count,
+// the published dispersion stays the same. Once trusted, the Client doesn't
+// require such corroboration again.
+func WithMinReadsBeforeTrust(n int) Option {
+	return func(cfg *config) {
+		cfg.minReadsBeforeTrust = n
+	}
+}
+
+// WithSamplePlacement sets when the local sys clock is sampled relative to
+// copying clockd's record, the default is SampleBeforeCopy.
+func WithSamplePlacement(placement SamplePlacement) Option {
+	return func(cfg *config) {
+		cfg.placement = placement
+	}
+}
+
+// WithLatencyInDispersion makes the Client add the latency of each read,
+// including the time spent waiting for clockd's semaphore and copying its
+// record, to the Dispersion of the returned UnixTime. The local clock is
+// sampled in the middle of the read, the extra dispersion keeps the interval
+// valid by the time the caller gets to use it, at the cost of wider intervals.
+func WithLatencyInDispersion() Option {
+	return func(cfg *config) {
+		cfg.latencyInDispersion = true
+	}
+}
+
+// WithFallbackDispersion sets the dispersion of the readings taken from the
+// local clock by GetUnixTimeOrFallback when clockd is not available. The
+// default is a deliberately huge 1 minute, as the local clock is not known to
+// be synchronized at all.
+func WithFallbackDispersion(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.fallbackDispersion = d
+	}
+}
+
+// WithHistorySize sets the number of recent readings retained by the Client
+// and returned by Recent, one reading is retained per Count published by
+// clockd. A zero size disables the history, the default is 16.
+func WithHistorySize(n int) Option {
+	return func(cfg *config) {
+		cfg.historySize = n
+	}
+}
+
+// WithStateChangeCallback registers a callback invoked whenever the state of
+// clockd observed by the Client changes, e.g. from StateReady to
+// StateStopped. The state is computed on each read and the callback is only
+// invoked on transitions, synchronously from the reading goroutine and never
+// with the semaphore held.
+func WithStateChangeCallback(fn func(old State, new State)) Option {
+	return func(cfg *config) {
+		cfg.onStateChange = fn
+	}
+}
+
+// WithLockTimeout bounds how long the Client waits for clockd's semaphore on
+// each read. When clockd crashed while holding the semaphore, reads fail with
+// ErrStopped once the timeout elapses rather than blocking forever. By default
+// reads wait for the semaphore indefinitely.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(cfg *config) {
+		cfg.lockTimeout = timeout
+	}
+}
+
+// WithLockRecovery makes the Client treat clockd's semaphore as abandoned
+// when it can't be acquired within the specified timeout, e.g. clockd crashed
+// between acquiring and releasing it, the semaphore is then re-initialized
+// using Semaphore.WaitRobust rather than leaving all reads wedged. onRecovered,
+// when not nil, is invoked on each recovery with the Client's internal lock
+// held, it must not call back into the Client. Recovery races with a clockd
+// that is alive but slow, the timeout should thus be generous, e.g. several
+// seconds. WithLockTimeout takes precedence when its timeout is shorter. By
+// default the semaphore is never recovered.
+func WithLockRecovery(timeout time.Duration, onRecovered func()) Option {
+	return func(cfg *config) {
+		cfg.lockRecovery = timeout
+		cfg.onLockRecovered = onRecovered
+	}
+}
+
+// WithTryLock makes the Client fail fast with ErrBusy, which is an
+// ErrNotReady, when clockd's semaphore is currently held rather than waiting
+// for it. It takes precedence over WithLockTimeout.
+func WithTryLock() Option {
+	return func(cfg *config) {
+		cfg.tryLock = true
+	}
+}
+
+// WithMaxClockDrift sets the absolute value of the max clock drift in ppb
+// assumed by the Client when computing the dispersion introduced by the local
+// clock, e.g. 50000 for a TCXO equipped host. ppb must be positive and no
+// larger than 1e7, the default is MaxClockDrift.
+func WithMaxClockDrift(ppb int64) Option {
+	return func(cfg *config) {
+		cfg.maxClockDrift = ppb
+	}
+}
+
+// WithDriftModel sets the DriftModel used by the Client when computing the
+// dispersion introduced by the local clock, e.g. a model calibrated from the
+// hardware. It takes precedence over WithMaxClockDrift, the default is the
+// LinearDriftModel of the max clock drift.
+func WithDriftModel(model DriftModel) Option {
+	return func(cfg *config) {
+		cfg.driftModel = model
+	}
+}
+
+// WithBufferSize sets the size of the shared memory region attached by the
+// Client, the default is ClientInfoSharedMemoryBufferSize. The region is
+// created with the specified size when clockd hasn't created it yet, while
+// attaching fails when clockd created a smaller one. A region created larger
+// by clockd is attached and copied as a whole. The record in the region is
+// checked against the size advertised by its version on each read, size must
+// thus be large enough for the largest supported record.
+func WithBufferSize(size int) Option {
+	return func(cfg *config) {
+		cfg.bufferSize = size
+	}
+}
+
+// WithCleanupOnClose makes Close additionally unlink clockd's semaphore, so
+// the name doesn't leak once the Client is gone. It is only appropriate for
+// the process that owns the semaphore, e.g. a test harness that started its
+// own clockd, as any other process still using the semaphore will silently
+// stop being synchronized with new openers of the same name.
+func WithCleanupOnClose() Option {
+	return func(cfg *config) {
+		cfg.cleanupOnClose = true
+	}
+}
+
+// WithStrictAttach makes the Client only open clockd's existing semaphore and
+// shared memory segment rather than creating them when they are missing. A
+// missing semaphore is reported as ErrNoSemaphore, a missing segment is
+// reported as ErrNoSegment and a segment whose size is not the configured
+// buffer size, see WithBufferSize, is reported as ErrSegmentSizeMismatch, so
+// misconfigurations such as a wrong shm key fail at startup instead of
+// returning ErrNotReady forever.
+func WithStrictAttach() Option {
+	return func(cfg *config) {
+		cfg.strictAttach = true
+	}
+}
+
+// WithReadOnly makes the Client attach clockd's shared memory segment read
+// only, the Client never writes to it. Accidental writes then fault rather
+// than corrupting clockd's record, and the process only requires read
+// permission on the segment, so it can run with lower privileges than clockd.
+// Write access to clockd's semaphore is still required for synchronization.
+// A read only Client never creates the segment, a missing segment is reported
+// as ErrNoSegment as in strict attach mode, see WithStrictAttach.
+func WithReadOnly() Option {
+	return func(cfg *config) {
+		cfg.readOnly = true
+	}
+}
+
+// WithPreTouch makes the Client read every page of clockd's shared memory
+// region whenever it attaches to it, so the page faults are taken when
+// attaching rather than as a latency spike on the first read.
+func WithPreTouch() Option {
+	return func(cfg *config) {
+		cfg.preTouch = true
+	}
+}
+
+// WithMlock makes the Client lock clockd's shared memory region in RAM
+// whenever it attaches to it, so the region is never paged out on memory
+// pressured hosts, which would add unbounded latency to reads. Locking memory
+// requires the RLIMIT_MEMLOCK resource limit to cover the region, or the
+// CAP_IPC_LOCK capability, on Linux. Failing to lock the region is logged as a
+// warning and is otherwise ignored, the Client keeps using the unlocked
+// region. The lock is released when the segment is detached, memory provided
+// by WithShmRegion is left locked when the Client is closed.
+func WithMlock() Option {
+	return func(cfg *config) {
+		cfg.mlock = true
+	}
+}
+
+// WithSemaphoreMode sets the permission mode of clockd's semaphore when it is
+// created by the Client, e.g. 0660 for clockd and its clients running as
+// different users of the same group. It is ignored when the semaphore already
+// exists, use WithStrictAttach to require clockd to have created it. The mode
+// is subject to the umask of the process, the default is 0600.
+func WithSemaphoreMode(mode uint32) Option {
+	return func(cfg *config) {
+		cfg.semaphoreMode = mode
+	}
+}
+
+// WithShmRegion makes the Client read clockd's records from region rather than
+// attaching clockd's shared memory segment, lock plays the role of clockd's
+// semaphore and must be held by whoever writes to region. The lock and attach
+// related options, e.g. WithLockTimeout, WithTryLock or WithStrictAttach, are
+// ignored. The caller retains the ownership of region and lock, Close doesn't
+// release them. See WriteRecord for publishing records into region.
+func WithShmRegion(region ShmRegion, lock sync.Locker) Option {
+	return func(cfg *config) {
+		cfg.region = region
+		cfg.regionLock = lock
+	}
+}
+
+// WithLockPath sets the path of the lock file used for locating clockd's
+// semaphore, the default is DefaultLockPath.
+func WithLockPath(lockPath string) Option {
+	return func(cfg *config) {
+		cfg.lockPath = lockPath
+	}
+}
+
+// WithShmKey sets the key of clockd's shared memory region, the default is
+// DefaultShmKey.
+func WithShmKey(shmKey int) Option {
+	return func(cfg *config) {
+		cfg.shmKey = shmKey
+	}
+}
+
+// WithStaleThreshold sets how long the Count published by clockd can stay
+// unchanged before the Client considers clockd as stopped and returns
+// ErrStopped. The threshold must comfortably exceed the interval at which
+// clockd updates its record, plus any scheduling delay of the reading process,
+// otherwise a healthy but slow to update clockd, or an infrequently scheduled
+// client on a loaded machine, gets false ErrStopped errors. A threshold of a
+// few multiples of clockd's update interval is usually safe. The default is
+// 300ms.
+func WithStaleThreshold(threshold time.Duration) Option {
+	return func(cfg *config) {
+		cfg.staleThreshold = threshold
+	}
+}
+
+// WithReconnectBackoff sets the backoff applied to repeated attempts of
+// reattaching clockd's shared memory region and semaphore after failed reads.
+// The delay between attempts starts from base and doubles after each attempt
+// until it reaches max, it is reset once a read succeeds. Between attempts,
+// reads keep using the existing attachment, or return the error of the last
+// failed attempt without making any syscall. A zero base disables the
+// throttling. The defaults are 10ms and 1s.
+func WithReconnectBackoff(base time.Duration, max time.Duration) Option {
+	return func(cfg *config) {
+		cfg.reconnectBase = base
+		cfg.reconnectMax = max
+	}
+}
+
+// WithMetricsObserver sets the MetricsObserver notified of the reads and
+// resets performed by the Client.
+func WithMetricsObserver(observer MetricsObserver) Option {
+	return func(cfg *config) {
+		if observer == nil {
+			observer = NopMetricsObserver{}
+		}
+		cfg.metrics = observer
+	}
+}
+
+// WithLogger sets the logger used by the Client to report internal events.
+// Attaching to clockd is logged at debug level, or at error level when it
+// fails, state transitions to not ready or degraded are logged as warnings,
+// transitions to stopped and semaphore failures are logged as errors. By
+// default nothing is logged.
+func WithLogger(logger *slog.Logger) Option {
+	return func(cfg *config) {
+		if logger == nil {
+			logger = discardLogger
+		}
+		cfg.logger = logger
+	}
+}
+
+// WithClock sets the Clock sampled by the Client, e.g. a simulated clock in
+// tests, the default is the sys clock.
+func WithClock(clock Clock) Option {
+	return func(cfg *config) {
+		cfg.clock = clock
+	}
+}
+
+// WithSeqlock makes the Client read clockd's record without taking the
+// semaphore when the record carries the Seq field. clockd is expected to make
+// Seq odd before updating the record and even again after the update, the
+// Client retries the copy until Seq is even and unchanged across it, so
+// readers never serialize with clockd or each other and a clockd crashed
+// while holding the semaphore doesn't block them. Records published in the
+// legacy layout are still read with the semaphore held.
+func WithSeqlock() Option {
+	return func(cfg *config) {
+		cfg.seqlock = true
+	}
+}
+
+// WithLegacyLayout makes the Client accept records published by clockd in the
+// legacy unversioned layout, which carries no magic number, version or Seq
+// field. Without it, such records are reported as ErrVersionMismatch. It is
+// intended for rolling upgrades of clockd.
+func WithLegacyLayout() Option {
+	return func(cfg *config) {
+		cfg.legacyLayout = true
+	}
+}
+
+// WithByteOrder sets the byte order of clockd's record, e.g.
+// binary.NativeEndian which avoids byte swapping on little endian hosts where
+// clockd and the Client typically run. The byte order is advertised in the
+// version byte of the record, mismatches are reported as ErrVersionMismatch.
+// The default is Encoder.
+func WithByteOrder(order binary.ByteOrder) Option {
+	return func(cfg *config) {
+		cfg.byteOrder = order
+	}
+}
+
+// validate returns an ErrInvalidOption when the config is invalid.
+func (cfg *config) validate() error {
+	if cfg.staleThreshold <= 0 {
+		return fmt.Errorf("%w: stale threshold %s not positive",
+			ErrInvalidOption, cfg.staleThreshold)
+	}
+	if cfg.reconnectBase < 0 || cfg.reconnectMax < cfg.reconnectBase {
+		return fmt.Errorf("%w: reconnect backoff [%s, %s] invalid",
+			ErrInvalidOption, cfg.reconnectBase, cfg.reconnectMax)
+	}
+	if cfg.lockRecovery < 0 {
+		return fmt.Errorf("%w: lock recovery timeout %s negative",
+			ErrInvalidOption, cfg.lockRecovery)
+	}
+	if cfg.maxDispersion < 0 {
+		return fmt.Errorf("%w: max usable dispersion %s negative",
+			ErrInvalidOption, cfg.maxDispersion)
+	}
+	if cfg.fallbackDispersion < 0 {
+		return fmt.Errorf("%w: fallback dispersion %s negative",
+			ErrInvalidOption, cfg.fallbackDispersion)
+	}
+	if cfg.historySize < 0 {
+		return fmt.Errorf("%w: history size %d negative",
+			ErrInvalidOption, cfg.historySize)
+	}
+	if cfg.semaphoreMode&^0777 != 0 {
+		return fmt.Errorf("%w: semaphore mode %#o invalid",
+			ErrInvalidOption, cfg.semaphoreMode)
+	}
+	if cfg.bufferSize < minBufferSize {
+		return fmt.Errorf("%w: buffer size %d smaller than %d",
+			ErrInvalidOption, cfg.bufferSize, minBufferSize)
+	}
+	if cfg.byteOrder == nil {
+		return fmt.Errorf("%w: nil byte order", ErrInvalidOption)
+	}
+	if (cfg.region == nil) != (cfg.regionLock == nil) {
+		return fmt.Errorf("%w: region and its lock must both be set",
+			ErrInvalidOption)
+	}
+	if len(cfg.lockPath) == 0 {
+		return fmt.Errorf("%w: empty lock path", ErrInvalidOption)
+	}
+	if cfg.maxClockDrift <= 0 || cfg.maxClockDrift > maxClockDriftCeiling {
+		return fmt.Errorf("%w: max clock drift %dppb out of range",
+			ErrInvalidOption, cfg.maxClockDrift)
+	}
+	if cfg.placement < SampleBeforeCopy || cfg.placement > SampleBracket {
+		return fmt.Errorf("%w: sample placement %d unknown",
+			ErrInvalidOption, cfg.placement)
+	}
+	if cfg.lockTimeout < 0 {
+		return fmt.Errorf("%w: lock timeout %s negative",
+			ErrInvalidOption, cfg.lockTimeout)
+	}
+	if cfg.degradedAge < 0 {
+		return fmt.Errorf("%w: degraded threshold %s negative",
+			ErrInvalidOption, cfg.degradedAge)
+	}
+	if cfg.minReadsBeforeTrust < 0 {
+		return fmt.Errorf("%w: min reads before trust %d negative",
+			ErrInvalidOption, cfg.minReadsBeforeTrust)
+	}
+	switch m := cfg.driftModel.(type) {
+	case LinearDriftModel:
+		if m < 0 {
+			return fmt.Errorf("%w: drift model %dppb negative",
+				ErrInvalidOption, int64(m))
+		}
+	case AffineDriftModel:
+		if m.PPB < 0 {
+			return fmt.Errorf("%w: drift model %dppb negative",
+				ErrInvalidOption, m.PPB)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package thymef
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	// shmDir is the directory in which glibc creates named POSIX semaphores.
+	shmDir = "/dev/shm"
+	// semFilePrefix is the prefix glibc adds to the name of the semaphore
+	// file.
+	semFilePrefix = "sem."
+)
+
+// Ftok derives a System V IPC key from the path of an existing file and the
+// specified non-zero project id, it follows the algorithm of glibc's ftok(3)
+// so the key matches the one derived by clockd using ftok(3) for the same
+// path and project id. Unlike a bare integer such as DefaultShmKey, the key
+// is stable for as long as the file is not recreated and is unlikely to
+// collide with keys picked by unrelated applications on a shared host.
+func Ftok(path string, projID byte) (int, error) {
+	if projID == 0 {
+		return 0, fmt.Errorf("%w: zero project id", ErrInvalidOption)
+	}
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return 0, fmt.Errorf("stat %s: %w", path, err)
+	}
+	key := uint32(st.Ino&0xFFFF) |
+		uint32(uint64(st.Dev)&0xFF)<<16 | uint32(projID)<<24
+
+	// key_t is a signed 32 bits integer
+	return int(int32(key)), nil
+}
+
+// semaphoreName returns the name of the named POSIX semaphore backed by the
+// file at the specified absolute path, e.g. clockd.client.lock for
+// /dev/shm/sem.clockd.client.lock.
+func semaphoreName(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("%w: semaphore path %s is not absolute",
+			ErrInvalidOption, path)
+	}
+	path = filepath.Clean(path)
+	base := filepath.Base(path)
+	name, ok := strings.CutPrefix(base, semFilePrefix)
+	if filepath.Dir(path) != shmDir || !ok || len(name) == 0 {
+		return "", fmt.Errorf("%w: semaphore path %s not in %s/%s<name> form",
+			ErrInvalidOption, path, shmDir, semFilePrefix)
+	}
+
+	return name, nil
+}
+
+// NewClientFromPath creates a new Client instance for the clockd instance
+// whose semaphore is backed by the file at semPath, an absolute path in the
+// /dev/shm/sem.<name> form used by glibc on Linux, and whose shared memory
+// segment is identified by the key derived by Ftok from keyPath and projID.
+//
+// It is intended for deployments in which clockd runs in a different PID
+// namespace, e.g. in a sibling container. Such a container must share /dev/shm
+// with the client for the semaphore to be reachable and must share the IPC
+// namespace for the System V segment to be reachable, keyPath must refer to
+// the same file on both sides of the boundary, typically a file in the shared
+// /dev/shm, as the derived key depends on the device and inode of the file.
+// opts are applied before the lock path and shm key derived from the paths.
+func NewClientFromPath(semPath string, keyPath string, projID byte,
+	opts ...Option) (*Client, error) {
+	lockPath, err := semaphoreName(semPath)
+	if err != nil {
+		return nil, err
+	}
+	shmKey, err := Ftok(keyPath, projID)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClient(lockPath, shmKey, opts...)
+}
@@ -0,0 +1,98 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package thymef
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFtok(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clockd")
+	require.NoError(t, os.WriteFile(path, nil, 0600))
+	k1, err := Ftok(path, 'T')
+	require.NoError(t, err)
+	k2, err := Ftok(path, 'T')
+	require.NoError(t, err)
+	assert.Equal(t, k1, k2)
+	k3, err := Ftok(path, 'U')
+	require.NoError(t, err)
+	assert.NotEqual(t, k1, k3)
+	assert.Equal(t, int('T'), k1>>24)
+	assert.Equal(t, k1&0xFFFFFF, k3&0xFFFFFF)
+	// project ids with the high bit set yield negative keys, as key_t does
+	k4, err := Ftok(path, 0x80)
+	require.NoError(t, err)
+	assert.Less(t, k4, 0)
+
+	_, err = Ftok(path, 0)
+	assert.ErrorIs(t, err, ErrInvalidOption)
+	_, err = Ftok(filepath.Join(t.TempDir(), "missing"), 'T')
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSemaphoreName(t *testing.T) {
+	name, err := semaphoreName("/dev/shm/sem.clockd.client.lock")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultLockPath, name)
+	name, err = semaphoreName("/dev/shm/../shm/sem.lock")
+	require.NoError(t, err)
+	assert.Equal(t, "lock", name)
+	for _, p := range []string{"sem.lock", "/dev/shm/sem.", "/dev/shm/lock",
+		"/tmp/sem.lock", "/dev/shm/x/sem.lock"} {
+		_, err := semaphoreName(p)
+		assert.ErrorIs(t, err, ErrInvalidOption, p)
+	}
+}
+
+func TestNewClientFromPath(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("semaphores are not backed by files in /dev/shm")
+	}
+	keyPath := filepath.Join(t.TempDir(), "clockd")
+	require.NoError(t, os.WriteFile(keyPath, nil, 0600))
+	key, err := Ftok(keyPath, 'T')
+	require.NoError(t, err)
+	d := newTestClockd(t)
+	seg, err := openSegment(key, ClientInfoSharedMemoryBufferSize)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, seg.detach())
+		assert.NoError(t, seg.remove())
+	})
+	// the semaphore of d guards a segment identified by the derived key
+	d = &testClockd{t: t, lockPath: d.lockPath, shmKey: key, seg: seg,
+		mutex: d.mutex, data: seg.data}
+	d.publish(lockedInfo(1, 1000))
+
+	c, err := NewClientFromPath(filepath.Join(shmDir, semFilePrefix+d.lockPath),
+		keyPath, 'T', WithStrictAttach())
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, c.Close())
+	}()
+	_, err = c.GetUnixTime()
+	assert.NoError(t, err)
+
+	_, err = NewClientFromPath(d.lockPath, keyPath, 'T')
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
@@ -0,0 +1,142 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import "time"
+
+const (
+	// DefaultPLLPhaseGain is the default phase gain of PLLSource.
+	DefaultPLLPhaseGain float64 = 0.1
+	// DefaultPLLFrequencyGain is the default frequency gain of PLLSource.
+	DefaultPLLFrequencyGain float64 = 0.01
+)
+
+// PLLOption is used for setting optional configurations of the PLLSource.
+type PLLOption func(*PLLSource)
+
+// WithPLLPhaseGain sets the phase gain of the PLLSource, it is the portion of
+// the observed phase error corrected on each reading. It must be in the
+// (0, 1] range, smaller values give smoother but slower to converge
+// midpoints, 1 disables the smoothing.
+func WithPLLPhaseGain(gain float64) PLLOption {
+	if gain <= 0 || gain > 1 {
+		panic("invalid phase gain")
+	}
+	return func(p *PLLSource) {
+		p.phaseGain = gain
+	}
+}
+
+// WithPLLFrequencyGain sets the frequency gain of the PLLSource, it controls
+// how fast the loop learns the rate difference between the monotonic clock
+// and the time reported by the underlying source. It must be in the [0, 1)
+// range, 0 disables the frequency tracking.
+func WithPLLFrequencyGain(gain float64) PLLOption {
+	if gain < 0 || gain >= 1 {
+		panic("invalid frequency gain")
+	}
+	return func(p *PLLSource) {
+		p.freqGain = gain
+	}
+}
+
+// PLLSource is a TimeSource blending readings from another TimeSource with a
+// local phase locked loop driven by the monotonic clock. Between clockd
+// updates, the midpoint of readings only moves with the local wall clock,
+// which can be jittery. PLLSource smooths the midpoint, reducing the visible
+// jitter for purposes like UI and graphing, while keeping the dispersion
+// honest, the reported interval always contains the one reported by the
+// underlying source. When the predicted midpoint falls outside the interval
+// reported by the underlying source, e.g. after a clockd step, the loop snaps
+// to the reported midpoint, so the dispersion is never inflated beyond twice
+// the one reported by the underlying source. PLLSource is not thread safe.
+type PLLSource struct {
+	source    TimeSource
+	phaseGain float64
+	freqGain  float64
+	start     time.Time
+	monotonic func() int64
+	locked    bool
+	lastMono  int64
+	estimate  int64
+	freq      float64
+}
+
+var _ TimeSource = (*PLLSource)(nil)
+
+// NewPLLSource creates a new PLLSource instance smoothing readings from the
+// specified source.
+func NewPLLSource(source TimeSource, opts ...PLLOption) *PLLSource {
+	p := &PLLSource{
+		source:    source,
+		phaseGain: DefaultPLLPhaseGain,
+		freqGain:  DefaultPLLFrequencyGain,
+		start:     time.Now(),
+	}
+	p.monotonic = func() int64 {
+		return int64(time.Since(p.start))
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// GetUnixTime returns the smoothed current time. Readings returned together
+// with an error, e.g. ErrDegraded, are still smoothed and returned with the
+// error. The loop is unlocked when the source returns no reading.
+func (p *PLLSource) GetUnixTime() (UnixTime, error) {
+	mono := p.monotonic()
+	ut, err := p.source.GetUnixTime()
+	if ut.IsEmpty() {
+		p.locked = false
+		return UnixTime{}, err
+	}
+	measured := int64(ut.Sec*1e9 + uint64(ut.NSec))
+	elapsed := mono - p.lastMono
+	p.lastMono = mono
+	if !p.locked {
+		p.locked = true
+		p.estimate = measured
+		p.freq = 0
+		return ut, err
+	}
+	predicted := p.estimate + elapsed + int64(p.freq*float64(elapsed))
+	phaseErr := measured - predicted
+	if phaseDistance(phaseErr) > int64(ut.Dispersion) {
+		p.estimate = measured
+		return ut, err
+	}
+	p.estimate = predicted + int64(p.phaseGain*float64(phaseErr))
+	if elapsed > 0 {
+		p.freq += p.freqGain * float64(phaseErr) / float64(elapsed)
+	}
+	result := uint64(p.estimate)
+
+	return UnixTime{
+		Sec:        result / 1e9,
+		NSec:       uint32(result % 1e9),
+		Dispersion: ut.Dispersion + uint64(phaseDistance(p.estimate-measured)),
+	}, err
+}
+
+// phaseDistance returns the absolute value of the phase error v.
+func phaseDistance(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
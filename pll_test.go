@@ -0,0 +1,124 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPLLSourceSmoothsMidpoint(t *testing.T) {
+	const (
+		steps  = 1000
+		warmup = 100
+		period = int64(1e6)
+		jitter = int64(50000)
+	)
+	rng := rand.New(rand.NewSource(1))
+	truth := make([]int64, steps)
+	source := &testTimeSource{}
+	for i := 0; i < steps; i++ {
+		truth[i] = 1000e9 + int64(i)*period
+		v := uint64(truth[i] + rng.Int63n(2*jitter+1) - jitter)
+		source.readings = append(source.readings, UnixTime{
+			Sec:        v / 1e9,
+			NSec:       uint32(v % 1e9),
+			Dispersion: 1e6,
+		})
+	}
+	p := NewPLLSource(source)
+	mono := int64(0)
+	p.monotonic = func() int64 {
+		return mono
+	}
+
+	var inErr, outErr float64
+	for i := 0; i < steps; i++ {
+		mono = int64(i) * period
+		in := source.readings[i]
+		out, err := p.GetUnixTime()
+		require.NoError(t, err)
+		// dispersion is never tighter than the true bound
+		assert.GreaterOrEqual(t, out.Dispersion, in.Dispersion)
+		inLower, inUpper := in.Bounds()
+		outLower, outUpper := out.Bounds()
+		assert.LessOrEqual(t, outLower, inLower)
+		assert.GreaterOrEqual(t, outUpper, inUpper)
+		assert.LessOrEqual(t, out.Dispersion, 2*in.Dispersion)
+		if i >= warmup {
+			inErr += math.Pow(float64(in.Sub(UnixTime{})-truth[i]), 2)
+			outErr += math.Pow(float64(out.Sub(UnixTime{})-truth[i]), 2)
+		}
+	}
+	assert.Less(t, outErr, inErr/4)
+}
+
+func TestPLLSourceSnapsToStep(t *testing.T) {
+	source := &testTimeSource{
+		readings: []UnixTime{
+			{Sec: 100, Dispersion: 1000},
+			{Sec: 200, Dispersion: 1000},
+		},
+	}
+	p := NewPLLSource(source)
+	p.monotonic = func() int64 {
+		return 0
+	}
+	ut, err := p.GetUnixTime()
+	require.NoError(t, err)
+	assert.Equal(t, source.readings[0], ut)
+	ut, err = p.GetUnixTime()
+	require.NoError(t, err)
+	assert.Equal(t, source.readings[1], ut)
+}
+
+func TestPLLSourceKeepsDegradedReadings(t *testing.T) {
+	source := &testTimeSource{
+		readings: []UnixTime{
+			{Sec: 100, Dispersion: 1000},
+			{Sec: 100, NSec: 1000, Dispersion: 1000},
+			{},
+			{Sec: 200, Dispersion: 1000},
+		},
+		errs: []error{nil, ErrDegraded, ErrStopped, nil},
+	}
+	p := NewPLLSource(source)
+	mono := int64(0)
+	p.monotonic = func() int64 {
+		return mono
+	}
+	_, err := p.GetUnixTime()
+	require.NoError(t, err)
+	// the degraded reading is smoothed and returned with its error
+	mono = 1000
+	ut, err := p.GetUnixTime()
+	assert.ErrorIs(t, err, ErrDegraded)
+	assert.False(t, ut.IsEmpty())
+	assert.True(t, p.locked)
+	assert.GreaterOrEqual(t, ut.Dispersion, source.readings[1].Dispersion)
+
+	// no reading at all unlocks the loop
+	ut, err = p.GetUnixTime()
+	assert.ErrorIs(t, err, ErrStopped)
+	assert.True(t, ut.IsEmpty())
+	assert.False(t, p.locked)
+	ut, err = p.GetUnixTime()
+	require.NoError(t, err)
+	assert.Equal(t, source.readings[3], ut)
+}
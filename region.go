@@ -0,0 +1,47 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+// ShmRegion is the memory region clockd publishes its records to. The Client
+// reads clockd's System V shared memory segment by default, WithShmRegion
+// makes it read any other region, e.g. a plain byte slice in tests exercising
+// the whole read and decode pipeline without shared memory.
+type ShmRegion interface {
+	// Bytes returns the memory of the region, the returned slice must stay
+	// valid for as long as the region is used by the Client.
+	Bytes() []byte
+}
+
+var _ ShmRegion = (*segment)(nil)
+
+// Bytes implements the ShmRegion interface.
+func (s *segment) Bytes() []byte {
+	return s.data
+}
+
+// WriteRecord writes the ClientInfo record into the memory region data the way
+// clockd does, i.e. the Encoder encoded record prefixed by its datalen. The
+// caller must hold the lock of the region.
+func WriteRecord(data []byte, info ClientInfo) error {
+	if len(data) < 2 {
+		return ErrInvalidLength
+	}
+	if _, err := info.Marshal(data[2:]); err != nil {
+		return err
+	}
+	Encoder.PutUint16(data, uint16(clientInfoSize))
+
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memRegion is a ShmRegion backed by a plain byte slice.
+type memRegion struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func newMemRegion(size int) *memRegion {
+	return &memRegion{data: make([]byte, size)}
+}
+
+func (r *memRegion) Bytes() []byte {
+	return r.data
+}
+
+func (r *memRegion) publish(t *testing.T, info ClientInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	require.NoError(t, WriteRecord(r.data, info))
+}
+
+func TestShmRegion(t *testing.T) {
+	r := newMemRegion(ClientInfoSharedMemoryBufferSize)
+	c, err := NewClientWithOptions(WithShmRegion(r, &r.mu))
+	require.NoError(t, err)
+	assert.True(t, c.Attached())
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrNeverReady)
+
+	info := lockedInfo(1, 1000)
+	r.publish(t, info)
+	ut, err := c.GetUnixTime()
+	require.NoError(t, err)
+	assert.Greater(t, ut.Dispersion, info.Dispersion)
+	v, err := c.DebugInfo()
+	require.NoError(t, err)
+	assert.Equal(t, info, v)
+
+	info.Count++
+	info.Locked = false
+	r.publish(t, info)
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrNotLocked)
+
+	// the region is reattached after the failure
+	info.Count++
+	info.Locked = true
+	r.publish(t, info)
+	_, err = c.GetUnixTime()
+	require.NoError(t, err)
+
+	require.NoError(t, c.Close())
+	assert.False(t, c.Attached())
+	// the lock is left released
+	assert.True(t, r.mu.TryLock())
+	r.mu.Unlock()
+}
+
+func TestShmRegionStaleness(t *testing.T) {
+	r := newMemRegion(ClientInfoSharedMemoryBufferSize)
+	info := lockedInfo(1, 1000)
+	r.publish(t, info)
+	clock := &steppedClock{
+		wall: time.Duration(info.Sec)*time.Second + time.Duration(info.NSec),
+		mono: time.Hour,
+	}
+	c, err := NewClientWithOptions(WithShmRegion(r, &r.mu), WithClock(clock))
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, c.Close())
+	}()
+	_, err = c.GetUnixTime()
+	require.NoError(t, err)
+	clock.mono += time.Second
+	_, err = c.GetUnixTime()
+	assert.ErrorIs(t, err, ErrStopped)
+}
+
+func TestShmRegionValidation(t *testing.T) {
+	r := newMemRegion(ClientInfoSharedMemoryBufferSize - 1)
+	_, err := NewClientWithOptions(WithShmRegion(r, &r.mu))
+	assert.ErrorIs(t, err, ErrSegmentSizeMismatch)
+	_, err = NewClientWithOptions(WithShmRegion(r, nil))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+	_, err = NewClientWithOptions(WithShmRegion(nil, &r.mu))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+	assert.ErrorIs(t, WriteRecord(make([]byte, 1), ClientInfo{}),
+		ErrInvalidLength)
+	assert.ErrorIs(t, WriteRecord(make([]byte, minBufferSize-1), ClientInfo{}),
+		ErrInvalidLength)
+}
+
+func TestTruncatedRegion(t *testing.T) {
+	// the region is too small for any record
+	r := newMemRegion(minBufferSize - 1)
+	_, err := NewClientWithOptions(WithShmRegion(r, &r.mu))
+	assert.ErrorIs(t, err, ErrSegmentSizeMismatch)
+	_, err = NewClientWithOptions(WithShmRegion(r, &r.mu),
+		WithBufferSize(minBufferSize-1))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+
+	// records claiming to extend beyond the region are reported as corrupted
+	r = newMemRegion(minBufferSize)
+	c, err := NewClientWithOptions(WithShmRegion(r, &r.mu),
+		WithBufferSize(minBufferSize))
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, c.Close())
+	}()
+	r.publish(t, lockedInfo(1, 1000))
+	_, err = c.GetUnixTime()
+	require.NoError(t, err)
+	for _, datalen := range []uint16{uint16(minBufferSize - 1), 0xFFFF} {
+		Encoder.PutUint16(r.data, datalen)
+		assert.NotPanics(t, func() {
+			_, err = c.GetUnixTime()
+		})
+		assert.ErrorIs(t, err, ErrCorruptData, datalen)
+	}
+	// truncated records are reported as corrupted as well
+	r.publish(t, lockedInfo(2, 1000))
+	Encoder.PutUint16(r.data, uint16(clientInfoSize-1))
+	assert.NotPanics(t, func() {
+		_, err = c.GetUnixTime()
+	})
+	assert.ErrorIs(t, err, ErrCorruptData)
+}
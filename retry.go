@@ -0,0 +1,95 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	retryBaseDelay = 10 * time.Millisecond
+	retryMaxDelay  = time.Second
+)
+
+// GetUnixTimeRetry is similar to GetUnixTimeContext, but it retries up to
+// attempts times in total when the read fails with ErrNotReady or ErrStopped,
+// e.g. while clockd is being restarted. The delay between attempts starts
+// from 10ms and doubles after each attempt up to 1s, with up to half of it
+// randomly shaved off to avoid retrying in lockstep with other clients. Other
+// errors, e.g. ErrCorruptData, are returned immediately, so are readings
+// returned together with an error such as ErrDegraded. The last error is
+// returned once all attempts failed, ctx.Err() is returned as soon as the
+// context is done. At least one attempt is made.
+func (c *Client) GetUnixTimeRetry(ctx context.Context,
+	attempts int) (UnixTime, error) {
+	for attempt := 0; ; attempt++ {
+		ut, err := c.GetUnixTimeContext(ctx)
+		if err == nil || !retryable(err) || attempt+1 >= attempts {
+			return ut, err
+		}
+		delay := backoffDelay(retryBaseDelay, retryMaxDelay, attempt)
+		if err := sleepContext(ctx, jitter(delay)); err != nil {
+			return UnixTime{}, err
+		}
+	}
+}
+
+// WaitReady blocks until clockd is serving, i.e. until a reading derived from a
+// valid and locked record is obtained, e.g. to replace the loop polling
+// GetUnixTime at startup. It returns nil immediately when clockd is already
+// serving, including when the reading is returned together with an error such
+// as ErrDegraded. Reads failing with ErrNotReady or ErrStopped are retried
+// with the same backoff as GetUnixTimeRetry, other errors are returned
+// immediately. ctx.Err() is returned as soon as the context is done.
+func (c *Client) WaitReady(ctx context.Context) error {
+	for attempt := 0; ; attempt++ {
+		ut, err := c.GetUnixTimeContext(ctx)
+		if !ut.IsEmpty() {
+			return nil
+		}
+		if !retryable(err) {
+			return err
+		}
+		delay := backoffDelay(retryBaseDelay, retryMaxDelay, attempt)
+		if err := sleepContext(ctx, jitter(delay)); err != nil {
+			return err
+		}
+	}
+}
+
+func retryable(err error) bool {
+	return errors.Is(err, ErrNotReady) || errors.Is(err, ErrStopped)
+}
+
+// jitter returns a random delay in [d/2, d].
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	return d - rand.N(half+1)
+}
+
+// sleepContext sleeps for d or until the context is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
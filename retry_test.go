@@ -0,0 +1,121 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetUnixTimeRetry(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	start := time.Now()
+	_, err := c.GetUnixTimeRetry(context.Background(), 3)
+	assert.ErrorIs(t, err, ErrNeverReady)
+	// two jittered delays of at least 5ms and 10ms
+	assert.GreaterOrEqual(t, time.Since(start), 15*time.Millisecond)
+
+	// clockd becomes ready while retrying
+	time.AfterFunc(30*time.Millisecond, func() {
+		d.publish(lockedInfo(1, 1000))
+	})
+	ut, err := c.GetUnixTimeRetry(context.Background(), 100)
+	require.NoError(t, err)
+	assert.False(t, ut.IsEmpty())
+
+	// no retry on corrupted data
+	d.write(func(data []byte) {
+		data[0], data[1] = 0xFF, 0xFF
+	})
+	start = time.Now()
+	_, err = c.GetUnixTimeRetry(context.Background(), 100)
+	assert.ErrorIs(t, err, ErrCorruptData)
+	assert.Less(t, time.Since(start), 5*time.Millisecond)
+
+	d.publish(ClientInfo{})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	_, err = c.GetUnixTimeRetry(ctx, 1000)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// at least one attempt is made
+	_, err = c.GetUnixTimeRetry(context.Background(), 0)
+	assert.ErrorIs(t, err, ErrNotReady)
+}
+
+// readyAfter is a MetricsObserver publishing a valid and locked record into
+// the region once the specified number of reads have been observed.
+type readyAfter struct {
+	NopMetricsObserver
+	t      *testing.T
+	region *memRegion
+	reads  int
+	after  int
+}
+
+func (r *readyAfter) ObserveRead(time.Duration, uint64, error) {
+	r.reads++
+	if r.reads == r.after {
+		r.region.publish(r.t, lockedInfo(1, 1000))
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	r := newMemRegion(ClientInfoSharedMemoryBufferSize)
+	observer := &readyAfter{t: t, region: r, after: 3}
+	c, err := NewClientWithOptions(WithShmRegion(r, &r.mu),
+		WithMetricsObserver(observer))
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, c.Close())
+	}()
+	require.NoError(t, c.WaitReady(context.Background()))
+	assert.Equal(t, 4, observer.reads)
+	// already ready
+	require.NoError(t, c.WaitReady(context.Background()))
+	assert.Equal(t, 5, observer.reads)
+	_, err = c.GetUnixTime()
+	assert.NoError(t, err)
+}
+
+func TestWaitReadyCanceled(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.ErrorIs(t, c.WaitReady(ctx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// no retry on corrupted data
+	d.write(func(data []byte) {
+		data[0], data[1] = 0xFF, 0xFF
+	})
+	assert.ErrorIs(t, c.WaitReady(context.Background()), ErrCorruptData)
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		d := jitter(10 * time.Millisecond)
+		assert.GreaterOrEqual(t, d, 5*time.Millisecond)
+		assert.LessOrEqual(t, d, 10*time.Millisecond)
+	}
+	assert.Equal(t, time.Duration(0), jitter(0))
+}
@@ -36,10 +36,16 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !windows && !(linux && (amd64 || arm64 || riscv64 || ppc64le || loong64) && (!cgo || thymef_purego))
+
 package thymef
 
 import (
+	"errors"
+	"os"
+	"strings"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -50,18 +56,65 @@ import (
 // #include <sys/types.h>
 // #include <semaphore.h>
 // #include <time.h>
+// #include <errno.h>
 // #ifndef GO_SEM_LIB_
 // #define GO_SEM_LIB_
 // sem_t* Go_sem_open(const char *name, int oflag, mode_t mode, unsigned int value)
 // {
 //		return sem_open(name, oflag, mode, value);
 // }
+// int Go_sem_timedwait(sem_t *sem, long long timeout)
+// {
+// #ifdef __APPLE__
+//		// macOS doesn't provide sem_timedwait
+//		struct timespec ts = { 0, 1000000 };
+//		for (;;) {
+//			if (sem_trywait(sem) == 0) {
+//				return 0;
+//			}
+//			if (errno != EAGAIN) {
+//				return -1;
+//			}
+//			if (timeout <= 0) {
+//				errno = ETIMEDOUT;
+//				return -1;
+//			}
+//			nanosleep(&ts, NULL);
+//			timeout -= ts.tv_nsec;
+//		}
+// #else
+//		struct timespec ts;
+//		if (clock_gettime(CLOCK_REALTIME, &ts) != 0) {
+//			return -1;
+//		}
+//		ts.tv_sec += timeout / 1000000000LL;
+//		ts.tv_nsec += timeout % 1000000000LL;
+//		if (ts.tv_nsec >= 1000000000L) {
+//			ts.tv_sec++;
+//			ts.tv_nsec -= 1000000000L;
+//		}
+//		// retry against the same absolute deadline when interrupted
+//		for (;;) {
+//			int ret = sem_timedwait(sem, &ts);
+//			if (ret == 0 || errno != EINTR) {
+//				return ret;
+//			}
+//		}
+// #endif
+// }
 // #endif
 import "C"
 
+// ErrTimeout is returned by TimedWait when the semaphore can't be acquired
+// before the timeout elapses.
+var ErrTimeout = errors.New("semaphore wait timed out")
+
+// Semaphore is a named POSIX semaphore used by clockd and its clients as an
+// inter-process lock. See semaphore_windows.go for the Windows counterpart.
 type Semaphore struct {
 	sem  *C.sem_t //semaphore returned by sem_open
 	name string   //name of semaphore
+	mode uint32   //permissions of semaphore
 }
 
 // Open creates a new POSIX semaphore or opens an existing semaphore.
@@ -78,7 +131,33 @@ func NewSemaphore(name string, mode, value uint32) (*Semaphore, error) {
 		return nil, err
 	}
 
-	return &Semaphore{sem: sem, name: name}, nil
+	return &Semaphore{sem: sem, name: name, mode: mode}, nil
+}
+
+// OpenSemaphore opens the existing POSIX semaphore identified by name, it
+// fails with ENOENT rather than creating the semaphore when it doesn't exist.
+// The permissions of the existing semaphore are recorded so the semaphore is
+// recreated with the same permissions by WaitRobust, they are assumed to be
+// 0600 on platforms where semaphores are not backed by files in /dev/shm.
+func OpenSemaphore(name string) (*Semaphore, error) {
+	n := C.CString(name)
+	sem, err := C.Go_sem_open(n, 0, 0, 0)
+	C.free(unsafe.Pointer(n))
+	if sem == nil {
+		return nil, err
+	}
+	mode := uint32(defaultSemaphoreMode)
+	if fi, err := os.Stat(semaphoreFile(name)); err == nil {
+		mode = uint32(fi.Mode().Perm())
+	}
+
+	return &Semaphore{sem: sem, name: name, mode: mode}, nil
+}
+
+// semaphoreFile returns the path of the file backing the named semaphore in
+// glibc's implementation.
+func semaphoreFile(name string) string {
+	return "/dev/shm/sem." + strings.TrimPrefix(name, "/")
 }
 
 // Close closes the named semaphore, allowing any resources that the system has
@@ -104,17 +183,69 @@ func (s *Semaphore) Post() error {
 
 // Wait decrements the semaphore. If the semaphore's value is greater than zero,
 // then the decrement proceeds, and the function returns, immediately. If the
-// semaphore currently has the value zero, then the call blocks until either
-// it becomes possible to perform the decrement, or a signal interrupts the call.
+// semaphore currently has the value zero, then the call blocks until it
+// becomes possible to perform the decrement. Waits interrupted by signals,
+// e.g. the SIGURG used by the Go runtime for preemption, are retried.
 func (s *Semaphore) Wait() error {
-	ret, err := C.sem_wait(s.sem)
+	for {
+		ret, err := C.sem_wait(s.sem)
+		if ret == 0 {
+			return nil
+		}
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// TimedWait is similar to Wait, but it gives up and returns ErrTimeout once the
+// specified timeout elapses. The absolute deadline is computed against
+// CLOCK_REALTIME as required by sem_timedwait(3), waits interrupted by signals
+// are retried against the same deadline.
+func (s *Semaphore) TimedWait(timeout time.Duration) error {
+	timeout = max(timeout, 0)
+	ret, err := C.Go_sem_timedwait(s.sem, C.longlong(timeout))
 	if ret != 0 {
+		if err == syscall.ETIMEDOUT {
+			return ErrTimeout
+		}
 		return err
 	}
 
 	return nil
 }
 
+// TryWait is similar to Wait, but it never blocks. It returns false together
+// with a nil error when the semaphore currently has the value zero.
+func (s *Semaphore) TryWait() (bool, error) {
+	ret, err := C.sem_trywait(s.sem)
+	if ret != 0 {
+		if err == syscall.EAGAIN {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetValue returns the current value of the semaphore, it is mostly useful
+// for diagnostics. The Client assumes a binary semaphore with the initial value
+// of 1, a value stuck at 0 for long periods suggests that the lock is held by
+// a wedged or crashed process, a value greater than 1 suggests a leaked post.
+// When the semaphore is locked, POSIX allows the returned value to be either 0
+// or a negative number whose absolute value is the number of waiters, Linux
+// returns 0. On macOS sem_getvalue is not supported and an error is returned.
+func (s *Semaphore) GetValue() (int, error) {
+	var sval C.int
+	ret, err := C.sem_getvalue(s.sem, &sval)
+	if ret != 0 {
+		return 0, err
+	}
+
+	return int(sval), nil
+}
+
 // Unlink removes the named semaphore. The semaphore name is removed immediately.
 // The semaphore is destroyed once all other processes that have the semaphore
 // open close it.
@@ -0,0 +1,304 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux && (amd64 || arm64 || riscv64 || ppc64le || loong64) && (!cgo || thymef_purego)
+
+package thymef
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// The pure Go Semaphore below is selected on 64-bit little endian Linux when
+// cgo is disabled or when the thymef_purego build tag is specified. It maps
+// the same /dev/shm/sem.<name> file created by glibc's sem_open(3) and
+// operates on it using glibc's 64-bit semaphore layout, so it interoperates
+// with clockd or any other process using POSIX named semaphores.
+//
+// The layout is
+//
+//	uint64 data    // value in the low 32 bits, waiters in the high 32 bits
+//	int32  private // 128 for process shared semaphores
+//	int32  pad
+//
+// waiters register themselves in the high 32 bits of data before sleeping on
+// the futex covering the value word, posters only issue a futex wake when
+// there are registered waiters.
+
+const (
+	semDir           = "/dev/shm/sem."
+	semSize          = 32
+	semNWaitersShift = 32
+	semValueMask     = 1<<semNWaitersShift - 1
+	semFutexShared   = 128
+	futexWait        = 0
+	futexWake        = 1
+)
+
+// ErrTimeout is returned by TimedWait when the semaphore can't be acquired
+// before the timeout elapses.
+var ErrTimeout = errors.New("semaphore wait timed out")
+
+// Semaphore is a named POSIX semaphore used by clockd and its clients as an
+// inter-process lock.
+type Semaphore struct {
+	mem  []byte
+	data *uint64
+	name string
+	mode uint32
+}
+
+// semPath returns the path of the file backing the named semaphore, the name
+// is checked the same way as sem_open(3) does.
+func semPath(name string) (string, error) {
+	name = strings.TrimLeft(name, "/")
+	if len(name) == 0 || strings.Contains(name, "/") {
+		return "", syscall.EINVAL
+	}
+
+	return semDir + name, nil
+}
+
+// NewSemaphore creates a new POSIX semaphore or opens an existing semaphore.
+// The semaphore is identified by name. The mode argument specifies the permissions
+// to be placed on the new semaphore. The value argument specifies the initial
+// value for the new semaphore. If the named semaphore already exist, mode and
+// value are ignored.
+// For details see sem_overview(7).
+func NewSemaphore(name string, mode, value uint32) (*Semaphore, error) {
+	if value > math.MaxInt32 {
+		return nil, syscall.EINVAL
+	}
+	path, err := semPath(name)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err == nil {
+			return mapSemaphore(f, name, mode)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err := createSemaphore(path, mode, value); err != nil &&
+			!errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+	}
+}
+
+// OpenSemaphore opens the existing POSIX semaphore identified by name, it
+// fails with ENOENT rather than creating the semaphore when it doesn't exist.
+func OpenSemaphore(name string) (*Semaphore, error) {
+	path, err := semPath(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	// the semaphore is recreated with the same permissions by WaitRobust
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return mapSemaphore(f, name, uint32(fi.Mode().Perm()))
+}
+
+// createSemaphore initializes the semaphore in a temporary file before
+// linking it into place, so other processes never observe a partially
+// initialized semaphore.
+func createSemaphore(path string, mode, value uint32) error {
+	var tmp string
+	var f *os.File
+	for {
+		var err error
+		tmp = fmt.Sprintf("%stmp.%d.%d", semDir, os.Getpid(), rand.Uint64())
+		f, err = os.OpenFile(tmp,
+			os.O_RDWR|os.O_CREATE|os.O_EXCL, os.FileMode(mode&0777))
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+	}
+	defer func() {
+		_ = os.Remove(tmp)
+	}()
+	var init [semSize]byte
+	binary.LittleEndian.PutUint64(init[0:], uint64(value))
+	binary.LittleEndian.PutUint32(init[8:], semFutexShared)
+	_, err := f.Write(init[:])
+	err = FirstError(err, f.Close())
+	if err != nil {
+		return err
+	}
+
+	return os.Link(tmp, path)
+}
+
+func mapSemaphore(f *os.File, name string, mode uint32) (*Semaphore, error) {
+	mem, err := syscall.Mmap(int(f.Fd()), 0, semSize,
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if cerr := f.Close(); err == nil && cerr != nil {
+		_ = syscall.Munmap(mem)
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Semaphore{
+		mem:  mem,
+		data: (*uint64)(unsafe.Pointer(&mem[0])),
+		name: name,
+		mode: mode,
+	}, nil
+}
+
+// Close unmaps the named semaphore, allowing any resources that the system has
+// allocated to the calling process for this semaphore to be freed.
+func (s *Semaphore) Close() error {
+	return syscall.Munmap(s.mem)
+}
+
+// Post increments the semaphore.
+func (s *Semaphore) Post() error {
+	for {
+		d := atomic.LoadUint64(s.data)
+		if d&semValueMask == math.MaxInt32 {
+			return syscall.EOVERFLOW
+		}
+		if atomic.CompareAndSwapUint64(s.data, d, d+1) {
+			if d>>semNWaitersShift > 0 {
+				return s.futex(futexWake, 1, nil)
+			}
+			return nil
+		}
+	}
+}
+
+// Wait decrements the semaphore. If the semaphore's value is greater than zero,
+// then the decrement proceeds, and the function returns, immediately. If the
+// semaphore currently has the value zero, then the call blocks until it
+// becomes possible to perform the decrement.
+func (s *Semaphore) Wait() error {
+	return s.wait(time.Time{})
+}
+
+// TimedWait is similar to Wait, but it gives up and returns ErrTimeout once the
+// specified timeout elapses. Waits interrupted by signals are retried against
+// the same deadline.
+func (s *Semaphore) TimedWait(timeout time.Duration) error {
+	return s.wait(time.Now().Add(max(timeout, 0)))
+}
+
+// TryWait is similar to Wait, but it never blocks. It returns false together
+// with a nil error when the semaphore currently has the value zero.
+func (s *Semaphore) TryWait() (bool, error) {
+	return s.tryDecrement(0), nil
+}
+
+// GetValue returns the current value of the semaphore, it is mostly useful
+// for diagnostics. The Client assumes a binary semaphore with the initial value
+// of 1, a value stuck at 0 for long periods suggests that the lock is held by
+// a wedged or crashed process, a value greater than 1 suggests a leaked post.
+func (s *Semaphore) GetValue() (int, error) {
+	return int(atomic.LoadUint64(s.data) & semValueMask), nil
+}
+
+// Unlink removes the named semaphore. The semaphore name is removed immediately.
+// The semaphore is destroyed once all other processes that have the semaphore
+// open close it.
+func (s *Semaphore) Unlink() error {
+	path, err := semPath(s.name)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// tryDecrement decrements the value when it is positive, the number of
+// waiters is decremented by waiters at the same time.
+func (s *Semaphore) tryDecrement(waiters uint64) bool {
+	for {
+		d := atomic.LoadUint64(s.data)
+		if d&semValueMask == 0 {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(s.data,
+			d, d-1-waiters<<semNWaitersShift) {
+			return true
+		}
+	}
+}
+
+// wait blocks until the value is decremented or the deadline is reached, a
+// zero deadline means waiting forever.
+func (s *Semaphore) wait(deadline time.Time) error {
+	if s.tryDecrement(0) {
+		return nil
+	}
+	atomic.AddUint64(s.data, 1<<semNWaitersShift)
+	for {
+		if s.tryDecrement(1) {
+			return nil
+		}
+		var ts *syscall.Timespec
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				atomic.AddUint64(s.data, ^uint64(1<<semNWaitersShift-1))
+				return ErrTimeout
+			}
+			v := syscall.NsecToTimespec(int64(remaining))
+			ts = &v
+		}
+		err := s.futex(futexWait, 0, ts)
+		if err != nil && err != syscall.EAGAIN &&
+			err != syscall.EINTR && err != syscall.ETIMEDOUT {
+			atomic.AddUint64(s.data, ^uint64(1<<semNWaitersShift-1))
+			return err
+		}
+	}
+}
+
+// futex invokes the futex(2) syscall on the value word, which is the low 32
+// bits of data on little endian systems.
+func (s *Semaphore) futex(op uintptr, val uint32, ts *syscall.Timespec) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_FUTEX,
+		uintptr(unsafe.Pointer(s.data)), op, uintptr(val),
+		uintptr(unsafe.Pointer(ts)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
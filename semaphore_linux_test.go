@@ -0,0 +1,116 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// interruptedWait blocks in wait on a locked OS thread while the thread is
+// repeatedly sent SIGURG, the signal used by the Go runtime for preemption.
+// The semaphore is posted once the thread has been interrupted for a while.
+func interruptedWait(t *testing.T, s *Semaphore, wait func() error) {
+	tid := make(chan int)
+	done := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		tid <- syscall.Gettid()
+		done <- wait()
+	}()
+	id := <-tid
+	deadline := time.Now().Add(50 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		require.NoError(t, syscall.Tgkill(os.Getpid(), id, syscall.SIGURG))
+		time.Sleep(100 * time.Microsecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("wait returned before post: %v", err)
+	default:
+	}
+	require.NoError(t, s.Post())
+	assert.NoError(t, <-done)
+}
+
+func TestSemaphoreWaitRetriesOnSignal(t *testing.T) {
+	s := newTestSemaphore(t, 0)
+	interruptedWait(t, s, s.Wait)
+}
+
+func TestSemaphoreTimedWaitRetriesOnSignal(t *testing.T) {
+	s := newTestSemaphore(t, 0)
+	interruptedWait(t, s, func() error {
+		return s.TimedWait(time.Minute)
+	})
+}
+
+func TestOpenSemaphoreModeSurvivesRecovery(t *testing.T) {
+	d := newTestClockd(t)
+	lockPath := d.lockPath + ".robust"
+	owner, err := NewSemaphore(lockPath, 0640, 1)
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, owner.Close())
+	}()
+	s, err := OpenSemaphore(lockPath)
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, s.Unlink())
+		assert.NoError(t, s.Close())
+	}()
+	assert.Equal(t, uint32(0640), s.mode)
+	// the owner dies while holding the semaphore
+	require.NoError(t, owner.Wait())
+	recovered, err := s.WaitRobust(10 * time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, recovered)
+	require.NoError(t, s.Post())
+	fi, err := os.Stat("/dev/shm/sem." + lockPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+}
+
+func TestClientSemaphoreMode(t *testing.T) {
+	d := newTestClockd(t)
+	tests := []struct {
+		opts []Option
+		mode os.FileMode
+	}{
+		{nil, 0600},
+		{[]Option{WithSemaphoreMode(0640)}, 0640},
+	}
+	for idx, tt := range tests {
+		lockPath := fmt.Sprintf("%s.%d", d.lockPath, idx)
+		c, err := NewClient(lockPath, d.shmKey, tt.opts...)
+		require.NoError(t, err)
+		fi, err := os.Stat("/dev/shm/sem." + lockPath)
+		require.NoError(t, err)
+		assert.Equal(t, tt.mode, fi.Mode().Perm(), idx)
+		assert.NoError(t, c.mutex.Unlink())
+		assert.NoError(t, c.Close())
+	}
+
+	_, err := NewClient(d.lockPath, d.shmKey, WithSemaphoreMode(01777))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
@@ -0,0 +1,65 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// WaitRobust is similar to TimedWait, but it assumes that the holder died
+// while holding the semaphore when the semaphore can't be acquired before the
+// timeout elapses, e.g. clockd crashed in the middle of an update. In that
+// case, the semaphore is re-initialized by unlinking it and creating a new one
+// with the same name and the value of 1, it is then waited for once more with
+// the same timeout. The returned boolean value indicates whether such
+// recovery happened.
+//
+// Recovery races with a holder that is alive but slower than the timeout, both
+// parties end up holding their own copy of the semaphore and the holder's
+// next update is no longer excluded from concurrent reads. Other processes
+// recovering at the same time can also unlink the semaphore just created by
+// each other. The timeout should thus be generous, i.e. many times longer than
+// the longest time the semaphore is legitimately held. On Windows, a mutex
+// abandoned by a dead owner is acquired by Wait directly and recovery is
+// rarely required.
+func (s *Semaphore) WaitRobust(timeout time.Duration) (bool, error) {
+	err := s.TimedWait(timeout)
+	if !errors.Is(err, ErrTimeout) {
+		return false, err
+	}
+	if err := s.reinitialize(); err != nil {
+		return false, err
+	}
+
+	return true, s.TimedWait(timeout)
+}
+
+// reinitialize replaces the semaphore with a newly created one of the same
+// name, the semaphore value is set to 1.
+func (s *Semaphore) reinitialize() error {
+	if err := s.Unlink(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	ns, err := NewSemaphore(s.name, s.mode, 1)
+	if err != nil {
+		return err
+	}
+	err = s.Close()
+	*s = *ns
+
+	return err
+}
@@ -0,0 +1,166 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package thymef
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSemaphore(t *testing.T, value uint32) *Semaphore {
+	name := fmt.Sprintf("/thymef.test.sem.%d.%s", os.Getpid(), t.Name())
+	s, err := NewSemaphore(name, 0600, value)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, s.Unlink())
+		assert.NoError(t, s.Close())
+	})
+
+	return s
+}
+
+func TestSemaphoreTimedWait(t *testing.T) {
+	s := newTestSemaphore(t, 1)
+	require.NoError(t, s.TimedWait(time.Second))
+
+	start := time.Now()
+	assert.ErrorIs(t, s.TimedWait(20*time.Millisecond), ErrTimeout)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	assert.ErrorIs(t, s.TimedWait(-time.Second), ErrTimeout)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		assert.NoError(t, s.Post())
+	}()
+	assert.NoError(t, s.TimedWait(5*time.Second))
+}
+
+func TestSemaphoreTryWait(t *testing.T) {
+	s := newTestSemaphore(t, 0)
+	acquired, err := s.TryWait()
+	require.NoError(t, err)
+	assert.False(t, acquired)
+
+	require.NoError(t, s.Post())
+	require.NoError(t, s.Post())
+	for i := 0; i < 2; i++ {
+		acquired, err = s.TryWait()
+		require.NoError(t, err)
+		assert.True(t, acquired)
+	}
+	acquired, err = s.TryWait()
+	require.NoError(t, err)
+	assert.False(t, acquired)
+}
+
+func TestSemaphoreWaitRobust(t *testing.T) {
+	s := newTestSemaphore(t, 1)
+	recovered, err := s.WaitRobust(time.Second)
+	require.NoError(t, err)
+	assert.False(t, recovered)
+
+	// the holder never posts
+	recovered, err = s.WaitRobust(20 * time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, recovered)
+	v, err := s.GetValue()
+	require.NoError(t, err)
+	assert.Equal(t, 0, v)
+	require.NoError(t, s.Post())
+}
+
+func TestSemaphoreGetValue(t *testing.T) {
+	s := newTestSemaphore(t, 1)
+	v, err := s.GetValue()
+	require.NoError(t, err)
+	assert.Equal(t, 1, v)
+
+	require.NoError(t, s.Wait())
+	v, err = s.GetValue()
+	require.NoError(t, err)
+	assert.LessOrEqual(t, v, 0)
+
+	require.NoError(t, s.Post())
+	require.NoError(t, s.Post())
+	v, err = s.GetValue()
+	require.NoError(t, err)
+	assert.Equal(t, 2, v)
+}
+
+// Run with -tags thymef_purego or CGO_ENABLED=0 to benchmark the futex based
+// implementation instead of the cgo one.
+func BenchmarkSemaphoreWaitPost(b *testing.B) {
+	name := fmt.Sprintf("/thymef.bench.sem.%d", os.Getpid())
+	s, err := NewSemaphore(name, 0600, 1)
+	require.NoError(b, err)
+	defer func() {
+		assert.NoError(b, s.Unlink())
+		assert.NoError(b, s.Close())
+	}()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.Wait(); err != nil {
+			b.Fatal(err)
+		}
+		if err := s.Post(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSemaphoreContended(b *testing.B) {
+	name := fmt.Sprintf("/thymef.bench.sem.%d", os.Getpid())
+	s, err := NewSemaphore(name, 0600, 1)
+	require.NoError(b, err)
+	defer func() {
+		assert.NoError(b, s.Unlink())
+		assert.NoError(b, s.Close())
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := s.Wait(); err != nil {
+				b.Error(err)
+				return
+			}
+			if err := s.Post(); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func TestOpenSemaphore(t *testing.T) {
+	s := newTestSemaphore(t, 1)
+	m, err := OpenSemaphore(s.name)
+	require.NoError(t, err)
+	require.NoError(t, m.Wait())
+	ok, err := s.TryWait()
+	require.NoError(t, err)
+	assert.False(t, ok)
+	require.NoError(t, m.Post())
+	require.NoError(t, m.Close())
+
+	_, err = OpenSemaphore(s.name + ".missing")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
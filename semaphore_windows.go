@@ -0,0 +1,171 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package thymef
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// ErrTimeout is returned by TimedWait when the semaphore can't be acquired
+// before the timeout elapses.
+var ErrTimeout = errors.New("semaphore wait timed out")
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procCreateMutexW = kernel32.NewProc("CreateMutexW")
+	procReleaseMutex = kernel32.NewProc("ReleaseMutex")
+	procOpenMutexW   = kernel32.NewProc("OpenMutexW")
+)
+
+// Semaphore is a named Windows mutex used by clockd and its clients as an
+// inter-process lock. It provides the same methods as the POSIX semaphore
+// used on other platforms with binary semaphore semantics.
+//
+// Windows mutexes are owned by the thread that acquired them, the calling
+// goroutine is locked to its OS thread from a successful Wait, TimedWait or
+// TryWait until the matching Post, which must be made by the same goroutine.
+type Semaphore struct {
+	handle syscall.Handle
+	name   string
+	mode   uint32
+}
+
+// mutexName maps the semaphore name to a mutex name in the Global namespace,
+// backslashes are reserved by the object manager.
+func mutexName(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	return `Global\` + strings.ReplaceAll(name, `\`, "_")
+}
+
+// NewSemaphore creates a new named mutex or opens an existing one. mode is
+// ignored, a non-zero value creates the mutex unowned while a zero value
+// makes the calling thread its initial owner. Similar to sem_open, value is
+// ignored when the named mutex already exists.
+func NewSemaphore(name string, mode, value uint32) (*Semaphore, error) {
+	n, err := syscall.UTF16PtrFromString(mutexName(name))
+	if err != nil {
+		return nil, err
+	}
+	var owned uintptr
+	if value == 0 {
+		runtime.LockOSThread()
+		owned = 1
+	}
+	h, _, err := procCreateMutexW.Call(0, owned, uintptr(unsafe.Pointer(n)))
+	if h == 0 {
+		if owned != 0 {
+			runtime.UnlockOSThread()
+		}
+		return nil, err
+	}
+
+	return &Semaphore{handle: syscall.Handle(h), name: name, mode: mode}, nil
+}
+
+// OpenSemaphore opens the existing named mutex identified by name, it fails
+// with ERROR_FILE_NOT_FOUND rather than creating the mutex when it doesn't
+// exist.
+func OpenSemaphore(name string) (*Semaphore, error) {
+	n, err := syscall.UTF16PtrFromString(mutexName(name))
+	if err != nil {
+		return nil, err
+	}
+	const access = syscall.SYNCHRONIZE | 0x0001 // MUTEX_MODIFY_STATE
+	h, _, err := procOpenMutexW.Call(access, 0, uintptr(unsafe.Pointer(n)))
+	if h == 0 {
+		return nil, err
+	}
+
+	// mode is ignored on Windows, it is recorded for consistency with the
+	// semaphores opened on other platforms
+	return &Semaphore{handle: syscall.Handle(h), name: name,
+		mode: defaultSemaphoreMode}, nil
+}
+
+// Close closes the mutex handle.
+func (s *Semaphore) Close() error {
+	return syscall.CloseHandle(s.handle)
+}
+
+// Post releases the mutex.
+func (s *Semaphore) Post() error {
+	ret, _, err := procReleaseMutex.Call(uintptr(s.handle))
+	if ret == 0 {
+		return err
+	}
+	runtime.UnlockOSThread()
+
+	return nil
+}
+
+// Wait acquires the mutex, it blocks until the mutex becomes available. A
+// mutex abandoned by a crashed owner is acquired as usual.
+func (s *Semaphore) Wait() error {
+	return s.wait(syscall.INFINITE)
+}
+
+// TimedWait is similar to Wait, but it gives up and returns ErrTimeout once the
+// specified timeout elapses. The timeout is rounded up to milliseconds.
+func (s *Semaphore) TimedWait(timeout time.Duration) error {
+	timeout = max(timeout, 0)
+	ms := (timeout + time.Millisecond - 1) / time.Millisecond
+	return s.wait(uint32(min(ms, syscall.INFINITE-1)))
+}
+
+// TryWait is similar to Wait, but it never blocks. It returns false together
+// with a nil error when the mutex is currently owned by another thread.
+func (s *Semaphore) TryWait() (bool, error) {
+	if err := s.wait(0); err != nil {
+		if errors.Is(err, ErrTimeout) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *Semaphore) wait(ms uint32) error {
+	runtime.LockOSThread()
+	event, err := syscall.WaitForSingleObject(s.handle, ms)
+	switch event {
+	case syscall.WAIT_OBJECT_0, syscall.WAIT_ABANDONED:
+		return nil
+	case syscall.WAIT_TIMEOUT:
+		err = ErrTimeout
+	}
+	runtime.UnlockOSThread()
+
+	return err
+}
+
+// GetValue is not supported on Windows as mutexes don't expose their state,
+// an error is always returned.
+func (s *Semaphore) GetValue() (int, error) {
+	return 0, syscall.EWINDOWS
+}
+
+// Unlink is a no-op on Windows, the named mutex is destroyed by the system
+// once the last handle to it is closed.
+func (s *Semaphore) Unlink() error {
+	return nil
+}
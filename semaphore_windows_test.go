@@ -0,0 +1,58 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package thymef
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWindowsSemaphoreIsExclusive(t *testing.T) {
+	name := fmt.Sprintf("thymef.test.sem.%d.%s", os.Getpid(), t.Name())
+	s, err := NewSemaphore(name, 0600, 1)
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, s.Close())
+	}()
+
+	require.NoError(t, s.Wait())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		acquired, err := s.TryWait()
+		assert.NoError(t, err)
+		assert.False(t, acquired)
+		assert.ErrorIs(t, s.TimedWait(10*time.Millisecond), ErrTimeout)
+	}()
+	<-done
+	require.NoError(t, s.Post())
+
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		acquired, err := s.TryWait()
+		assert.NoError(t, err)
+		assert.True(t, acquired)
+		assert.NoError(t, s.Post())
+	}()
+	<-done
+}
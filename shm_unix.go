@@ -0,0 +1,140 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package thymef
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/gen2brain/shm"
+)
+
+// segment is a System V shared memory segment attached to the process.
+type segment struct {
+	id   int
+	data []byte
+}
+
+// openSegment attaches the System V shared memory segment identified by key,
+// the segment is created when it doesn't exist yet.
+func openSegment(key int, size int) (*segment, error) {
+	id, err := shm.Get(key, size, shm.IPC_CREAT|0600)
+	if err != nil {
+		return nil, permissionError(key, err)
+	}
+	data, err := shm.At(id, 0, 0)
+	if err != nil {
+		return nil, permissionError(key, err)
+	}
+
+	return &segment{id: id, data: data}, nil
+}
+
+// attachSegment attaches the existing System V shared memory segment
+// identified by key, ErrNoSegment is returned when it doesn't exist and
+// ErrSegmentSizeMismatch is returned when it is smaller than size bytes. The
+// segment is attached with SHM_RDONLY when readOnly is set, only read
+// permission on the segment is then required.
+func attachSegment(key int, size int, readOnly bool) (*segment, error) {
+	perm, flags := 0600, 0
+	if readOnly {
+		perm, flags = 0400, shm.SHM_RDONLY
+	}
+	id, err := shm.Get(key, 0, perm)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: no segment with key %d", ErrNoSegment, key)
+		}
+		return nil, permissionError(key, err)
+	}
+	data, err := shm.At(id, 0, flags)
+	if err != nil {
+		return nil, permissionError(key, err)
+	}
+	if len(data) < size {
+		_ = shm.Dt(data)
+		return nil, fmt.Errorf("%w: segment %d has %d bytes, expected %d",
+			ErrSegmentSizeMismatch, key, len(data), size)
+	}
+
+	return &segment{id: id, data: data}, nil
+}
+
+// permissionError turns the EACCES or EPERM error returned when attaching the
+// segment identified by key into an ErrPermission describing the ownership of
+// the segment, other errors are returned as is. The ownership is unknown when
+// the process is not even allowed to read the segment's metadata.
+func permissionError(key int, err error) error {
+	if !errors.Is(err, os.ErrPermission) {
+		return err
+	}
+	euid, egid := os.Geteuid(), os.Getegid()
+	// a zero shmflg requests no permission, the id is always returned
+	id, serr := shm.Get(key, 0, 0)
+	if serr == nil {
+		var ds shm.IdDs
+		if _, serr = shm.Ctl(id, shm.IPC_STAT, &ds); serr == nil {
+			return fmt.Errorf("%w: segment %d owned by uid %d gid %d, "+
+				"created by uid %d gid %d, mode %#o, process uid %d gid %d",
+				ErrPermission, key, ds.Perm.Uid, ds.Perm.Gid,
+				ds.Perm.Cuid, ds.Perm.Cgid, ds.Perm.Mode&0777, euid, egid)
+		}
+	}
+
+	return fmt.Errorf("%w: segment %d not accessible by uid %d gid %d: %v",
+		ErrPermission, key, euid, egid, err)
+}
+
+// detach detaches the segment from the process, the segment itself is kept.
+func (s *segment) detach() error {
+	return shm.Dt(s.data)
+}
+
+// remove marks the segment to be destroyed once the last process detaches.
+func (s *segment) remove() error {
+	return shm.Rm(s.id)
+}
+
+// lockMemory locks the pages of b in RAM.
+func lockMemory(b []byte) error {
+	return syscall.Mlock(b)
+}
+
+// removeStaleSegment removes the segment identified by key unless some process
+// is still attached to it, in which case ErrSegmentInUse is returned. A
+// missing segment is not an error.
+func removeStaleSegment(key int) error {
+	id, err := shm.Get(key, 0, 0)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var ds shm.IdDs
+	if _, err := shm.Ctl(id, shm.IPC_STAT, &ds); err != nil {
+		return err
+	}
+	if ds.Nattch > 0 {
+		return fmt.Errorf("%w: segment %d has %d attachments",
+			ErrSegmentInUse, key, ds.Nattch)
+	}
+
+	return shm.Rm(id)
+}
@@ -0,0 +1,94 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package thymef
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermissionError(t *testing.T) {
+	d := newTestClockd(t)
+	err := permissionError(d.shmKey, syscall.EACCES)
+	assert.ErrorIs(t, err, ErrPermission)
+	assert.Contains(t, err.Error(),
+		fmt.Sprintf("owned by uid %d gid %d", os.Geteuid(), os.Getegid()))
+	assert.Contains(t, err.Error(), "mode 0600")
+
+	err = permissionError(d.shmKey, syscall.EINVAL)
+	assert.Equal(t, syscall.EINVAL, err)
+}
+
+func TestReadOnlySegmentFaultsOnWrite(t *testing.T) {
+	d := newTestClockd(t)
+	seg, err := attachSegment(d.shmKey, ClientInfoSharedMemoryBufferSize, true)
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, seg.detach())
+	}()
+	d.data[0] = 42
+	assert.Equal(t, byte(42), seg.data[0])
+
+	old := debug.SetPanicOnFault(true)
+	defer debug.SetPanicOnFault(old)
+	assert.Panics(t, func() {
+		seg.data[0] = 1
+	})
+	assert.Equal(t, byte(42), d.data[0])
+}
+
+// lockedMemory returns the amount of memory locked by the process as reported
+// by /proc/self/status.
+func lockedMemory(t *testing.T) int {
+	data, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		t.Skip("/proc/self/status not available")
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if v, ok := strings.CutPrefix(line, "VmLck:"); ok {
+			kb, err := strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(v, "kB")))
+			require.NoError(t, err)
+			return kb * 1024
+		}
+	}
+	t.Skip("VmLck not reported")
+	return 0
+}
+
+func TestMlock(t *testing.T) {
+	d := newTestClockd(t)
+	d.publish(lockedInfo(1, 1000))
+	before := lockedMemory(t)
+	c := d.newClient(WithMlock())
+	if lockedMemory(t) == before {
+		t.Skip("not permitted to lock memory")
+	}
+	assert.GreaterOrEqual(t, lockedMemory(t)-before, len(d.data))
+	_, err := c.GetUnixTime()
+	assert.NoError(t, err)
+	// detaching the segment releases the lock
+	require.NoError(t, c.Close())
+	assert.Equal(t, before, lockedMemory(t))
+}
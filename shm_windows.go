@@ -0,0 +1,153 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package thymef
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procOpenFileMappingW = kernel32.NewProc("OpenFileMappingW")
+	procVirtualLock      = kernel32.NewProc("VirtualLock")
+)
+
+// segment is a named file mapping backed by the system paging file, it plays
+// the role of the System V shared memory segment used on other platforms.
+type segment struct {
+	handle syscall.Handle
+	addr   uintptr
+	data   []byte
+}
+
+// segmentName returns the name of the file mapping identified by key. clockd
+// is expected to create the mapping in the Global namespace so it is visible
+// across sessions.
+func segmentName(key int) string {
+	return fmt.Sprintf(`Global\thymef.shm.%d`, key)
+}
+
+// openSegment maps the named file mapping identified by key into the process,
+// the mapping is created when it doesn't exist yet.
+func openSegment(key int, size int) (*segment, error) {
+	name, err := syscall.UTF16PtrFromString(segmentName(key))
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFileMapping(syscall.InvalidHandle,
+		nil, syscall.PAGE_READWRITE, 0, uint32(size), name)
+	if err != nil {
+		return nil, permissionError(key, err)
+	}
+
+	return mapSegment(h, size, syscall.FILE_MAP_READ|syscall.FILE_MAP_WRITE)
+}
+
+// attachSegment maps the existing named file mapping identified by key into
+// the process, ErrNoSegment is returned when it doesn't exist. The size of a
+// file mapping can't be queried, a mapping smaller than size is reported as
+// ErrSegmentSizeMismatch. The view is mapped read only when readOnly is set.
+func attachSegment(key int, size int, readOnly bool) (*segment, error) {
+	name, err := syscall.UTF16PtrFromString(segmentName(key))
+	if err != nil {
+		return nil, err
+	}
+	access := uint32(syscall.FILE_MAP_READ | syscall.FILE_MAP_WRITE)
+	if readOnly {
+		access = syscall.FILE_MAP_READ
+	}
+	r, _, err := procOpenFileMappingW.Call(uintptr(access), 0,
+		uintptr(unsafe.Pointer(name)))
+	if r == 0 {
+		if errors.Is(err, syscall.ERROR_FILE_NOT_FOUND) {
+			return nil, fmt.Errorf("%w: no segment with key %d", ErrNoSegment, key)
+		}
+		return nil, permissionError(key, err)
+	}
+	seg, err := mapSegment(syscall.Handle(r), size, access)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSegmentSizeMismatch, err)
+	}
+
+	return seg, nil
+}
+
+// permissionError turns the access denied error returned when opening the
+// file mapping identified by key into an ErrPermission, other errors are
+// returned as is.
+func permissionError(key int, err error) error {
+	if !errors.Is(err, os.ErrPermission) {
+		return err
+	}
+
+	return fmt.Errorf("%w: segment %s not accessible: %v",
+		ErrPermission, segmentName(key), err)
+}
+
+// mapSegment maps size bytes of the file mapping h into the process with the
+// specified access, h is closed when the mapping fails.
+func mapSegment(h syscall.Handle, size int, access uint32) (*segment, error) {
+	addr, err := syscall.MapViewOfFile(h, access, 0, 0, uintptr(size))
+	if err != nil {
+		_ = syscall.CloseHandle(h)
+		return nil, err
+	}
+	// converting via a pointer to addr keeps go vet happy, the view is not
+	// managed by the Go runtime so it never moves
+	p := *(*unsafe.Pointer)(unsafe.Pointer(&addr))
+
+	return &segment{
+		handle: h,
+		addr:   addr,
+		data:   unsafe.Slice((*byte)(p), size),
+	}, nil
+}
+
+// detach unmaps the view and closes the mapping handle.
+func (s *segment) detach() error {
+	err := syscall.UnmapViewOfFile(s.addr)
+	return FirstError(err, syscall.CloseHandle(s.handle))
+}
+
+// remove is a no-op on Windows, the file mapping is destroyed by the system
+// once the last handle to it is closed.
+func (s *segment) remove() error {
+	return nil
+}
+
+// lockMemory locks the pages of b in the working set of the process.
+func lockMemory(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	r, _, err := procVirtualLock.Call(uintptr(unsafe.Pointer(&b[0])),
+		uintptr(len(b)))
+	if r == 0 {
+		return os.NewSyscallError("VirtualLock", err)
+	}
+
+	return nil
+}
+
+// removeStaleSegment is a no-op on Windows, file mappings can't leak as they
+// are destroyed by the system once the last handle to them is closed.
+func removeStaleSegment(key int) error {
+	return nil
+}
@@ -0,0 +1,48 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import "context"
+
+// TimeSource is the interface implemented by backends providing the current
+// bounded time. Client is the shared memory based implementation, see the
+// thymeftest package for a fake one suitable for testing.
+type TimeSource interface {
+	// GetUnixTime returns the UnixTime instance that represents the current
+	// time with reported uncertainty.
+	GetUnixTime() (UnixTime, error)
+}
+
+// BoundedClock is the interface providing the current bounded time together
+// with the interval comparisons built on top of it. Client implements it, so
+// does the fake in the thymeftest package, consumers can depend on it to
+// inject deterministic bounded time in tests.
+type BoundedClock interface {
+	TimeSource
+	// GetUnixTimeContext is similar to GetUnixTime, but gives up and returns
+	// ctx.Err() once the context is done.
+	GetUnixTimeContext(ctx context.Context) (UnixTime, error)
+	// After returns a boolean value indicating whether the current time is
+	// definitely after the specified UnixTime.
+	After(ut UnixTime) (bool, error)
+	// Before returns a boolean value indicating whether the current time is
+	// definitely before the specified UnixTime.
+	Before(ut UnixTime) (bool, error)
+}
+
+var (
+	_ TimeSource   = (*Client)(nil)
+	_ BoundedClock = (*Client)(nil)
+)
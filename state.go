@@ -0,0 +1,68 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import "errors"
+
+// State is the state of the bounded time service as observed by the Client.
+type State int
+
+const (
+	// StateUnknown is the state before the first reading.
+	StateUnknown State = iota
+	// StateReady indicates that clockd is serving valid readings.
+	StateReady
+	// StateNotReady indicates that no valid reading is available, e.g. clockd
+	// is not locked yet or its record is corrupted.
+	StateNotReady
+	// StateStopped indicates that clockd stopped updating its record.
+	StateStopped
+	// StateDegraded indicates that readings are still valid but derived from a
+	// clockd reference older than the configured degraded threshold, or wider
+	// than the configured max usable dispersion.
+	StateDegraded
+)
+
+// String returns the name of the state.
+func (s State) String() string {
+	switch s {
+	case StateUnknown:
+		return "unknown"
+	case StateReady:
+		return "ready"
+	case StateNotReady:
+		return "not-ready"
+	case StateStopped:
+		return "stopped"
+	case StateDegraded:
+		return "degraded"
+	default:
+		return "invalid"
+	}
+}
+
+// stateOf returns the State corresponding to the outcome of a read.
+func stateOf(err error) State {
+	switch {
+	case err == nil:
+		return StateReady
+	case errors.Is(err, ErrDegraded), errors.Is(err, ErrDispersionTooLarge):
+		return StateDegraded
+	case errors.Is(err, ErrStopped):
+		return StateStopped
+	default:
+		return StateNotReady
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateOf(t *testing.T) {
+	tests := []struct {
+		err   error
+		state State
+	}{
+		{nil, StateReady},
+		{ErrDegraded, StateDegraded},
+		{ErrStopped, StateStopped},
+		{ErrNotReady, StateNotReady},
+		{ErrNotLocked, StateNotReady},
+		{ErrUninitializedSegment, StateNotReady},
+		{ErrCorruptData, StateNotReady},
+		{errors.New("other"), StateNotReady},
+	}
+
+	for idx, tt := range tests {
+		assert.Equal(t, tt.state, stateOf(tt.err), idx)
+	}
+}
+
+func TestStateChangeCallback(t *testing.T) {
+	type transition struct {
+		old State
+		new State
+	}
+	var transitions []transition
+	d := newTestClockd(t)
+	c := d.newClient(WithDegradedThreshold(time.Second),
+		WithStateChangeCallback(func(old State, new State) {
+			transitions = append(transitions, transition{old, new})
+		}))
+
+	read := func() {
+		_, _ = c.GetUnixTime()
+	}
+	// segment never written
+	read()
+	read()
+	d.publish(lockedInfo(1, 1000))
+	read()
+	read()
+	// stale record
+	c.last.mono -= int64(time.Second)
+	read()
+	d.publish(lockedInfo(2, 1000))
+	read()
+	info := lockedInfo(3, 1000)
+	info.Sec -= 2
+	d.publish(info)
+	read()
+	info = lockedInfo(4, 1000)
+	info.Locked = false
+	d.publish(info)
+	read()
+	read()
+
+	expected := []transition{
+		{StateUnknown, StateNotReady},
+		{StateNotReady, StateReady},
+		{StateReady, StateStopped},
+		{StateStopped, StateReady},
+		{StateReady, StateDegraded},
+		{StateDegraded, StateNotReady},
+	}
+	assert.Equal(t, expected, transitions)
+}
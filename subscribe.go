@@ -0,0 +1,81 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"context"
+	"time"
+)
+
+// Reading is a UnixTime delivered by Subscribe together with the error
+// returned when reading it, with the same semantics as the values returned by
+// GetUnixTime.
+type Reading struct {
+	Time UnixTime
+	Err  error
+}
+
+// Subscribe starts a goroutine reading the current time every interval, the
+// first reading is taken immediately. Readings are delivered with latest wins
+// semantics, the returned channel buffers a single Reading and a Reading not
+// yet received by the time the next one is ready is dropped in favor of the
+// newer one, so a slow consumer never blocks the producer and always receives
+// the most recent reading. The goroutine stops and closes the channel once the
+// context is done. The client must be safe for concurrent use, i.e. created
+// by NewSyncClient, when it is used elsewhere while subscribed.
+func (c *Client) Subscribe(ctx context.Context,
+	interval time.Duration) <-chan Reading {
+	if interval <= 0 {
+		panic("invalid subscribe interval")
+	}
+	ch := make(chan Reading, 1)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			ut, err := c.GetUnixTimeContext(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			publishLatest(ch, Reading{Time: ut, Err: err})
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return ch
+}
+
+// publishLatest sends r to ch without blocking, the Reading pending in ch is
+// dropped to make room for r. ch must have a single sender.
+func publishLatest(ch chan Reading, r Reading) {
+	select {
+	case ch <- r:
+		return
+	default:
+	}
+	select {
+	case <-ch:
+	default:
+	}
+	select {
+	case ch <- r:
+	default:
+	}
+}
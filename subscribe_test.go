@@ -0,0 +1,74 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymef
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribe(t *testing.T) {
+	d := newTestClockd(t)
+	c := d.newClient()
+	d.publish(lockedInfo(1, 1000))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := c.Subscribe(ctx, time.Millisecond)
+
+	var prev UnixTime
+	for i := 0; i < 3; i++ {
+		r := <-ch
+		require.NoError(t, r.Err)
+		assert.Greater(t, r.Time.Sub(prev), int64(0))
+		prev = r.Time
+	}
+
+	// a slow consumer doesn't block the producer and gets the latest reading
+	time.Sleep(20 * time.Millisecond)
+	d.publish(ClientInfo{})
+	time.Sleep(20 * time.Millisecond)
+	r := <-ch
+	assert.ErrorIs(t, r.Err, ErrNotReady)
+
+	cancel()
+	timer := time.NewTimer(5 * time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timer.C:
+			t.Fatal("channel not closed after cancellation")
+		}
+	}
+}
+
+func TestPublishLatest(t *testing.T) {
+	ch := make(chan Reading, 1)
+	for i := uint64(1); i <= 3; i++ {
+		publishLatest(ch, Reading{Time: UnixTime{Sec: i}})
+	}
+	assert.Equal(t, uint64(3), (<-ch).Time.Sec)
+	assert.Empty(t, ch)
+	assert.Panics(t, func() {
+		(&Client{}).Subscribe(context.Background(), 0)
+	})
+}
@@ -0,0 +1,173 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymeftest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lni/thymef"
+)
+
+const (
+	conformanceReads = 100
+	// conformanceTimeout is how long a backend is given to report a new
+	// state of clockd, e.g. a CachedClient only notices it on its next
+	// refresh.
+	conformanceTimeout = 5 * time.Second
+)
+
+// Advancer is implemented by TimeSource backends whose time can be moved
+// forward while clockd is stalled, e.g. FakeClient. RunConformance uses it to
+// check that the dispersion is inflated over time.
+type Advancer interface {
+	Advance(d time.Duration)
+}
+
+// Clockd controls the clockd instance read by a backend. RunConformance uses
+// it to check that each state of clockd is reported as the matching error.
+type Clockd interface {
+	// NotReady publishes a record that is not locked to the reference.
+	NotReady()
+	// Stall stops clockd from updating its record for longer than the
+	// backend's stale threshold.
+	Stall()
+	// VersionMismatch publishes a record in an unsupported version.
+	VersionMismatch()
+}
+
+// Backend is a thymef.TimeSource under test together with the clockd it reads
+// from, which is expected to be ready, i.e. publishing valid and locked
+// records.
+type Backend struct {
+	Source thymef.TimeSource
+	// Clockd controls the clockd read by Source, the checks of the states of
+	// clockd are skipped when it is nil.
+	Clockd Clockd
+}
+
+// RunConformance checks the invariants every thymef.TimeSource backend must
+// honor. The factory is called to get a fresh backend for each check.
+func RunConformance(t *testing.T, factory func() Backend) {
+	t.Helper()
+	t.Run("BoundsOrdered", func(t *testing.T) {
+		ts := factory().Source
+		for i := 0; i < conformanceReads; i++ {
+			ut := mustRead(t, ts)
+			lower, upper := ut.Bounds()
+			if lower > upper {
+				t.Fatalf("lower bound %d above upper bound %d", lower, upper)
+			}
+		}
+	})
+	t.Run("NeverGoesBackwards", func(t *testing.T) {
+		ts := factory().Source
+		var prev thymef.UnixTime
+		for i := 0; i < conformanceReads; i++ {
+			ut := mustRead(t, ts)
+			if !prev.IsEmpty() {
+				prevLower, _ := prev.Bounds()
+				_, upper := ut.Bounds()
+				if upper < prevLower {
+					t.Fatalf("reading %+v is entirely before %+v", ut, prev)
+				}
+			}
+			prev = ut
+		}
+	})
+	t.Run("DispersionInflatesWhenStalled", func(t *testing.T) {
+		ts := factory().Source
+		advancer, ok := ts.(Advancer)
+		if !ok {
+			t.Skip("backend can not be stalled")
+		}
+		prev, err := ts.GetUnixTime()
+		if err != nil {
+			t.Fatalf("failed to get the first reading %v", err)
+		}
+		for _, d := range []time.Duration{time.Millisecond, time.Second, time.Hour} {
+			advancer.Advance(d)
+			ut, err := ts.GetUnixTime()
+			if err != nil {
+				t.Fatalf("failed to get reading %v", err)
+			}
+			if ut.Dispersion <= prev.Dispersion {
+				t.Fatalf("dispersion not inflated after %s, %d <= %d",
+					d, ut.Dispersion, prev.Dispersion)
+			}
+			if ut.Sub(prev) != int64(d) {
+				t.Fatalf("time advanced by %d, want %d", ut.Sub(prev), d)
+			}
+			prev = ut
+		}
+	})
+	states := []struct {
+		name  string
+		enter func(Clockd)
+		want  error
+	}{
+		{"NotReady", Clockd.NotReady, thymef.ErrNotReady},
+		{"Stalled", Clockd.Stall, thymef.ErrStopped},
+		{"VersionMismatch", Clockd.VersionMismatch, thymef.ErrVersionMismatch},
+	}
+	for _, state := range states {
+		t.Run(state.name, func(t *testing.T) {
+			b := factory()
+			if b.Clockd == nil {
+				t.Skip("clockd of the backend can not be controlled")
+			}
+			mustRead(t, b.Source)
+			state.enter(b.Clockd)
+			err := waitError(b.Source)
+			if !errors.Is(err, state.want) {
+				t.Fatalf("got error %v, want %v", err, state.want)
+			}
+			for _, other := range states {
+				if other.want != state.want && errors.Is(err, other.want) {
+					t.Fatalf("error %v is also %v", err, other.want)
+				}
+			}
+		})
+	}
+}
+
+// mustRead returns a reading of ts, it fails the test when ts returns no
+// usable reading. Degraded readings are usable.
+func mustRead(t *testing.T, ts thymef.TimeSource) thymef.UnixTime {
+	t.Helper()
+	ut, err := ts.GetUnixTime()
+	if err != nil && (!errors.Is(err, thymef.ErrDegraded) || ut.IsEmpty()) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	return ut
+}
+
+// waitError returns the first error other than ErrDegraded returned by ts
+// within conformanceTimeout, or nil when there is none.
+func waitError(ts thymef.TimeSource) error {
+	deadline := time.Now().Add(conformanceTimeout)
+	for {
+		_, err := ts.GetUnixTime()
+		if err != nil && !errors.Is(err, thymef.ErrDegraded) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
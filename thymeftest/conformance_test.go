@@ -0,0 +1,270 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thymeftest
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lni/thymef"
+)
+
+func newTestFakeClient() *FakeClient {
+	return NewFakeClient(thymef.UnixTime{
+		Sec:        1714564800,
+		NSec:       999999000,
+		Dispersion: 1000,
+	})
+}
+
+// fakeClockd controls a FakeClient the way clockd states are reported.
+type fakeClockd struct {
+	f *FakeClient
+}
+
+func (d fakeClockd) NotReady() {
+	d.f.SetError(thymef.ErrNotLocked)
+}
+
+func (d fakeClockd) Stall() {
+	d.f.SetError(thymef.ErrStopped)
+}
+
+func (d fakeClockd) VersionMismatch() {
+	d.f.SetError(thymef.ErrVersionMismatch)
+}
+
+// testStaleThreshold is the stale threshold of the clients reading from
+// regionClockd, it is never reached by the real time elapsed in tests.
+const testStaleThreshold = time.Hour
+
+// regionClockd publishes records into an in-memory region the way clockd
+// does. It is also the clock of the clients reading from it, so stalling it
+// is a matter of moving the clock past the stale threshold.
+type regionClockd struct {
+	mu     sync.Mutex
+	data   []byte
+	count  uint16
+	offset atomic.Int64
+}
+
+var _ Clockd = (*regionClockd)(nil)
+var _ thymef.MonotonicClock = (*regionClockd)(nil)
+
+func newRegionClockd() *regionClockd {
+	d := &regionClockd{
+		data: make([]byte, thymef.ClientInfoSharedMemoryBufferSize),
+	}
+	d.publish(true, nil)
+
+	return d
+}
+
+func (d *regionClockd) Bytes() []byte {
+	return d.data
+}
+
+func (d *regionClockd) Now() (uint64, uint32) {
+	now := time.Now().Add(time.Duration(d.offset.Load()))
+	return uint64(now.Unix()), uint32(now.Nanosecond())
+}
+
+func (d *regionClockd) Monotonic() time.Duration {
+	return thymef.SystemClock{}.Monotonic() + time.Duration(d.offset.Load())
+}
+
+// publish publishes a new record using the current time as its reference,
+// tamper is applied to the region after the record is written.
+func (d *regionClockd) publish(locked bool, tamper func(data []byte)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.count++
+	sec, nsec := d.Now()
+	if err := thymef.WriteRecord(d.data, thymef.ClientInfo{
+		Valid:      true,
+		Locked:     locked,
+		Count:      d.count,
+		Dispersion: 1000,
+		Sec:        sec,
+		NSec:       nsec,
+	}); err != nil {
+		panic(err)
+	}
+	if tamper != nil {
+		tamper(d.data)
+	}
+}
+
+func (d *regionClockd) NotReady() {
+	d.publish(false, nil)
+}
+
+func (d *regionClockd) Stall() {
+	d.offset.Add(int64(2 * testStaleThreshold))
+}
+
+func (d *regionClockd) VersionMismatch() {
+	d.publish(true, func(data []byte) {
+		// the version byte follows the datalen prefix and the magic number
+		data[4]++
+	})
+}
+
+func (d *regionClockd) newClient(t *testing.T) *thymef.Client {
+	c, err := thymef.NewClientWithOptions(thymef.WithShmRegion(d, &d.mu),
+		thymef.WithClock(d), thymef.WithStaleThreshold(testStaleThreshold))
+	require.NoError(t, err)
+
+	return c
+}
+
+// multiClockd controls all clockd instances read by a MultiClient at once.
+type multiClockd []*regionClockd
+
+func (m multiClockd) NotReady() {
+	for _, d := range m {
+		d.NotReady()
+	}
+}
+
+func (m multiClockd) Stall() {
+	for _, d := range m {
+		d.Stall()
+	}
+}
+
+func (m multiClockd) VersionMismatch() {
+	for _, d := range m {
+		d.VersionMismatch()
+	}
+}
+
+func TestFakeClientConformance(t *testing.T) {
+	RunConformance(t, func() Backend {
+		f := newTestFakeClient()
+		return Backend{Source: f, Clockd: fakeClockd{f}}
+	})
+}
+
+func TestClientConformance(t *testing.T) {
+	RunConformance(t, func() Backend {
+		d := newRegionClockd()
+		c := d.newClient(t)
+		t.Cleanup(func() {
+			assert.NoError(t, c.Close())
+		})
+		return Backend{Source: c, Clockd: d}
+	})
+}
+
+func TestCachedClientConformance(t *testing.T) {
+	RunConformance(t, func() Backend {
+		d := newRegionClockd()
+		c := thymef.NewCachedClient(d.newClient(t), time.Millisecond)
+		t.Cleanup(func() {
+			assert.NoError(t, c.Close())
+		})
+		return Backend{Source: c, Clockd: d}
+	})
+}
+
+func TestMultiClientConformance(t *testing.T) {
+	RunConformance(t, func() Backend {
+		d := multiClockd{newRegionClockd(), newRegionClockd(), newRegionClockd()}
+		m := thymef.NewMultiClient(2,
+			d[0].newClient(t), d[1].newClient(t), d[2].newClient(t))
+		t.Cleanup(func() {
+			assert.NoError(t, m.Close())
+		})
+		return Backend{Source: m, Clockd: d}
+	})
+}
+
+func TestPLLSourceConformance(t *testing.T) {
+	RunConformance(t, func() Backend {
+		d := newRegionClockd()
+		c := d.newClient(t)
+		t.Cleanup(func() {
+			assert.NoError(t, c.Close())
+		})
+		return Backend{Source: thymef.NewPLLSource(c), Clockd: d}
+	})
+}
+
+func TestFakeClientAdvance(t *testing.T) {
+	f := newTestFakeClient()
+	f.Advance(2 * time.Microsecond)
+	ut, err := f.GetUnixTime()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1714564801), ut.Sec)
+	assert.Equal(t, uint32(1000), ut.NSec)
+	assert.Equal(t, uint64(1002), ut.Dispersion)
+}
+
+func TestFakeClientError(t *testing.T) {
+	f := newTestFakeClient()
+	f.SetError(thymef.ErrStopped)
+	_, err := f.GetUnixTime()
+	assert.Equal(t, thymef.ErrStopped, err)
+	f.SetError(nil)
+	_, err = f.GetUnixTime()
+	assert.NoError(t, err)
+}
+
+func TestFakeClientAfterAndBefore(t *testing.T) {
+	f := newTestFakeClient()
+	earlier := thymef.UnixTime{Sec: 1714564800, NSec: 999997999}
+	later := thymef.UnixTime{Sec: 1714564801, NSec: 1}
+	ok, err := f.After(earlier)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = f.Before(later)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// the intervals overlap once the dispersion grows
+	f.GrowDispersion(time.Microsecond)
+	ok, err = f.After(earlier)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = f.Before(later)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	f.Set(thymef.UnixTime{Sec: 1714564802})
+	ok, err = f.After(later)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	f.SetError(thymef.ErrStopped)
+	_, err = f.After(later)
+	assert.ErrorIs(t, err, thymef.ErrStopped)
+}
+
+func TestFakeClientContext(t *testing.T) {
+	f := newTestFakeClient()
+	_, err := f.GetUnixTimeContext(context.Background())
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = f.GetUnixTimeContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
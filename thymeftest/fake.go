@@ -0,0 +1,128 @@
+// Copyright 2023-2024 Lei Ni (nilei81@gmail.com) and other contributors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package thymeftest provides utilities for testing code built on top of
+// thymef without a running clockd.
+package thymeftest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lni/thymef"
+)
+
+// FakeClient is an in-process thymef.BoundedClock with fully controlled time.
+// It keeps returning the seeded reading until Advance is called, which moves
+// the time forward as if clockd published no update in between, so the
+// dispersion grows the same way it does on a real client. FakeClient is
+// thread safe.
+type FakeClient struct {
+	mu  sync.Mutex
+	now thymef.UnixTime
+	err error
+}
+
+var _ thymef.BoundedClock = (*FakeClient)(nil)
+
+// NewFakeClient creates a new FakeClient instance seeded with the specified
+// reading.
+func NewFakeClient(seed thymef.UnixTime) *FakeClient {
+	return &FakeClient{now: seed}
+}
+
+// GetUnixTime returns the current reading of the fake, or the error set by
+// SetError.
+func (f *FakeClient) GetUnixTime() (thymef.UnixTime, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return thymef.UnixTime{}, f.err
+	}
+
+	return f.now, nil
+}
+
+// GetUnixTimeContext is similar to GetUnixTime, but it returns ctx.Err() when
+// the context is already done.
+func (f *FakeClient) GetUnixTimeContext(ctx context.Context) (thymef.UnixTime, error) {
+	if err := ctx.Err(); err != nil {
+		return thymef.UnixTime{}, err
+	}
+
+	return f.GetUnixTime()
+}
+
+// After returns a boolean value indicating whether the current reading is
+// definitely after ut as defined by thymef.UnixTime.DefinitelyAfter.
+func (f *FakeClient) After(ut thymef.UnixTime) (bool, error) {
+	now, err := f.GetUnixTime()
+	if err != nil {
+		return false, err
+	}
+
+	return now.DefinitelyAfter(ut), nil
+}
+
+// Before returns a boolean value indicating whether the current reading is
+// definitely before ut as defined by thymef.UnixTime.DefinitelyBefore.
+func (f *FakeClient) Before(ut thymef.UnixTime) (bool, error) {
+	now, err := f.GetUnixTime()
+	if err != nil {
+		return false, err
+	}
+
+	return now.DefinitelyBefore(ut), nil
+}
+
+// Set replaces the current reading.
+func (f *FakeClient) Set(now thymef.UnixTime) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = now
+}
+
+// GrowDispersion inflates the dispersion of the current reading by d without
+// moving the time, e.g. to simulate a clockd losing its reference.
+func (f *FakeClient) GrowDispersion(d time.Duration) {
+	if d < 0 {
+		panic("negative duration")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now.Dispersion += uint64(d)
+}
+
+// Advance moves the time forward by d without any clockd update, the
+// dispersion is inflated by the clock uncertainty accumulated over d.
+func (f *FakeClient) Advance(d time.Duration) {
+	if d < 0 {
+		panic("negative duration")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ns := uint64(f.now.NSec) + uint64(d)
+	f.now.Sec += ns / 1e9
+	f.now.NSec = uint32(ns % 1e9)
+	f.now.Dispersion += thymef.GetClockUncertainty(int64(d))
+}
+
+// SetError makes all following GetUnixTime calls fail with the specified
+// error, e.g. thymef.ErrStopped, until it is cleared by SetError(nil).
+func (f *FakeClient) SetError(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
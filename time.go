@@ -14,7 +14,16 @@
 
 package thymef
 
-import "time"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
 
 const (
 	// MaxClockDrift is the absolute value of the max clock drift in ppb. 1e3ppm
@@ -30,6 +39,11 @@ const (
 	// maximum adjustment, meaning anything higher than that reflects a hardware
 	// fault.
 	MaxClockDrift int64 = 1000000
+	// maxTimeSec is the max Unix time in seconds that can be represented by
+	// time.Time, which internally counts seconds since year 1.
+	maxTimeSec = math.MaxInt64 - 62135596800
+	// timeFormat is RFC3339 with fixed nanosecond precision.
+	timeFormat = "2006-01-02T15:04:05.000000000Z07:00"
 )
 
 // UnixTime is the native time provided by clockd. It is used to represent
@@ -51,40 +65,418 @@ func (t *UnixTime) IsEmpty() bool {
 }
 
 // Bounds returns the lower and upper limit of the time represented by the
-// UnixTime instance.
+// UnixTime instance. The lower limit is clamped at 0, i.e. the Unix epoch,
+// when the Dispersion exceeds the time itself, the upper limit is saturated
+// rather than wrapped around as well.
 func (t *UnixTime) Bounds() (uint64, uint64) {
 	un := t.Sec*1e9 + uint64(t.NSec)
-	return un - t.Dispersion, un + t.Dispersion
+	lower := uint64(0)
+	if un > t.Dispersion {
+		lower = un - t.Dispersion
+	}
+	upper := uint64(math.MaxUint64)
+	if un <= math.MaxUint64-t.Dispersion {
+		upper = un + t.Dispersion
+	}
+
+	return lower, upper
 }
 
-// Sub returns the time difference of (t - other) in nanoseconds.
+// Sub returns the time difference of (t - other) in nanoseconds. The seconds
+// and nanoseconds are subtracted separately in signed integers, so no borrow
+// is required and t being earlier than other, including when t.Sec is 0,
+// simply yields a negative difference.
 func (t *UnixTime) Sub(other UnixTime) int64 {
-	v := *t
-	if other.NSec > v.NSec {
-		v.NSec += 1e9
-		v.Sec -= 1
+	sd := int64(t.Sec) - int64(other.Sec)
+	nsd := int64(t.NSec) - int64(other.NSec)
+	return sd*1e9 + nsd
+}
+
+// DefinitelyBefore returns a boolean value indicating whether t is definitely
+// before o, i.e. the upper bound of t is strictly less than the lower bound of
+// o so the intervals returned by Bounds are disjoint.
+func (t UnixTime) DefinitelyBefore(o UnixTime) bool {
+	_, tu := t.Bounds()
+	ol, _ := o.Bounds()
+	return tu < ol
+}
+
+// DefinitelyAfter returns a boolean value indicating whether t is definitely
+// after o, i.e. the lower bound of t is strictly greater than the upper bound
+// of o so the intervals returned by Bounds are disjoint.
+func (t UnixTime) DefinitelyAfter(o UnixTime) bool {
+	return o.DefinitelyBefore(t)
+}
+
+// Indeterminate returns a boolean value indicating whether the order of t and
+// o can't be determined, i.e. the intervals returned by Bounds overlap,
+// including when they merely touch. Exactly one of DefinitelyBefore,
+// DefinitelyAfter and Indeterminate is true for any t and o.
+func (t UnixTime) Indeterminate(o UnixTime) bool {
+	return Overlap(t, o)
+}
+
+// CommitTimestamp returns the upper bound of t in nanoseconds since the Unix
+// epoch, i.e. the latest instant t could represent, as the timestamp to assign
+// to an event such as a transaction commit in a causally consistent log.
+//
+// Assigning the upper bound and then commit waiting, i.e. not making the event
+// visible before Client.WaitUntilPast(ctx, t) returns, yields external
+// consistency: once the wait returns the actual time is past the timestamp,
+// so any event starting afterwards reads a lower bound and thus a commit
+// timestamp strictly greater than it. Commit timestamps of events that
+// overlap in time carry no ordering guarantee.
+func (t UnixTime) CommitTimestamp() uint64 {
+	_, upper := t.Bounds()
+	return upper
+}
+
+// Add returns the UnixTime advanced by d with the same Dispersion, d can be
+// negative. The result is saturated to the Unix epoch when d would move it
+// before the epoch, and to the max representable time on overflow.
+func (t UnixTime) Add(d time.Duration) UnixTime {
+	if d >= 0 {
+		sec := uint64(d) / 1e9
+		nsec := uint64(t.NSec) + uint64(d)%1e9
+		if nsec >= 1e9 {
+			nsec -= 1e9
+			sec++
+		}
+		if t.Sec > math.MaxUint64-sec {
+			return UnixTime{
+				Sec:        math.MaxUint64,
+				NSec:       1e9 - 1,
+				Dispersion: t.Dispersion,
+			}
+		}
+		return UnixTime{
+			Sec:        t.Sec + sec,
+			NSec:       uint32(nsec),
+			Dispersion: t.Dispersion,
+		}
+	}
+	// -d overflows for math.MinInt64
+	back := uint64(-(d + 1)) + 1
+	sec := back / 1e9
+	nsec := int64(t.NSec) - int64(back%1e9)
+	if nsec < 0 {
+		nsec += 1e9
+		sec++
+	}
+	if t.Sec < sec {
+		return UnixTime{Dispersion: t.Dispersion}
+	}
+
+	return UnixTime{
+		Sec:        t.Sec - sec,
+		NSec:       uint32(nsec),
+		Dispersion: t.Dispersion,
+	}
+}
+
+// AddDispersion returns the UnixTime with its Dispersion widened by extra,
+// e.g. to account for the uncertainty of a scheduling delay. The Dispersion is
+// saturated on overflow.
+func (t UnixTime) AddDispersion(extra uint64) UnixTime {
+	t.Dispersion = addSaturated(t.Dispersion, extra)
+	return t
+}
+
+// LogFields returns the earliest, latest and midpoint of the time represented
+// by the UnixTime instance together with its dispersion as structured log
+// attributes, e.g. logger.LogAttrs(ctx, slog.LevelInfo, "now", ut.LogFields()...).
+func (t UnixTime) LogFields() []slog.Attr {
+	lower, upper := t.BoundsTime()
+	return []slog.Attr{
+		slog.Time("earliest", lower.UTC()),
+		slog.Time("latest", upper.UTC()),
+		slog.Time("midpoint", t.ToTime().UTC()),
+		slog.Duration("dispersion", time.Duration(t.Dispersion)),
+	}
+}
+
+// ToTime returns the time.Time representing the midpoint of the UnixTime
+// instance, the dispersion is dropped. Sec values beyond what time.Time can
+// represent are saturated.
+func (t UnixTime) ToTime() time.Time {
+	if t.Sec > maxTimeSec {
+		return time.Unix(maxTimeSec, int64(t.NSec))
+	}
+
+	return time.Unix(int64(t.Sec), int64(t.NSec))
+}
+
+// BoundsTime returns the lower and upper limit of the time represented by the
+// UnixTime instance as time.Time values.
+func (t UnixTime) BoundsTime() (time.Time, time.Time) {
+	lower, upper := t.Bounds()
+	return nsToTime(lower), nsToTime(upper)
+}
+
+// Interval is the same as BoundsTime, it returns the lower and upper limit of
+// the time represented by the UnixTime instance as time.Time values.
+func (t UnixTime) Interval() (time.Time, time.Time) {
+	return t.BoundsTime()
+}
+
+// Width returns the total width of the uncertainty window, i.e. twice the
+// Dispersion, saturated at the max time.Duration.
+func (t UnixTime) Width() time.Duration {
+	if t.Dispersion > math.MaxInt64/2 {
+		return math.MaxInt64
+	}
+
+	return time.Duration(2 * t.Dispersion)
+}
+
+// DispersionDuration returns the Dispersion as a time.Duration, saturated at
+// the max time.Duration for dispersions beyond about 292 years.
+func (t UnixTime) DispersionDuration() time.Duration {
+	return time.Duration(min(t.Dispersion, math.MaxInt64))
+}
+
+// DispersionString returns the Dispersion formatted in the unit that keeps it
+// readable, i.e. in ns below 1µs, in µs below 1ms and in ms otherwise, with at
+// most 3 decimal places, e.g. 850ns, 1.5µs or 12.346ms. It is intended for
+// dashboards and logs.
+func (t UnixTime) DispersionString() string {
+	return formatDispersion(t.Dispersion)
+}
+
+func formatDispersion(d uint64) string {
+	switch {
+	case d < 1e3:
+		return strconv.FormatUint(d, 10) + "ns"
+	case d < 1e6:
+		return formatDecimal(float64(d)/1e3) + "µs"
+	default:
+		return formatDecimal(float64(d)/1e6) + "ms"
+	}
+}
+
+// formatDecimal formats v with at most 3 decimal places and no trailing zero.
+func formatDecimal(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 3, 64)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// String returns the midpoint in UTC with nanosecond precision followed by
+// the dispersion formatted as DispersionString does, e.g.
+// 2024-05-01T12:00:00.123456789Z ±8ns.
+func (t UnixTime) String() string {
+	return t.ToTime().UTC().Format(timeFormat) + " ±" + t.DispersionString()
+}
+
+type unixTimeJSON struct {
+	Sec        *uint64   `json:"sec"`
+	NSec       *uint32   `json:"nsec"`
+	Dispersion *uint64   `json:"dispersion"`
+	Time       string    `json:"time,omitempty"`
+	Bounds     [2]uint64 `json:"bounds"`
+}
+
+// MarshalJSON implements the json.Marshaler interface. Other than the sec,
+// nsec and dispersion fields, the derived RFC3339 time of the midpoint and
+// the [lower, upper] bounds in nanoseconds are included for readability.
+func (t UnixTime) MarshalJSON() ([]byte, error) {
+	lower, upper := t.Bounds()
+	return json.Marshal(unixTimeJSON{
+		Sec:        &t.Sec,
+		NSec:       &t.NSec,
+		Dispersion: &t.Dispersion,
+		Time:       t.ToTime().UTC().Format(timeFormat),
+		Bounds:     [2]uint64{lower, upper},
+	})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. The UnixTime is
+// reconstructed from the sec, nsec and dispersion fields, which are all
+// required, derived fields are ignored.
+func (t *UnixTime) UnmarshalJSON(data []byte) error {
+	var v unixTimeJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Sec == nil || v.NSec == nil || v.Dispersion == nil {
+		return errors.New("sec, nsec or dispersion missing")
+	}
+	if *v.NSec >= 1e9 {
+		return errors.New("nsec out of range")
+	}
+	*t = UnixTime{
+		Sec:        *v.Sec,
+		NSec:       *v.NSec,
+		Dispersion: *v.Dispersion,
+	}
+
+	return nil
+}
+
+// FromTime returns the UnixTime representing the specified time.Time with
+// zero Dispersion. Times before the Unix epoch are saturated to the epoch as
+// they can't be represented by UnixTime.
+func FromTime(t time.Time) UnixTime {
+	if t.Unix() < 0 {
+		return UnixTime{}
+	}
+
+	return UnixTime{
+		Sec:  uint64(t.Unix()),
+		NSec: uint32(t.Nanosecond()),
+	}
+}
+
+// ErrNegativeElapsed indicates that a negative elapsed time was passed to
+// CombineReadings.
+var ErrNegativeElapsed = errors.New("negative elapsed time")
+
+// CombineReadings combines two readings derived from the same clockd record,
+// i.e. taken with no clockd update in between as confirmed by their Count,
+// into a reading tighter than both. first is shifted forward by the monotonic
+// time elapsed between the two readings, with its dispersion inflated by the
+// uncertainty accumulated over the elapsed time according to model, before
+// being intersected with second. The returned boolean value is false when the
+// two intervals don't overlap, meaning the readings are inconsistent.
+// ErrNegativeElapsed is returned when elapsed is negative.
+func CombineReadings(first UnixTime, second UnixTime,
+	elapsed time.Duration, model DriftModel) (UnixTime, bool, error) {
+	if elapsed < 0 {
+		return UnixTime{}, false, fmt.Errorf("%w: %s", ErrNegativeElapsed, elapsed)
+	}
+	lower, upper := first.Bounds()
+	shift := uint64(elapsed)
+	uct := model.Uncertainty(int64(elapsed))
+	// clamped at the Unix epoch as Bounds does, saturated otherwise
+	lower = addSaturated(lower, shift)
+	if lower > uct {
+		lower -= uct
+	} else {
+		lower = 0
+	}
+	upper = addSaturated(addSaturated(upper, shift), uct)
+	sl, su := second.Bounds()
+	ut, ok := intersect(lower, upper, sl, su)
+
+	return ut, ok, nil
+}
+
+// Overlap returns a boolean value indicating whether the intervals represented
+// by a and b share at least one instant, both bounds inclusive. Two readings of
+// the actual time that don't overlap can't both be correct.
+func Overlap(a UnixTime, b UnixTime) bool {
+	al, au := a.Bounds()
+	bl, bu := b.Bounds()
+	return al <= bu && bl <= au
+}
+
+// Intersect returns the UnixTime representing the intersection of the
+// intervals represented by a and b, its midpoint is the center of the
+// intersection and its Dispersion is half of the intersection width rounded
+// up, so the intersection is never narrowed. The returned boolean value is
+// false when a and b don't overlap.
+func Intersect(a UnixTime, b UnixTime) (UnixTime, bool) {
+	al, au := a.Bounds()
+	bl, bu := b.Bounds()
+	return intersect(al, au, bl, bu)
+}
+
+func nsToTime(ns uint64) time.Time {
+	return time.Unix(int64(ns/1e9), int64(ns%1e9))
+}
+
+// intersect returns the UnixTime representing the intersection of the two
+// specified intervals in nanoseconds.
+func intersect(l1 uint64, u1 uint64, l2 uint64, u2 uint64) (UnixTime, bool) {
+	lower := max(l1, l2)
+	upper := min(u1, u2)
+	if lower > upper {
+		return UnixTime{}, false
 	}
 
-	sd := (int64(v.Sec) - int64(other.Sec)) * int64(1e9)
-	nsd := int64(v.NSec) - int64(other.NSec)
-	return sd + nsd
+	return fromBounds(lower, upper), true
+}
+
+// fromBounds returns the UnixTime covering the [lower, upper] interval in
+// nanoseconds. The dispersion is rounded up so the interval is never narrowed.
+func fromBounds(lower uint64, upper uint64) UnixTime {
+	width := upper - lower
+	mid := lower + width/2
+	return UnixTime{
+		Sec:        mid / 1e9,
+		NSec:       uint32(mid % 1e9),
+		Dispersion: width - width/2,
+	}
 }
 
 // GetClockUncertainty returns the dispersion introduced by the clock itself
 // when we can not confirm whether it is broken or not. When there is a
 // nanosecond worth of uncertain period, we multiply it with the MaxClockDrift
-// to get the dispersion.
+// to get the dispersion. It is the Uncertainty of the default DriftModel.
+// It panics when nanosecond is negative, the read path never passes negative
+// values as a local clock behind clockd's reference is reported as
+// ErrClockInversion.
 func GetClockUncertainty(nanosecond int64) uint64 {
+	return getClockUncertainty(nanosecond, MaxClockDrift)
+}
+
+// getClockUncertainty is similar to GetClockUncertainty, the max clock drift
+// in ppb is specified by drift.
+func getClockUncertainty(nanosecond int64, drift int64) uint64 {
 	if nanosecond < 0 {
 		panic("invalid value")
 	}
-	// 1s leads to 1ms uncertainty, that is Dispersion(1e12)
-	fv := float64(nanosecond*MaxClockDrift) / float64(1e9)
+	// 1s leads to 1ms uncertainty for the default MaxClockDrift. nanosecond *
+	// drift overflows int64 after about 2.5 hours for the default drift, whole
+	// seconds and the remaining nanoseconds are thus scaled separately, both
+	// products fit in int64 for drifts up to the 1e7ppb ceiling.
+	sec := nanosecond / 1e9
+	ns := nanosecond % 1e9
+
+	return uint64(sec*drift + ns*drift/1e9)
+}
+
+// DispersionBreakdown is the split of the dispersion of a reading into its
+// components, see Client.GetUnixTimeBreakdown. The components are in
+// nanoseconds and add up to the Dispersion of the reading.
+type DispersionBreakdown struct {
+	// Clockd is the dispersion published by clockd, a large value implies
+	// that clockd itself is uncertain.
+	Clockd uint64
+	// Local is the uncertainty accumulated by the local clock since clockd's
+	// reference according to the drift model, including the extra leap second
+	// uncertainty when a leap second is pending. A large value implies that
+	// clockd's record is old, e.g. clockd updates it infrequently.
+	Local uint64
+	// Read is the uncertainty added by the read itself, i.e. the span of the
+	// local clock samples with SampleBracket and the read latency with
+	// WithLatencyInDispersion, it is zero by default.
+	Read uint64
+}
 
-	return uint64(fv)
+// Total returns the sum of all components, saturated at math.MaxUint64.
+func (b DispersionBreakdown) Total() uint64 {
+	return addSaturated(addSaturated(b.Clockd, b.Local), b.Read)
 }
 
-func getDispersion(info ClientInfo, sec uint64, nsec uint32) uint64 {
+// getDispersion returns the dispersion of the local clock time sec and nsec
+// derived from info. An ErrClockInversion is returned when the local clock
+// time is earlier than clockd's reference, e.g. the two clocks momentarily
+// disagree.
+func getDispersion(info ClientInfo,
+	sec uint64, nsec uint32, model DriftModel) (uint64, error) {
+	b, err := getDispersionBreakdown(info, sec, nsec, model)
+	if err != nil {
+		return 0, err
+	}
+
+	return b.Total(), nil
+}
+
+// getDispersionBreakdown is similar to getDispersion, but it returns the
+// components of the dispersion separately, Read is always zero.
+func getDispersionBreakdown(info ClientInfo,
+	sec uint64, nsec uint32, model DriftModel) (DispersionBreakdown, error) {
 	current := UnixTime{
 		Sec:  sec,
 		NSec: nsec,
@@ -95,11 +487,16 @@ func getDispersion(info ClientInfo, sec uint64, nsec uint32) uint64 {
 	}
 	ns := current.Sub(ref)
 	if ns < 0 {
-		panic("invalid client info and clock time")
+		return DispersionBreakdown{}, fmt.Errorf(
+			"%w: local clock %s behind the reference",
+			ErrClockInversion, time.Duration(-ns))
+	}
+	uct := model.Uncertainty(ns)
+	if info.LeapState.Pending() && nearLeapSecond(sec, nsec) {
+		uct = addSaturated(uct, leapSecondUncertainty)
 	}
-	uct := GetClockUncertainty(ns)
 
-	return info.Dispersion + uct
+	return DispersionBreakdown{Clockd: info.Dispersion, Local: uct}, nil
 }
 
 func getSysClockTime() (uint64, uint32) {
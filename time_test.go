@@ -15,9 +15,15 @@
 package thymef
 
 import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestIsEmpty(t *testing.T) {
@@ -53,6 +59,33 @@ func TestRange(t *testing.T) {
 	assert.Equal(t, uint64(2000100208), upper)
 }
 
+func TestBoundsClamped(t *testing.T) {
+	tests := []struct {
+		ut    UnixTime
+		lower uint64
+		upper uint64
+	}{
+		{UnixTime{Sec: 0, NSec: 100, Dispersion: 100}, 0, 200},
+		{UnixTime{Sec: 0, NSec: 100, Dispersion: 101}, 0, 201},
+		{UnixTime{Sec: 1, NSec: 100, Dispersion: 3e9}, 0, 4000000100},
+		{UnixTime{Sec: 0, NSec: 0, Dispersion: math.MaxUint64}, 0, math.MaxUint64},
+		{UnixTime{Sec: 1, Dispersion: math.MaxUint64 - 1e9}, 0, math.MaxUint64},
+		{UnixTime{Sec: 1, Dispersion: math.MaxUint64 - 1e9 + 1}, 0, math.MaxUint64},
+	}
+
+	for idx, tt := range tests {
+		lower, upper := tt.ut.Bounds()
+		assert.Equal(t, tt.lower, lower, idx)
+		assert.Equal(t, tt.upper, upper, idx)
+	}
+
+	// a wrapped lower bound used to make a reading near the epoch appear to be
+	// after everything
+	ut := UnixTime{Sec: 0, NSec: 100, Dispersion: 1000}
+	assert.False(t, after(ut, UnixTime{Sec: 1}))
+	assert.True(t, before(ut, UnixTime{Sec: 1}))
+}
+
 func TestUnixTimeSub(t *testing.T) {
 	tests := []struct {
 		sec    uint64
@@ -67,6 +100,15 @@ func TestUnixTimeSub(t *testing.T) {
 		{1, 0, 0, 0, 1e9},
 		{0, 100, 1, 100, -1e9},
 		{2, 100, 1, 200, 1e9 - 100},
+		// borrowing from a zero Sec used to underflow
+		{0, 0, 0, 100, -100},
+		{0, 0, 0, 999999999, -999999999},
+		{0, 0, 1, 100, -1e9 - 100},
+		{0, 100, 0, 0, 100},
+		{0, 100, 2, 200, -2e9 - 100},
+		{1, 0, 0, 999999999, 1},
+		{1e9, 0, 1e9 - 1, 1, 1e9 - 1},
+		{1e9 - 1, 1, 1e9, 0, -1e9 + 1},
 	}
 
 	for idx, tt := range tests {
@@ -82,6 +124,49 @@ func TestUnixTimeSub(t *testing.T) {
 	}
 }
 
+func TestUnixTimeAdd(t *testing.T) {
+	tests := []struct {
+		ut     UnixTime
+		d      time.Duration
+		result UnixTime
+	}{
+		{UnixTime{Sec: 1, NSec: 100, Dispersion: 5}, 0,
+			UnixTime{Sec: 1, NSec: 100, Dispersion: 5}},
+		{UnixTime{Sec: 1, NSec: 100}, 200, UnixTime{Sec: 1, NSec: 300}},
+		// carry at the second boundary
+		{UnixTime{Sec: 1, NSec: 999999999}, 1, UnixTime{Sec: 2}},
+		{UnixTime{Sec: 1, NSec: 999999999}, 2500 * time.Millisecond,
+			UnixTime{Sec: 4, NSec: 499999999}},
+		// borrow at the second boundary
+		{UnixTime{Sec: 2}, -1, UnixTime{Sec: 1, NSec: 999999999}},
+		{UnixTime{Sec: 4, NSec: 499999999}, -2500 * time.Millisecond,
+			UnixTime{Sec: 1, NSec: 999999999}},
+		{UnixTime{Sec: 1, NSec: 100}, -time.Second - 100, UnixTime{}},
+		// saturated
+		{UnixTime{Sec: 1, NSec: 100, Dispersion: 5}, -time.Second - 101,
+			UnixTime{Dispersion: 5}},
+		{UnixTime{Sec: 1}, math.MinInt64, UnixTime{}},
+		{UnixTime{Sec: math.MaxUint64}, time.Second,
+			UnixTime{Sec: math.MaxUint64, NSec: 999999999}},
+	}
+
+	for idx, tt := range tests {
+		result := tt.ut.Add(tt.d)
+		assert.Equal(t, tt.result, result, idx)
+		if tt.d != math.MinInt64 && result.Sec > 0 && result.Sec < math.MaxUint64 {
+			assert.Equal(t, int64(tt.d), result.Sub(tt.ut), idx)
+		}
+	}
+}
+
+func TestUnixTimeAddDispersion(t *testing.T) {
+	ut := UnixTime{Sec: 1, NSec: 2, Dispersion: 3}
+	assert.Equal(t, UnixTime{Sec: 1, NSec: 2, Dispersion: 10}, ut.AddDispersion(7))
+	assert.Equal(t, uint64(3), ut.Dispersion)
+	assert.Equal(t, uint64(math.MaxUint64),
+		ut.AddDispersion(math.MaxUint64).Dispersion)
+}
+
 func TestGetClockUncertainty(t *testing.T) {
 	tests := []struct {
 		val    int64
@@ -91,6 +176,13 @@ func TestGetClockUncertainty(t *testing.T) {
 		{1e9, 1000000},
 		{1e8, 100000},
 		{1e3, 1},
+		{999, 0},
+		{1e9 + 999, 1000000},
+		// nanosecond * MaxClockDrift used to overflow int64 from here
+		{math.MaxInt64 / MaxClockDrift, 9223372036},
+		{math.MaxInt64/MaxClockDrift + 1, 9223372036},
+		{3 * 3600 * 1e9, 10800000000},
+		{math.MaxInt64, 9223372036854775},
 	}
 
 	for idx, tt := range tests {
@@ -98,19 +190,145 @@ func TestGetClockUncertainty(t *testing.T) {
 	}
 }
 
-func TestGetDispersionWithInvalidInput(t *testing.T) {
-	defer func() {
-		r := recover()
-		if r == nil {
-			t.Fail()
+func TestGetClockUncertaintyIsMonotonic(t *testing.T) {
+	boundary := math.MaxInt64 / MaxClockDrift
+	prev := GetClockUncertainty(boundary - 1e4)
+	for ns := boundary - 1e4; ns < boundary+1e4; ns += 7 {
+		v := GetClockUncertainty(ns)
+		assert.GreaterOrEqual(t, v, prev, ns)
+		prev = v
+	}
+	for _, drift := range []int64{1, MaxClockDrift, maxClockDriftCeiling} {
+		prev = 0
+		for ns := int64(1); ns > 0 && ns < math.MaxInt64/2; ns *= 3 {
+			v := getClockUncertainty(ns, drift)
+			assert.GreaterOrEqual(t, v, prev, ns)
+			prev = v
 		}
-	}()
+	}
+	assert.Equal(t, uint64(92233720368547758),
+		getClockUncertainty(math.MaxInt64, maxClockDriftCeiling))
+}
 
+// dispersionOf returns the dispersion derived from info for the local clock
+// time sec and nsec with the default DriftModel.
+func dispersionOf(t testing.TB, info ClientInfo, sec uint64, nsec uint32) uint64 {
+	t.Helper()
+	d, err := getDispersion(info, sec, nsec, LinearDriftModel(MaxClockDrift))
+	require.NoError(t, err)
+	return d
+}
+
+func TestGetDispersionWithClockInversion(t *testing.T) {
 	info := ClientInfo{
 		Sec:  2,
 		NSec: 0,
 	}
-	getDispersion(info, 1, 0)
+	_, err := getDispersion(info, 1, 0, LinearDriftModel(MaxClockDrift))
+	assert.ErrorIs(t, err, ErrClockInversion)
+	assert.ErrorIs(t, err, ErrNotReady)
+	assert.Contains(t, err.Error(), "1s behind")
+}
+
+func TestGetDispersionDuringPendingLeapSecond(t *testing.T) {
+	// 2016-12-31, a leap second was inserted at its end
+	const day = 17166 * secondsPerDay
+	noon := ClientInfo{Sec: day + 12*3600, Dispersion: 1000}
+	late := ClientInfo{Sec: day + 86399, Dispersion: 1000}
+	none := dispersionOf(t, noon, noon.Sec, 5e8)
+	assert.Equal(t, none, dispersionOf(t, late, late.Sec, 5e8))
+	for _, s := range []LeapState{LeapNone, LeapSmearing} {
+		late.LeapState = s
+		assert.Equal(t, none, dispersionOf(t, late, late.Sec, 5e8), s)
+	}
+	for _, s := range []LeapState{LeapPendingInsert, LeapPendingDelete} {
+		// readings at noon of the pending day are not widened
+		noon.LeapState = s
+		assert.Equal(t, none, dispersionOf(t, noon, noon.Sec, 5e8), s)
+		// readings at 23:59:59.5 are
+		late.LeapState = s
+		assert.Equal(t, none+leapSecondUncertainty,
+			dispersionOf(t, late, late.Sec, 5e8), s)
+		// so are readings right after midnight
+		assert.Equal(t, GetClockUncertainty(1999999999)+1000+leapSecondUncertainty,
+			dispersionOf(t, late, late.Sec+1, 999999999), s)
+		assert.Equal(t, GetClockUncertainty(2e9)+1000,
+			dispersionOf(t, late, late.Sec+2, 0), s)
+	}
+}
+
+func TestDispersionBreakdownTotalSaturates(t *testing.T) {
+	b := DispersionBreakdown{Clockd: math.MaxUint64 - 1, Local: 2}
+	assert.Equal(t, uint64(math.MaxUint64), b.Total())
+	b = DispersionBreakdown{Clockd: math.MaxUint64 - 1, Read: 2}
+	assert.Equal(t, uint64(math.MaxUint64), b.Total())
+	b = DispersionBreakdown{Clockd: math.MaxUint64 - 3, Local: 1, Read: 1}
+	assert.Equal(t, uint64(math.MaxUint64-1), b.Total())
+	// a huge dispersion published by clockd never turns into a tiny one
+	info := ClientInfo{Sec: 100, Dispersion: math.MaxUint64 - 1}
+	assert.Equal(t, uint64(math.MaxUint64), dispersionOf(t, info, 101, 0))
+}
+
+func TestLeapSecondUncertaintySaturates(t *testing.T) {
+	model := DriftModelFunc(func(int64) uint64 {
+		return math.MaxUint64 - 1
+	})
+	info := ClientInfo{Sec: secondsPerDay - 1, LeapState: LeapPendingInsert}
+	b, err := getDispersionBreakdown(info, secondsPerDay, 0, model)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(math.MaxUint64), b.Local)
+}
+
+func TestNearLeapSecond(t *testing.T) {
+	const day = 17166 * secondsPerDay
+	tests := []struct {
+		sec  uint64
+		nsec uint32
+		want bool
+	}{
+		{day + 12*3600, 0, false},
+		{day + 86398, 999999999, false},
+		{day + 86399, 0, true},
+		{day + 86399, 500000000, true},
+		{day + 86400, 0, true},
+		{day + 86400, 999999999, true},
+		{day + 86401, 0, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, nearLeapSecond(tt.sec, tt.nsec), tt)
+	}
+}
+
+func TestCommitTimestamp(t *testing.T) {
+	ut := UnixTime{Sec: 100, NSec: 500, Dispersion: 1000}
+	_, upper := ut.Bounds()
+	assert.Equal(t, upper, ut.CommitTimestamp())
+	assert.Equal(t, uint64(100*1e9+1500), ut.CommitTimestamp())
+	ut.Dispersion = math.MaxUint64
+	assert.Equal(t, uint64(math.MaxUint64), ut.CommitTimestamp())
+}
+
+func TestGetDispersionBreakdown(t *testing.T) {
+	model := LinearDriftModel(MaxClockDrift)
+	info := ClientInfo{Sec: 100, Dispersion: 1000}
+	b, err := getDispersionBreakdown(info, 101, 0, model)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1000), b.Clockd)
+	assert.Equal(t, model.Uncertainty(1e9), b.Local)
+	assert.Zero(t, b.Read)
+	assert.Equal(t, dispersionOf(t, info, 101, 0), b.Total())
+	// the leap second uncertainty is accounted as local
+	info.LeapState = LeapPendingInsert
+	b, err = getDispersionBreakdown(info, 101, 0, model)
+	require.NoError(t, err)
+	assert.Equal(t, model.Uncertainty(1e9), b.Local)
+	info.Sec = secondsPerDay - 1
+	b, err = getDispersionBreakdown(info, secondsPerDay, 0, model)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1000), b.Clockd)
+	assert.Equal(t, model.Uncertainty(1e9)+leapSecondUncertainty, b.Local)
+	_, err = getDispersionBreakdown(info, 99, 0, model)
+	assert.ErrorIs(t, err, ErrClockInversion)
 }
 
 func TestGetDispersion(t *testing.T) {
@@ -133,7 +351,337 @@ func TestGetDispersion(t *testing.T) {
 			NSec:       tt.nsec,
 			Dispersion: tt.dispersion,
 		}
-		result := getDispersion(info, tt.oSec, tt.oNsec)
+		result := dispersionOf(t, info, tt.oSec, tt.oNsec)
 		assert.Equal(t, tt.result, result, idx)
 	}
 }
+
+func TestLogFields(t *testing.T) {
+	ut := UnixTime{
+		Sec:        1714564800,
+		NSec:       125000000,
+		Dispersion: 25000000,
+	}
+	lower, upper := ut.Bounds()
+	attrs := make(map[string]slog.Value)
+	for _, attr := range ut.LogFields() {
+		attrs[attr.Key] = attr.Value
+	}
+	assert.Len(t, attrs, 4)
+	assert.Equal(t, int64(lower), attrs["earliest"].Time().UnixNano())
+	assert.Equal(t, int64(upper), attrs["latest"].Time().UnixNano())
+	assert.Equal(t, time.Unix(1714564800, 100000000).UTC(), attrs["earliest"].Time())
+	assert.Equal(t, time.Unix(1714564800, 150000000).UTC(), attrs["latest"].Time())
+	assert.Equal(t, time.Unix(1714564800, 125000000).UTC(), attrs["midpoint"].Time())
+	assert.Equal(t, 25*time.Millisecond, attrs["dispersion"].Duration())
+}
+
+func TestCombineReadings(t *testing.T) {
+	first := UnixTime{
+		Sec:        100,
+		NSec:       999999500,
+		Dispersion: 1000,
+	}
+	second := UnixTime{
+		Sec:        101,
+		NSec:       1100,
+		Dispersion: 1000,
+	}
+	model := LinearDriftModel(MaxClockDrift)
+	result, ok, err := CombineReadings(first, second, time.Microsecond, model)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	// first shifted to 101.000000500 +/- 1001, second is 101.000001100 +/- 1000,
+	// the odd width intersection is widened by 1ns when rounding
+	lower, upper := result.Bounds()
+	assert.Equal(t, uint64(101000000099), lower)
+	assert.Equal(t, uint64(101000001501), upper)
+	assert.Less(t, result.Dispersion, first.Dispersion)
+	assert.Less(t, result.Dispersion, second.Dispersion)
+	assert.Less(t, result.NSec, uint32(1e9))
+
+	second.NSec = 10000
+	_, ok, err = CombineReadings(first, second, time.Microsecond, model)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, _, err = CombineReadings(first, second, -time.Microsecond, model)
+	assert.ErrorIs(t, err, ErrNegativeElapsed)
+
+	// the uncertainty of the model exceeding the first reading is clamped at
+	// the Unix epoch rather than wrapped around
+	first = UnixTime{NSec: 500, Dispersion: 100}
+	second = UnixTime{NSec: 1000, Dispersion: 1000}
+	result, ok, err = CombineReadings(first, second, time.Microsecond,
+		AffineDriftModel{Base: 10000})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, UnixTime{NSec: 1000, Dispersion: 1000}, result)
+
+	// huge dispersions saturate rather than wrap around
+	first = UnixTime{Sec: 1, Dispersion: math.MaxUint64}
+	second = UnixTime{Sec: 1, Dispersion: 1000}
+	result, ok, err = CombineReadings(first, second, time.Microsecond, model)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, second, result)
+}
+
+func TestDefinitelyBeforeAfterAndIndeterminate(t *testing.T) {
+	at := func(ns uint64, dispersion uint64) UnixTime {
+		return UnixTime{Sec: ns / 1e9, NSec: uint32(ns % 1e9), Dispersion: dispersion}
+	}
+	tests := []struct {
+		a      UnixTime
+		b      UnixTime
+		before bool
+		after  bool
+	}{
+		// disjoint
+		{at(10e9, 100), at(11e9, 100), true, false},
+		{at(11e9, 100), at(10e9, 100), false, true},
+		{at(10e9, 0), at(10e9+1, 0), true, false},
+		// touching, [10e9-100, 10e9+100] and [10e9+100, 10e9+300]
+		{at(10e9, 100), at(10e9+200, 100), false, false},
+		{at(10e9+200, 100), at(10e9, 100), false, false},
+		// overlapping
+		{at(10e9, 100), at(10e9+150, 100), false, false},
+		{at(10e9, 1e9), at(10e9+1, 0), false, false},
+		// identical
+		{at(10e9, 0), at(10e9, 0), false, false},
+		{at(10e9, 100), at(10e9, 100), false, false},
+	}
+	for idx, tt := range tests {
+		assert.Equal(t, tt.before, tt.a.DefinitelyBefore(tt.b), idx)
+		assert.Equal(t, tt.after, tt.a.DefinitelyAfter(tt.b), idx)
+		assert.Equal(t, !tt.before && !tt.after, tt.a.Indeterminate(tt.b), idx)
+		assert.Equal(t, tt.a.Indeterminate(tt.b), tt.b.Indeterminate(tt.a), idx)
+	}
+}
+
+func TestOverlapAndIntersect(t *testing.T) {
+	tests := []struct {
+		name    string
+		a       UnixTime
+		b       UnixTime
+		overlap bool
+		lower   uint64
+		upper   uint64
+	}{
+		{"disjoint", UnixTime{Sec: 1, Dispersion: 10},
+			UnixTime{Sec: 1, NSec: 30, Dispersion: 10}, false, 0, 0},
+		{"touching", UnixTime{Sec: 1, Dispersion: 10},
+			UnixTime{Sec: 1, NSec: 20, Dispersion: 10}, true, 1e9 + 10, 1e9 + 10},
+		{"nested", UnixTime{Sec: 1, Dispersion: 100},
+			UnixTime{Sec: 1, NSec: 10, Dispersion: 20}, true, 1e9 - 10, 1e9 + 30},
+		{"identical", UnixTime{Sec: 1, NSec: 5, Dispersion: 5},
+			UnixTime{Sec: 1, NSec: 5, Dispersion: 5}, true, 1e9, 1e9 + 10},
+		{"partial", UnixTime{Sec: 1, Dispersion: 100},
+			UnixTime{Sec: 1, NSec: 150, Dispersion: 100}, true, 1e9 + 50, 1e9 + 100},
+		{"across second", UnixTime{Sec: 1, NSec: 999999990, Dispersion: 20},
+			UnixTime{Sec: 2, NSec: 5, Dispersion: 10}, true, 2e9 - 5, 2e9 + 10},
+		{"odd width", UnixTime{Sec: 1, Dispersion: 10},
+			UnixTime{Sec: 1, NSec: 15, Dispersion: 10}, true, 1e9 + 5, 1e9 + 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.overlap, Overlap(tt.a, tt.b))
+			assert.Equal(t, tt.overlap, Overlap(tt.b, tt.a))
+			ut, ok := Intersect(tt.a, tt.b)
+			assert.Equal(t, tt.overlap, ok)
+			if !ok {
+				assert.Equal(t, UnixTime{}, ut)
+				return
+			}
+			rev, _ := Intersect(tt.b, tt.a)
+			assert.Equal(t, ut, rev)
+			assert.Less(t, ut.NSec, uint32(1e9))
+			lower, upper := ut.Bounds()
+			// the odd width intersection is widened by 1ns when rounding
+			assert.LessOrEqual(t, lower, tt.lower)
+			assert.LessOrEqual(t, tt.lower-lower, uint64(1))
+			assert.Equal(t, tt.upper, upper)
+		})
+	}
+}
+
+func TestFromBounds(t *testing.T) {
+	tests := []struct {
+		lower uint64
+		upper uint64
+	}{
+		{0, 0},
+		{10, 20},
+		{10, 21},
+		{999999999, 1000000001},
+		{1999999999, 2000000000},
+	}
+
+	for idx, tt := range tests {
+		ut := fromBounds(tt.lower, tt.upper)
+		assert.Less(t, ut.NSec, uint32(1e9), idx)
+		lower, upper := ut.Bounds()
+		assert.LessOrEqual(t, lower, tt.lower, idx)
+		assert.Equal(t, tt.upper, upper, idx)
+		assert.LessOrEqual(t, tt.lower-lower, uint64(1), idx)
+	}
+}
+
+func TestToTimeAndFromTime(t *testing.T) {
+	inputs := []UnixTime{
+		{Sec: 0, NSec: 1},
+		{Sec: 1, NSec: 999999999},
+		{Sec: 1714564800, NSec: 123456789, Dispersion: 100},
+		{Sec: maxTimeSec, NSec: 999999999},
+	}
+	for _, input := range inputs {
+		v := FromTime(input.ToTime())
+		assert.Equal(t, input.Sec, v.Sec)
+		assert.Equal(t, input.NSec, v.NSec)
+		assert.Equal(t, uint64(0), v.Dispersion)
+	}
+
+	tt := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)
+	assert.True(t, tt.Equal(FromTime(tt).ToTime()))
+	assert.Equal(t, UnixTime{}, FromTime(time.Unix(-1, 0)))
+
+	saturated := UnixTime{Sec: math.MaxUint64, NSec: 1}.ToTime()
+	assert.Equal(t, int64(maxTimeSec), saturated.Unix())
+	assert.True(t, saturated.After(time.Unix(1714564800, 0)))
+}
+
+func TestUnixTimeString(t *testing.T) {
+	ut := UnixTime{Sec: 1714564800, NSec: 123456789, Dispersion: 8}
+	assert.Equal(t, "2024-05-01T12:00:00.123456789Z ±8ns", ut.String())
+	ut = UnixTime{Sec: 1714564800, NSec: 100, Dispersion: 1500}
+	assert.Equal(t, "2024-05-01T12:00:00.000000100Z ±1.5µs", ut.String())
+	ut.Dispersion = 2000000
+	assert.Equal(t, "2024-05-01T12:00:00.000000100Z ±2ms", ut.String())
+	// large dispersions stay in ms rather than switching to h/m/s
+	ut.Dispersion = uint64(90 * time.Minute)
+	assert.Equal(t, "2024-05-01T12:00:00.000000100Z ±5400000ms", ut.String())
+	ut.Dispersion = 12345678
+	assert.Equal(t, "2024-05-01T12:00:00.000000100Z ±12.346ms", ut.String())
+	assert.Equal(t, ut.String(), fmt.Sprint(ut))
+}
+
+func TestDispersionDuration(t *testing.T) {
+	ut := UnixTime{Dispersion: 1500}
+	assert.Equal(t, 1500*time.Nanosecond, ut.DispersionDuration())
+	ut.Dispersion = math.MaxInt64
+	assert.Equal(t, time.Duration(math.MaxInt64), ut.DispersionDuration())
+	ut.Dispersion = math.MaxInt64 + 1
+	assert.Equal(t, time.Duration(math.MaxInt64), ut.DispersionDuration())
+	ut.Dispersion = math.MaxUint64
+	assert.Equal(t, time.Duration(math.MaxInt64), ut.DispersionDuration())
+}
+
+func TestDispersionString(t *testing.T) {
+	tests := []struct {
+		dispersion uint64
+		want       string
+	}{
+		{0, "0ns"},
+		{999, "999ns"},
+		{1000, "1µs"},
+		{1500, "1.5µs"},
+		{1234, "1.234µs"},
+		{999999, "999.999µs"},
+		{1000000, "1ms"},
+		{1000001, "1ms"},
+		{12345678, "12.346ms"},
+		{2000000000, "2000ms"},
+		{math.MaxUint64, "18446744073709.551ms"},
+	}
+	for _, tt := range tests {
+		ut := UnixTime{Dispersion: tt.dispersion}
+		assert.Equal(t, tt.want, ut.DispersionString(), tt.dispersion)
+	}
+}
+
+func TestBoundsTime(t *testing.T) {
+	ut := UnixTime{Sec: 1714564800, NSec: 500, Dispersion: 1000}
+	lower, upper := ut.BoundsTime()
+	assert.Equal(t, time.Unix(1714564799, 999999500), lower)
+	assert.Equal(t, time.Unix(1714564800, 1500), upper)
+}
+
+func TestIntervalAndWidth(t *testing.T) {
+	ut := UnixTime{Sec: 1714564800, NSec: 500, Dispersion: 1000}
+	lower, upper := ut.Interval()
+	bl, bu := ut.BoundsTime()
+	assert.Equal(t, bl, lower)
+	assert.Equal(t, bu, upper)
+	assert.Equal(t, 2*time.Microsecond, ut.Width())
+	assert.Equal(t, upper.Sub(lower), ut.Width())
+
+	tests := []struct {
+		dispersion uint64
+		width      time.Duration
+	}{
+		{0, 0},
+		{1, 2},
+		{math.MaxInt64 / 2, math.MaxInt64 - 1},
+		{math.MaxInt64/2 + 1, math.MaxInt64},
+		{math.MaxInt64, math.MaxInt64},
+		{math.MaxUint64, math.MaxInt64},
+	}
+	for idx, tt := range tests {
+		ut := UnixTime{Sec: 1, Dispersion: tt.dispersion}
+		assert.Equal(t, tt.width, ut.Width(), idx)
+	}
+}
+
+func TestUnixTimeJSON(t *testing.T) {
+	ut := UnixTime{Sec: 1714564800, NSec: 123456789, Dispersion: 8}
+	data, err := json.Marshal(ut)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"sec":1714564800,"nsec":123456789,"dispersion":8,`+
+		`"time":"2024-05-01T12:00:00.123456789Z",`+
+		`"bounds":[1714564800123456781,1714564800123456797]}`, string(data))
+
+	var v UnixTime
+	assert.NoError(t, json.Unmarshal(data, &v))
+	assert.Equal(t, ut, v)
+
+	// derived fields are ignored
+	data = []byte(`{"sec":1,"nsec":2,"dispersion":3,"time":"bogus","bounds":[0,0]}`)
+	assert.NoError(t, json.Unmarshal(data, &v))
+	assert.Equal(t, UnixTime{Sec: 1, NSec: 2, Dispersion: 3}, v)
+
+	malformed := []string{
+		`{`,
+		`[]`,
+		`{"sec":"1","nsec":2,"dispersion":3}`,
+		`{"sec":-1,"nsec":2,"dispersion":3}`,
+		`{"nsec":2,"dispersion":3}`,
+		`{"sec":1,"dispersion":3}`,
+		`{"sec":1,"nsec":2}`,
+		`{"sec":1,"nsec":1000000000,"dispersion":3}`,
+	}
+	for _, input := range malformed {
+		v = UnixTime{Sec: 100}
+		assert.Error(t, json.Unmarshal([]byte(input), &v), input)
+		assert.Equal(t, UnixTime{Sec: 100}, v, input)
+	}
+}
+
+func TestClientInfoJSON(t *testing.T) {
+	info := ClientInfo{
+		Valid:      true,
+		Locked:     true,
+		Count:      3,
+		Dispersion: 4,
+		Sec:        5,
+		NSec:       6,
+		LeapState:  LeapPendingInsert,
+	}
+	data, err := json.Marshal(info)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"valid":true,"locked":true,"count":3,`+
+		`"dispersion":4,"sec":5,"nsec":6,"seq":0,"leap_state":2}`, string(data))
+	var v ClientInfo
+	assert.NoError(t, json.Unmarshal(data, &v))
+	assert.Equal(t, info, v)
+}
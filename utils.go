@@ -14,9 +14,21 @@
 
 package thymef
 
+import "math"
+
 func FirstError(err1 error, err2 error) error {
 	if err1 != nil {
 		return err1
 	}
 	return err2
 }
+
+// addSaturated returns a + b saturated at math.MaxUint64 rather than wrapped
+// around, so an overflowing dispersion never turns into a tiny one.
+func addSaturated(a uint64, b uint64) uint64 {
+	if a > math.MaxUint64-b {
+		return math.MaxUint64
+	}
+
+	return a + b
+}